@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"log"
 	"os"
+	"path/filepath"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -12,16 +13,18 @@ import (
 
 var DB *sql.DB
 
+// DataDir 数据目录，存放数据库和日志文件
+const DataDir = "/app/data"
+
 func InitDB() {
 	var err error
 
 	// 确保数据目录存在
-	dataDir := "/app/data"
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+	if err := os.MkdirAll(DataDir, 0755); err != nil {
 		log.Fatal("Failed to create data directory:", err)
 	}
 
-	DB, err = sql.Open("sqlite3", "/app/data/runme.db")
+	DB, err = sql.Open("sqlite3", filepath.Join(DataDir, "runme.db"))
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
@@ -31,6 +34,7 @@ func InitDB() {
 	}
 
 	createAllTables()
+	migrateTables()
 	createDefaultAdmin() // 创建默认管理员账户
 	log.Println("Database initialized successfully")
 }
@@ -243,6 +247,192 @@ func createAllTables() {
 	);
 	`
 
+	// 创建主机变量表
+	hostVarTable := `
+	CREATE TABLE IF NOT EXISTS host_vars (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (host_id) REFERENCES hosts(id) ON DELETE CASCADE,
+		UNIQUE(host_id, key)
+	);
+	`
+
+	// 创建主机组变量表
+	hostGroupVarTable := `
+	CREATE TABLE IF NOT EXISTS host_group_vars (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host_group_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (host_group_id) REFERENCES host_groups(id) ON DELETE CASCADE,
+		UNIQUE(host_group_id, key)
+	);
+	`
+
+	// 创建版本记录表
+	revisionTable := `
+	CREATE TABLE IF NOT EXISTS revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		resource_type TEXT NOT NULL,
+		resource_id INTEGER NOT NULL,
+		revision INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		snapshot TEXT NOT NULL,
+		author TEXT NOT NULL DEFAULT '',
+		message TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(resource_type, resource_id, revision)
+	);
+	`
+
+	// 创建定时执行计划表
+	scheduleTable := `
+	CREATE TABLE IF NOT EXISTS schedules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		target_type TEXT NOT NULL,
+		target_id INTEGER NOT NULL,
+		cron_expr TEXT NOT NULL,
+		payload TEXT NOT NULL DEFAULT '',
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		last_run_at DATETIME,
+		next_run_at DATETIME,
+		last_status TEXT NOT NULL DEFAULT '',
+		last_message TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+
+	// 创建工作流表，steps为步骤定义（JSON）
+	workflowTable := `
+	CREATE TABLE IF NOT EXISTS workflows (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		steps TEXT NOT NULL DEFAULT '[]',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+
+	// 创建工作流运行记录表
+	workflowRunTable := `
+	CREATE TABLE IF NOT EXISTS workflow_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		workflow_id INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		triggered_by TEXT NOT NULL DEFAULT '',
+		started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		finished_at DATETIME,
+		FOREIGN KEY (workflow_id) REFERENCES workflows(id)
+	);
+	`
+
+	// 创建工作流步骤运行记录表
+	workflowStepRunTable := `
+	CREATE TABLE IF NOT EXISTS workflow_step_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		run_id INTEGER NOT NULL,
+		step_key TEXT NOT NULL,
+		step_name TEXT NOT NULL DEFAULT '',
+		step_type TEXT NOT NULL,
+		target_id INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		started_at DATETIME,
+		finished_at DATETIME,
+		FOREIGN KEY (run_id) REFERENCES workflow_runs(id)
+	);
+	`
+
+	// 创建日志文件表，记录超过阈值后写入磁盘的执行输出（gzip压缩）
+	logFileTable := `
+	CREATE TABLE IF NOT EXISTS log_files (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		log_type TEXT NOT NULL,
+		log_id INTEGER NOT NULL,
+		stream TEXT NOT NULL,
+		path TEXT NOT NULL,
+		size INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (log_type, log_id, stream)
+	);
+	`
+
+	// 创建执行历史保留策略表
+	retentionPolicyTable := `
+	CREATE TABLE IF NOT EXISTS retention_policies (
+		log_type TEXT PRIMARY KEY,
+		max_age_days INTEGER NOT NULL DEFAULT 0,
+		max_sessions INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+
+	// 创建Ansible任务结果表，记录每个主机上每个任务的结果
+	ansibleTaskResultTable := `
+	CREATE TABLE IF NOT EXISTS ansible_task_results (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		log_id INTEGER NOT NULL,
+		host TEXT NOT NULL,
+		play TEXT NOT NULL DEFAULT '',
+		task TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		started_at DATETIME,
+		finished_at DATETIME,
+		FOREIGN KEY (log_id) REFERENCES ansible_execution_logs(id)
+	);
+	`
+
+	// 创建Playbook项目文件表，保存roles、templates、group_vars等文件
+	ansiblePlaybookFileTable := `
+	CREATE TABLE IF NOT EXISTS ansible_playbook_files (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		playbook_id INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		content BLOB NOT NULL,
+		mode INTEGER NOT NULL DEFAULT 420,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (playbook_id, path),
+		FOREIGN KEY (playbook_id) REFERENCES ansible_playbooks(id)
+	);
+	`
+
+	// 创建凭据表，保存Vault密码等敏感信息，secret不通过接口返回
+	credentialTable := `
+	CREATE TABLE IF NOT EXISTS credentials (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		type TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+
+	// 创建部署制品表，制品文件保存在数据目录的artifacts下
+	deploymentArtifactTable := `
+	CREATE TABLE IF NOT EXISTS deployment_artifacts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL,
+		session_name TEXT NOT NULL,
+		release TEXT NOT NULL,
+		commit_sha TEXT NOT NULL DEFAULT '',
+		commit_message TEXT NOT NULL DEFAULT '',
+		path TEXT NOT NULL,
+		size INTEGER NOT NULL DEFAULT 0,
+		checksum TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (task_id, release),
+		FOREIGN KEY (task_id) REFERENCES deployment_tasks(id)
+	);
+	`
+
 	// 按顺序创建所有表
 	tables := []string{
 		usersTable,
@@ -260,6 +450,19 @@ func createAllTables() {
 		certificateTable,
 		certificateLogTable,
 		dockerTemplateTable,
+		hostVarTable,
+		hostGroupVarTable,
+		revisionTable,
+		scheduleTable,
+		workflowTable,
+		workflowRunTable,
+		workflowStepRunTable,
+		logFileTable,
+		retentionPolicyTable,
+		ansibleTaskResultTable,
+		ansiblePlaybookFileTable,
+		credentialTable,
+		deploymentArtifactTable,
 	}
 
 	for _, table := range tables {
@@ -271,6 +474,121 @@ func createAllTables() {
 	log.Println("All tables created successfully")
 }
 
+// migrateTables 为已存在的表补充新增字段（SQLite不支持ADD COLUMN IF NOT EXISTS）
+func migrateTables() {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"scripts", "parameters", "TEXT NOT NULL DEFAULT '[]'"},
+		{"execution_sessions", "parameters", "TEXT NOT NULL DEFAULT '{}'"},
+		{"hosts", "hostname", "TEXT NOT NULL DEFAULT ''"},
+		{"hosts", "tags", "TEXT NOT NULL DEFAULT ''"},
+		{"execution_sessions", "revision", "INTEGER NOT NULL DEFAULT 0"},
+		{"ansible_execution_sessions", "revision", "INTEGER NOT NULL DEFAULT 0"},
+		{"hosts", "become_password", "TEXT NOT NULL DEFAULT ''"},
+		{"scripts", "run_as", "TEXT NOT NULL DEFAULT ''"},
+		{"docker_templates", "run_as", "TEXT NOT NULL DEFAULT ''"},
+		// 已有的部署任务依赖sudo创建部署目录，添加字段时回填为root保持兼容；
+		// 新建任务由接口写入run_as，为空时使用登录用户
+		{"deployment_tasks", "run_as", "TEXT NOT NULL DEFAULT 'root'"},
+		{"scripts", "success_exit_codes", "TEXT NOT NULL DEFAULT '0'"},
+		{"scripts", "warning_exit_codes", "TEXT NOT NULL DEFAULT ''"},
+		{"execution_logs", "session_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"execution_logs", "stdout", "TEXT NOT NULL DEFAULT ''"},
+		{"execution_logs", "stderr", "TEXT NOT NULL DEFAULT ''"},
+		{"execution_logs", "exit_code", "INTEGER"},
+		{"execution_logs", "started_at", "DATETIME"},
+		{"execution_logs", "finished_at", "DATETIME"},
+		{"execution_logs", "duration_ms", "INTEGER NOT NULL DEFAULT 0"},
+		{"ansible_execution_logs", "session_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"ansible_execution_logs", "ok_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"ansible_execution_logs", "changed_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"ansible_execution_logs", "failed_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"ansible_execution_logs", "skipped_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"ansible_execution_logs", "unreachable_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"ansible_execution_sessions", "status", "TEXT NOT NULL DEFAULT ''"},
+		{"ansible_execution_sessions", "output", "TEXT NOT NULL DEFAULT ''"},
+		{"ansible_execution_sessions", "finished_at", "DATETIME"},
+		{"ansible_execution_sessions", "options", "TEXT NOT NULL DEFAULT '{}'"},
+		{"ansible_playbooks", "entry_point", "TEXT NOT NULL DEFAULT ''"},
+		{"ansible_playbooks", "source", "TEXT NOT NULL DEFAULT ''"},
+		{"ansible_playbooks", "source_url", "TEXT NOT NULL DEFAULT ''"},
+		{"ansible_playbooks", "source_ref", "TEXT NOT NULL DEFAULT ''"},
+		{"ansible_playbooks", "source_commit", "TEXT NOT NULL DEFAULT ''"},
+		{"ansible_playbooks", "vault_credential_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"deployment_tasks", "strategy", "TEXT NOT NULL DEFAULT 'auto'"},
+		{"deployment_tasks", "deploy_path", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "build_command", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "start_command", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "stop_command", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "env", "TEXT NOT NULL DEFAULT '{}'"},
+		{"deployment_tasks", "ports", "TEXT NOT NULL DEFAULT '[]'"},
+		{"deployment_tasks", "process_manager", "TEXT NOT NULL DEFAULT 'nohup'"},
+		{"deployment_tasks", "service_user", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "restart_policy", "TEXT NOT NULL DEFAULT 'on-failure'"},
+		{"deployment_tasks", "keep_releases", "INTEGER NOT NULL DEFAULT 5"},
+		{"deployment_logs", "service_status", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_logs", "release", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "batch_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"deployment_tasks", "health_check", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "health_target", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "health_timeout", "INTEGER NOT NULL DEFAULT 30"},
+		{"deployment_tasks", "auto_rollback", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"deployment_tasks", "canary", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"deployment_sessions", "status", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_sessions", "release", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "git_credential_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"credentials", "username", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "webhook_enabled", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"deployment_tasks", "webhook_secret", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_sessions", "commit_sha", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_sessions", "ref", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_logs", "commit_sha", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_logs", "commit_message", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_logs", "switched", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"deployment_tasks", "build_mode", "TEXT NOT NULL DEFAULT 'remote'"},
+		{"deployment_tasks", "build_image", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "artifact_path", "TEXT NOT NULL DEFAULT ''"},
+		{"deployment_tasks", "install_command", "TEXT NOT NULL DEFAULT ''"},
+		{"ansible_playbooks", "host_group_ids", "TEXT NOT NULL DEFAULT '[]'"},
+	}
+
+	for _, col := range columns {
+		if err := addColumnIfNotExists(col.table, col.column, col.definition); err != nil {
+			log.Fatalf("Failed to migrate column %s.%s: %v", col.table, col.column, err)
+		}
+	}
+}
+
+// addColumnIfNotExists 当字段不存在时为表添加字段
+func addColumnIfNotExists(table, column, definition string) error {
+	rows, err := DB.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
 // 创建默认管理员账户
 func createDefaultAdmin() {
 	// 检查是否已存在管理员账户
@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"database/sql"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"runme-backend/database"
 	"runme-backend/models"
 	"runme-backend/services"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,7 +20,8 @@ import (
 // GetAnsiblePlaybooks 获取所有Ansible Playbook
 func GetAnsiblePlaybooks(c *gin.Context) {
 	rows, err := database.DB.Query(`
-		SELECT ap.id, ap.name, ap.content, ap.variables, ap.host_group_id, ap.created_at, ap.updated_at, hg.name as host_group_name
+		SELECT ap.id, ap.name, ap.content, ap.variables, ap.host_group_id, ap.host_group_ids, ap.entry_point, ap.source, ap.source_url, ap.source_ref,
+		       ap.source_commit, ap.vault_credential_id, ap.created_at, ap.updated_at, hg.name as host_group_name
 		FROM ansible_playbooks ap
 		LEFT JOIN host_groups hg ON ap.host_group_id = hg.id
 	`)
@@ -34,11 +39,17 @@ func GetAnsiblePlaybooks(c *gin.Context) {
 	var playbooks []PlaybookWithHostGroup
 	for rows.Next() {
 		var p PlaybookWithHostGroup
-		err := rows.Scan(&p.ID, &p.Name, &p.Content, &p.Variables, &p.HostGroupID, &p.CreatedAt, &p.UpdatedAt, &p.HostGroupName)
+		var hostGroupIDs string
+		err := rows.Scan(&p.ID, &p.Name, &p.Content, &p.Variables, &p.HostGroupID, &hostGroupIDs, &p.EntryPoint, &p.Source, &p.SourceURL, &p.SourceRef,
+			&p.SourceCommit, &p.VaultCredentialID, &p.CreatedAt, &p.UpdatedAt, &p.HostGroupName)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		if p.HostGroupIDs, err = services.ParseHostGroupIDs(hostGroupIDs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 		playbooks = append(playbooks, p)
 	}
 
@@ -47,18 +58,44 @@ func GetAnsiblePlaybooks(c *gin.Context) {
 
 // CreateAnsiblePlaybook 创建Ansible Playbook
 func CreateAnsiblePlaybook(c *gin.Context) {
-	var playbook models.AnsiblePlaybook
-	if err := c.ShouldBindJSON(&playbook); err != nil {
+	var req struct {
+		models.AnsiblePlaybook
+		RevisionMessage string `json:"revision_message"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	playbook := req.AnsiblePlaybook
+	// 新建的Playbook还没有项目文件，入口在执行时校验
+	entryPoint, err := services.CleanPlaybookEntryPoint(playbook.EntryPoint)
+	if err != nil {
+		respondRunError(c, err)
+		return
+	}
+	playbook.EntryPoint = entryPoint
+	if err := services.ValidateCredentialReference(playbook.VaultCredentialID, services.CredentialTypeVaultPassword); err != nil {
+		respondRunError(c, err)
+		return
+	}
+	if err := services.ValidateHostGroupIDs(playbook.HostGroupIDs); err != nil {
+		respondRunError(c, err)
+		return
+	}
+	// 校验YAML结构和hosts，并执行语法检查
+	if err := services.ValidateAnsiblePlaybook(playbook); err != nil {
+		respondRunError(c, err)
+		return
+	}
 
 	playbook.CreatedAt = time.Now()
 	playbook.UpdatedAt = time.Now()
 
-	result, err := database.DB.Exec(
-		"INSERT INTO ansible_playbooks (name, content, variables, host_group_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
-		playbook.Name, playbook.Content, playbook.Variables, playbook.HostGroupID, playbook.CreatedAt, playbook.UpdatedAt,
+	result, err := database.DB.Exec(`
+		INSERT INTO ansible_playbooks (name, content, variables, host_group_id, host_group_ids, entry_point, vault_credential_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, playbook.Name, playbook.Content, playbook.Variables, playbook.HostGroupID, services.EncodeHostGroupIDs(playbook.HostGroupIDs),
+		playbook.EntryPoint, playbook.VaultCredentialID, playbook.CreatedAt, playbook.UpdatedAt,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -67,6 +104,16 @@ func CreateAnsiblePlaybook(c *gin.Context) {
 
 	id, _ := result.LastInsertId()
 	playbook.ID = int(id)
+
+	// 记录初始版本
+	if req.RevisionMessage == "" {
+		req.RevisionMessage = "Create playbook"
+	}
+	if _, err := services.CreatePlaybookRevision(playbook, c.GetString("username"), req.RevisionMessage); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to record revision: %v", err)})
+		return
+	}
+
 	c.JSON(http.StatusCreated, playbook)
 }
 
@@ -78,24 +125,67 @@ func UpdateAnsiblePlaybook(c *gin.Context) {
 		return
 	}
 
-	var playbook models.AnsiblePlaybook
-	if err := c.ShouldBindJSON(&playbook); err != nil {
+	var req struct {
+		models.AnsiblePlaybook
+		RevisionMessage string `json:"revision_message"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	playbook := req.AnsiblePlaybook
+	if playbook.EntryPoint, err = services.CleanPlaybookEntryPoint(playbook.EntryPoint); err != nil {
+		respondRunError(c, err)
+		return
+	}
+	if err := services.ValidateCredentialReference(playbook.VaultCredentialID, services.CredentialTypeVaultPassword); err != nil {
+		respondRunError(c, err)
+		return
+	}
+	if err := services.ValidateHostGroupIDs(playbook.HostGroupIDs); err != nil {
+		respondRunError(c, err)
+		return
+	}
+	// 校验YAML结构和hosts，并使用已保存的项目文件执行语法检查
+	playbook.ID = id
+	if err := services.ValidateAnsiblePlaybook(playbook); err != nil {
+		respondRunError(c, err)
+		return
+	}
 
 	playbook.UpdatedAt = time.Now()
 
-	_, err = database.DB.Exec(
-		"UPDATE ansible_playbooks SET name = ?, content = ?, variables = ?, host_group_id = ?, updated_at = ? WHERE id = ?",
-		playbook.Name, playbook.Content, playbook.Variables, playbook.HostGroupID, playbook.UpdatedAt, id,
+	result, err := database.DB.Exec(`
+		UPDATE ansible_playbooks SET name = ?, content = ?, variables = ?, host_group_id = ?, host_group_ids = ?, entry_point = ?,
+		                             vault_credential_id = ?, updated_at = ?
+		WHERE id = ?
+	`, playbook.Name, playbook.Content, playbook.Variables, playbook.HostGroupID, services.EncodeHostGroupIDs(playbook.HostGroupIDs),
+		playbook.EntryPoint, playbook.VaultCredentialID, playbook.UpdatedAt, id,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playbook not found"})
+		return
+	}
+
 	playbook.ID = id
+
+	// 每次保存都记录一个不可变版本
+	if _, err := services.CreatePlaybookRevision(playbook, c.GetString("username"), req.RevisionMessage); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to record revision: %v", err)})
+		return
+	}
+
 	c.JSON(http.StatusOK, playbook)
 }
 
@@ -113,6 +203,16 @@ func DeleteAnsiblePlaybook(c *gin.Context) {
 		return
 	}
 
+	if err := services.DeleteRevisions(services.RevisionTypeAnsible, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.DeletePlaybookFiles(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Playbook deleted successfully"})
 }
 
@@ -124,87 +224,37 @@ func ExecuteAnsiblePlaybook(c *gin.Context) {
 		return
 	}
 
-	// 获取playbook信息
-	var playbook models.AnsiblePlaybook
-	err = database.DB.QueryRow(`
-		SELECT id, name, content, variables, host_group_id
-		FROM ansible_playbooks
-		WHERE id = ?
-	`, playbookID).Scan(&playbook.ID, &playbook.Name, &playbook.Content, &playbook.Variables, &playbook.HostGroupID)
-
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Playbook not found"})
+	// 执行选项（可选），如check、tags、limit和extra_vars
+	var opts services.AnsibleRunOptions
+	if err := c.ShouldBindJSON(&opts); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 获取主机组中的所有主机
-	rows, err := database.DB.Query("SELECT ip, port, username, password FROM hosts WHERE host_group_id = ?", playbook.HostGroupID)
+	// 获取playbook信息
+	playbook, err := services.LoadAnsiblePlaybook(playbookID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch hosts"})
-		return
-	}
-	defer rows.Close()
-
-	var hosts []models.Host
-	for rows.Next() {
-		var host models.Host
-		err := rows.Scan(&host.IP, &host.Port, &host.Username, &host.Password)
-		if err != nil {
-			continue
-		}
-		hosts = append(hosts, host)
-	}
-
-	if len(hosts) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid hosts found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playbook not found"})
 		return
 	}
 
-	// 创建执行会话
-	sessionName := fmt.Sprintf("%s_%d", playbook.Name, time.Now().Unix())
-	_, err = database.DB.Exec(
-		"INSERT INTO ansible_execution_sessions (playbook_id, session_name, created_at) VALUES (?, ?, ?)",
-		playbookID, sessionName, time.Now(),
-	)
+	// 获取目标主机并创建执行会话
+	run, err := services.PrepareAnsibleRun(*playbook, opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create execution session"})
+		respondRunError(c, err)
 		return
 	}
 
 	// 异步执行playbook（在当前机器上执行，连接到所有目标主机）
-	go func() {
-		// 在当前机器上执行Ansible，连接到所有主机
-		output, err := services.ExecuteAnsiblePlaybook(hosts, playbook.Content, playbook.Variables)
-
-		// 为每个主机创建执行日志记录
-		for _, host := range hosts {
-			log := models.AnsibleExecutionLog{
-				PlaybookID: playbookID,
-				Host:       host.IP,
-				ExecutedAt: time.Now(),
-			}
-
-			if err != nil {
-				log.Status = "failed"
-				log.Error = err.Error()
-				log.Output = output // 包含错误信息
-			} else {
-				log.Status = "success"
-				log.Output = output // 包含成功的执行输出
-			}
-
-			// 保存执行日志
-			_, saveErr := database.DB.Exec(
-				"INSERT INTO ansible_execution_logs (playbook_id, host, status, output, error, executed_at) VALUES (?, ?, ?, ?, ?, ?)",
-				log.PlaybookID, log.Host, log.Status, log.Output, log.Error, log.ExecutedAt,
-			)
-			if saveErr != nil {
-				fmt.Printf("Failed to save execution log for host %s: %v\n", host.IP, saveErr)
-			}
-		}
-	}()
-
-	c.JSON(http.StatusOK, gin.H{"message": "Playbook execution started", "session_name": sessionName})
+	go run.Execute()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Playbook execution started",
+		"session_id":   run.SessionID,
+		"session_name": run.SessionName,
+		"revision":     run.Revision,
+		"options":      run.Options,
+	})
 }
 
 // GetAnsibleExecutionSessions 获取Ansible执行会话
@@ -216,7 +266,7 @@ func GetAnsibleExecutionSessions(c *gin.Context) {
 	}
 
 	rows, err := database.DB.Query(
-		"SELECT id, playbook_id, session_name, created_at FROM ansible_execution_sessions WHERE playbook_id = ? ORDER BY created_at DESC",
+		"SELECT id, playbook_id, session_name, revision, status, options, created_at, finished_at FROM ansible_execution_sessions WHERE playbook_id = ? ORDER BY created_at DESC",
 		playbookID,
 	)
 	if err != nil {
@@ -228,7 +278,8 @@ func GetAnsibleExecutionSessions(c *gin.Context) {
 	var sessions []models.AnsibleExecutionSession
 	for rows.Next() {
 		var session models.AnsibleExecutionSession
-		err := rows.Scan(&session.ID, &session.PlaybookID, &session.SessionName, &session.CreatedAt)
+		err := rows.Scan(&session.ID, &session.PlaybookID, &session.SessionName, &session.Revision, &session.Status,
+			&session.Options, &session.CreatedAt, &session.FinishedAt)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -261,33 +312,31 @@ func GetAnsibleExecutionLogs(c *gin.Context) {
 	}
 
 	// 首先验证session是否存在
-	var sessionExists bool
+	var sessionID int
 	err = database.DB.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM ansible_execution_sessions WHERE playbook_id = ? AND session_name = ?)",
+		"SELECT id FROM ansible_execution_sessions WHERE playbook_id = ? AND session_name = ? ORDER BY id DESC LIMIT 1",
 		playbookID, decodedSessionName,
-	).Scan(&sessionExists)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
 		return
 	}
-
-	if !sessionExists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 获取该session的所有日志
+	// 获取该session的所有日志，旧日志（session_id为0）按会话创建时间匹配
 	rows, err := database.DB.Query(`
-		SELECT ael.id, ael.playbook_id, ael.host, ael.status, ael.output, ael.error, ael.executed_at
+		SELECT ael.id, ael.playbook_id, ael.session_id, ael.host, ael.status, ael.output, ael.error,
+		       ael.ok_count, ael.changed_count, ael.failed_count, ael.skipped_count, ael.unreachable_count, ael.executed_at
 		FROM ansible_execution_logs ael
-		JOIN ansible_execution_sessions aes ON ael.playbook_id = aes.playbook_id
-		WHERE ael.playbook_id = ? AND aes.session_name = ?
-		AND ael.executed_at >= (
-			SELECT created_at FROM ansible_execution_sessions 
-			WHERE playbook_id = ? AND session_name = ?
-		)
+		WHERE ael.playbook_id = ?
+		AND (ael.session_id = ? OR (ael.session_id = 0 AND ael.executed_at >= (
+			SELECT created_at FROM ansible_execution_sessions WHERE id = ?
+		)))
 		ORDER BY ael.executed_at ASC
-	`, playbookID, decodedSessionName, playbookID, decodedSessionName)
+	`, playbookID, sessionID, sessionID)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -298,7 +347,8 @@ func GetAnsibleExecutionLogs(c *gin.Context) {
 	var logs []models.AnsibleExecutionLog
 	for rows.Next() {
 		var log models.AnsibleExecutionLog
-		err := rows.Scan(&log.ID, &log.PlaybookID, &log.Host, &log.Status, &log.Output, &log.Error, &log.ExecutedAt)
+		err := rows.Scan(&log.ID, &log.PlaybookID, &log.SessionID, &log.Host, &log.Status, &log.Output, &log.Error,
+			&log.OkCount, &log.ChangedCount, &log.FailedCount, &log.SkippedCount, &log.UnreachableCount, &log.ExecutedAt)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -308,3 +358,173 @@ func GetAnsibleExecutionLogs(c *gin.Context) {
 
 	c.JSON(http.StatusOK, logs)
 }
+
+// GetAnsibleTaskResults 获取单个主机执行日志的任务结果
+func GetAnsibleTaskResults(c *gin.Context) {
+	playbookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playbook ID"})
+		return
+	}
+	logID, err := strconv.Atoi(c.Param("logId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid log ID"})
+		return
+	}
+
+	var exists bool
+	err = database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM ansible_execution_logs WHERE id = ? AND playbook_id = ?)",
+		logID, playbookID,
+	).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+		return
+	}
+
+	tasks, err := services.ListAnsibleTaskResults(logID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tasks)
+}
+
+// StreamAnsibleSession 通过WebSocket推送Playbook会话的输出
+// 消息格式与终端相同：output为一行输出，done表示执行结束（data为会话状态），error为错误
+func StreamAnsibleSession(c *gin.Context) {
+	playbookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playbook ID"})
+		return
+	}
+	sessionID, err := strconv.Atoi(c.Param("sessionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	var exists bool
+	err = database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM ansible_execution_sessions WHERE id = ? AND playbook_id = ?)",
+		sessionID, playbookID,
+	).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade websocket: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	send := func(msgType, data string) error {
+		return ws.WriteJSON(TerminalMessage{Type: msgType, Data: data})
+	}
+
+	backlog, lines, unsubscribe, live := services.SubscribeAnsibleStream(int64(sessionID))
+	if !live {
+		// 会话已结束，发送保存的输出
+		var status, output string
+		err := database.DB.QueryRow(
+			"SELECT status, output FROM ansible_execution_sessions WHERE id = ?", sessionID,
+		).Scan(&status, &output)
+		if err != nil {
+			send("error", err.Error())
+			return
+		}
+		if output != "" {
+			for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+				if send("output", line) != nil {
+					return
+				}
+			}
+		}
+		// 输出流在会话结束后才关闭，仍为running说明执行状态未知，不能当作已结束
+		if status == "running" {
+			send("error", "Session is still running but its live output is unavailable")
+			return
+		}
+		send("done", status)
+		return
+	}
+	defer unsubscribe()
+
+	// 客户端断开时停止推送
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, line := range backlog {
+		if send("output", line) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				// 执行结束，或读取过慢被断开
+				var status string
+				database.DB.QueryRow("SELECT status FROM ansible_execution_sessions WHERE id = ?", sessionID).Scan(&status)
+				if status == "running" {
+					send("error", "Stream interrupted, please reconnect")
+				} else {
+					send("done", status)
+				}
+				return
+			}
+			if send("output", line) != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// GetAnsibleInventory 以动态inventory JSON格式（ansible-inventory --list）导出主机组和主机，不包含登录密码
+// 查询参数：group_id（可重复或逗号分隔，默认全部主机组）、host（只返回该主机的变量，对应--host）
+func GetAnsibleInventory(c *gin.Context) {
+	groupIDs, err := services.ParseInventoryGroupIDs(c.QueryArray("group_id"))
+	if err != nil {
+		respondRunError(c, err)
+		return
+	}
+
+	inventory, err := services.BuildAnsibleInventory(groupIDs, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if host, ok := c.GetQuery("host"); ok {
+		vars, found := inventory.HostVars[host]
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Host not found"})
+			return
+		}
+		c.JSON(http.StatusOK, vars)
+		return
+	}
+
+	c.JSON(http.StatusOK, inventory.DynamicInventory())
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"runme-backend/services"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadPlaybookForProject 解析路由中的Playbook ID并加载Playbook，失败时已写入响应
+func loadPlaybookForProject(c *gin.Context) (int, bool) {
+	playbookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playbook ID"})
+		return 0, false
+	}
+	if _, err := services.LoadAnsiblePlaybook(playbookID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playbook not found"})
+		return 0, false
+	}
+	return playbookID, true
+}
+
+// GetPlaybookFiles 获取Playbook的项目文件列表
+func GetPlaybookFiles(c *gin.Context) {
+	playbookID, ok := loadPlaybookForProject(c)
+	if !ok {
+		return
+	}
+
+	files, err := services.ListPlaybookFiles(playbookID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// GetPlaybookFile 下载单个项目文件的原始内容
+func GetPlaybookFile(c *gin.Context) {
+	playbookID, ok := loadPlaybookForProject(c)
+	if !ok {
+		return
+	}
+
+	file, err := services.LoadPlaybookFile(playbookID, playbookFilePath(c))
+	if err != nil {
+		respondPlaybookFileError(c, err)
+		return
+	}
+	c.Header("X-File-Mode", fmt.Sprintf("%04o", file.Mode))
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, path.Base(file.Path)))
+	c.Data(http.StatusOK, "application/octet-stream", file.Content)
+}
+
+// SavePlaybookFile 上传或覆盖项目文件，请求体为文件原始内容
+// 查询参数：mode（八进制权限，如0755，不传时保留原权限）
+func SavePlaybookFile(c *gin.Context) {
+	playbookID, ok := loadPlaybookForProject(c)
+	if !ok {
+		return
+	}
+
+	mode := 0
+	if m := c.Query("mode"); m != "" {
+		parsed, err := strconv.ParseInt(m, 8, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode"})
+			return
+		}
+		mode = int(parsed)
+	}
+
+	content, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, services.PlaybookFileMaxSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("file must not exceed %d bytes", services.PlaybookFileMaxSize)})
+		return
+	}
+
+	file, err := services.SavePlaybookFile(playbookID, playbookFilePath(c), content, mode)
+	if err != nil {
+		respondRunError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, file)
+}
+
+// DeletePlaybookFile 删除项目文件
+func DeletePlaybookFile(c *gin.Context) {
+	playbookID, ok := loadPlaybookForProject(c)
+	if !ok {
+		return
+	}
+
+	found, err := services.DeletePlaybookFile(playbookID, playbookFilePath(c))
+	if err != nil {
+		respondRunError(c, err)
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+}
+
+// ImportPlaybookProject 导入项目文件，替换Playbook原有的全部项目文件
+// multipart请求：archive为tar或tar.gz文件，可选strip_components和entry_point（不传时保留当前入口）
+// JSON请求：git_url、git_ref、entry_point，不传git_url时从上次导入的仓库重新导入
+func ImportPlaybookProject(c *gin.Context) {
+	playbookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playbook ID"})
+		return
+	}
+	playbook, err := services.LoadAnsiblePlaybook(playbookID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playbook not found"})
+		return
+	}
+
+	var result *services.PlaybookImportResult
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.PlaybookProjectMaxSize)
+		header, err := c.FormFile("archive")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "archive file is required"})
+			return
+		}
+		strip := 0
+		if s := c.PostForm("strip_components"); s != "" {
+			if strip, err = strconv.Atoi(s); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid strip_components"})
+				return
+			}
+		}
+		archive, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer archive.Close()
+		entryPoint, ok := c.GetPostForm("entry_point")
+		if !ok {
+			entryPoint = playbook.EntryPoint
+		}
+		result, err = services.ImportPlaybookFromArchive(*playbook, archive, strip, entryPoint)
+		if err != nil {
+			respondRunError(c, err)
+			return
+		}
+	} else {
+		var req struct {
+			GitURL     string  `json:"git_url"`
+			GitRef     string  `json:"git_ref"`
+			EntryPoint *string `json:"entry_point"` // 不传时保留当前入口
+		}
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.GitURL == "" {
+			if playbook.Source != services.PlaybookSourceGit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "git_url is required"})
+				return
+			}
+			req.GitURL = playbook.SourceURL
+			if req.GitRef == "" {
+				req.GitRef = playbook.SourceRef
+			}
+		}
+		entryPoint := playbook.EntryPoint
+		if req.EntryPoint != nil {
+			entryPoint = *req.EntryPoint
+		}
+		result, err = services.ImportPlaybookFromGit(*playbook, req.GitURL, req.GitRef, entryPoint)
+		if err != nil {
+			respondRunError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// playbookFilePath 路由中的文件路径，去掉通配参数开头的斜杠
+func playbookFilePath(c *gin.Context) string {
+	return strings.TrimPrefix(c.Param("path"), "/")
+}
+
+// respondPlaybookFileError 文件不存在时返回404，其余错误按执行错误处理
+func respondPlaybookFileError(c *gin.Context, err error) {
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	respondRunError(c, err)
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"runme-backend/database"
+	"runme-backend/models"
+	"runme-backend/services"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// credentialRequest 创建和更新凭据的请求，secret只写不读
+type credentialRequest struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Username    string `json:"username"`
+	Description string `json:"description"`
+	Secret      string `json:"secret"`
+}
+
+// GetCredentials 获取所有凭据（不含secret）
+func GetCredentials(c *gin.Context) {
+	rows, err := database.DB.Query(
+		"SELECT id, name, type, username, description, created_at, updated_at FROM credentials ORDER BY name",
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	credentials := []models.Credential{}
+	for rows.Next() {
+		var credential models.Credential
+		err := rows.Scan(&credential.ID, &credential.Name, &credential.Type, &credential.Username, &credential.Description,
+			&credential.CreatedAt, &credential.UpdatedAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		credentials = append(credentials, credential)
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+// CreateCredential 创建凭据
+func CreateCredential(c *gin.Context) {
+	var req credentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential := models.Credential{
+		Name:        strings.TrimSpace(req.Name),
+		Type:        req.Type,
+		Username:    strings.TrimSpace(req.Username),
+		Description: req.Description,
+		Secret:      req.Secret,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := services.ValidateCredential(credential, true); err != nil {
+		respondRunError(c, err)
+		return
+	}
+
+	result, err := database.DB.Exec(
+		"INSERT INTO credentials (name, type, username, description, secret, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		credential.Name, credential.Type, credential.Username, credential.Description, credential.Secret, credential.CreatedAt, credential.UpdatedAt,
+	)
+	if err != nil {
+		respondCredentialWriteError(c, err)
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	credential.ID = int(id)
+	c.JSON(http.StatusCreated, credential)
+}
+
+// UpdateCredential 更新凭据，secret为空时保留原值
+func UpdateCredential(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential ID"})
+		return
+	}
+
+	var req credentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := services.LoadCredential(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Credential not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	credential := models.Credential{
+		ID:          id,
+		Name:        strings.TrimSpace(req.Name),
+		Type:        req.Type,
+		Username:    strings.TrimSpace(req.Username),
+		Description: req.Description,
+		Secret:      req.Secret,
+		CreatedAt:   existing.CreatedAt,
+		UpdatedAt:   time.Now(),
+	}
+	if err := services.ValidateCredential(credential, false); err != nil {
+		respondRunError(c, err)
+		return
+	}
+	// 已被引用的凭据不能修改类型
+	if credential.Type != existing.Type {
+		if !ensureCredentialUnused(c, id) {
+			return
+		}
+	}
+	if credential.Secret == "" {
+		credential.Secret = existing.Secret
+	}
+
+	_, err = database.DB.Exec(
+		"UPDATE credentials SET name = ?, type = ?, username = ?, description = ?, secret = ?, updated_at = ? WHERE id = ?",
+		credential.Name, credential.Type, credential.Username, credential.Description, credential.Secret, credential.UpdatedAt, id,
+	)
+	if err != nil {
+		respondCredentialWriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, credential)
+}
+
+// DeleteCredential 删除未被使用的凭据
+func DeleteCredential(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential ID"})
+		return
+	}
+
+	if !ensureCredentialUnused(c, id) {
+		return
+	}
+
+	result, err := database.DB.Exec("DELETE FROM credentials WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Credential not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Credential deleted successfully"})
+}
+
+// ensureCredentialUnused 凭据被引用时返回400，失败时已写入响应
+func ensureCredentialUnused(c *gin.Context, id int) bool {
+	usage, err := services.CredentialUsage(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if len(usage) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   fmt.Sprintf("Credential is in use by %s", strings.Join(usage, ", ")),
+			"used_by": usage,
+		})
+		return false
+	}
+	return true
+}
+
+// respondCredentialWriteError 名称重复时返回400
+func respondCredentialWriteError(c *gin.Context, err error) {
+	if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Credential name already exists"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
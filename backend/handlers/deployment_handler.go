@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"io"
 	"net/http"
 	"runme-backend/database"
 	"runme-backend/models"
 	"runme-backend/services"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,8 +16,12 @@ import (
 // GetDeploymentTasks 获取所有部署任务
 func GetDeploymentTasks(c *gin.Context) {
 	rows, err := database.DB.Query(`
-		SELECT dt.id, dt.name, dt.github_url, dt.branch, dt.host_group_id, 
-		       dt.status, dt.description, dt.created_at, dt.updated_at,
+		SELECT dt.id, dt.name, dt.github_url, dt.git_credential_id, dt.branch, dt.host_group_id, 
+		       dt.status, dt.description, dt.run_as, dt.strategy, dt.deploy_path, dt.build_command,
+		       dt.start_command, dt.stop_command, dt.env, dt.ports, dt.process_manager, dt.service_user,
+		       dt.restart_policy, dt.keep_releases, dt.batch_size, dt.health_check, dt.health_target,
+		       dt.health_timeout, dt.auto_rollback, dt.canary, dt.webhook_enabled, dt.build_mode, dt.build_image,
+		       dt.artifact_path, dt.install_command, dt.created_at, dt.updated_at,
 		       hg.name as host_group_name
 		FROM deployment_tasks dt
 		LEFT JOIN host_groups hg ON dt.host_group_id = hg.id
@@ -30,25 +36,55 @@ func GetDeploymentTasks(c *gin.Context) {
 	var tasks []map[string]interface{}
 	for rows.Next() {
 		var task models.DeploymentTask
-		var hostGroupName string
-		err := rows.Scan(&task.ID, &task.Name, &task.GithubURL, &task.Branch,
-			&task.HostGroupID, &task.Status, &task.Description,
-			&task.CreatedAt, &task.UpdatedAt, &hostGroupName)
+		var hostGroupName, env, ports string
+		err := rows.Scan(&task.ID, &task.Name, &task.GithubURL, &task.GitCredentialID, &task.Branch,
+			&task.HostGroupID, &task.Status, &task.Description, &task.RunAs,
+			&task.Strategy, &task.DeployPath, &task.BuildCommand, &task.StartCommand, &task.StopCommand, &env, &ports,
+			&task.ProcessManager, &task.ServiceUser, &task.RestartPolicy, &task.KeepReleases, &task.BatchSize, &task.HealthCheck,
+			&task.HealthTarget, &task.HealthTimeout, &task.AutoRollback, &task.Canary, &task.WebhookEnabled, &task.BuildMode, &task.BuildImage,
+			&task.ArtifactPath, &task.InstallCommand, &task.CreatedAt, &task.UpdatedAt, &hostGroupName)
 		if err != nil {
 			continue
 		}
+		if err := services.ParseDeploymentSettings(&task, env, ports); err != nil {
+			continue
+		}
 
 		taskMap := map[string]interface{}{
-			"id":              task.ID,
-			"name":            task.Name,
-			"github_url":      task.GithubURL,
-			"branch":          task.Branch,
-			"host_group_id":   task.HostGroupID,
-			"host_group_name": hostGroupName,
-			"status":          task.Status,
-			"description":     task.Description,
-			"created_at":      task.CreatedAt,
-			"updated_at":      task.UpdatedAt,
+			"id":                task.ID,
+			"name":              task.Name,
+			"github_url":        task.GithubURL,
+			"git_credential_id": task.GitCredentialID,
+			"branch":            task.Branch,
+			"host_group_id":     task.HostGroupID,
+			"host_group_name":   hostGroupName,
+			"status":            task.Status,
+			"description":       task.Description,
+			"run_as":            task.RunAs,
+			"strategy":          task.Strategy,
+			"deploy_path":       task.DeployPath,
+			"build_command":     task.BuildCommand,
+			"start_command":     task.StartCommand,
+			"stop_command":      task.StopCommand,
+			"env":               task.Env,
+			"ports":             task.Ports,
+			"process_manager":   task.ProcessManager,
+			"service_user":      task.ServiceUser,
+			"restart_policy":    task.RestartPolicy,
+			"keep_releases":     task.KeepReleases,
+			"batch_size":        task.BatchSize,
+			"health_check":      task.HealthCheck,
+			"health_target":     task.HealthTarget,
+			"health_timeout":    task.HealthTimeout,
+			"auto_rollback":     task.AutoRollback,
+			"canary":            task.Canary,
+			"webhook_enabled":   task.WebhookEnabled,
+			"build_mode":        task.BuildMode,
+			"build_image":       task.BuildImage,
+			"artifact_path":     task.ArtifactPath,
+			"install_command":   task.InstallCommand,
+			"created_at":        task.CreatedAt,
+			"updated_at":        task.UpdatedAt,
 		}
 		tasks = append(tasks, taskMap)
 	}
@@ -68,15 +104,34 @@ func CreateDeploymentTask(c *gin.Context) {
 	if task.Branch == "" {
 		task.Branch = "main"
 	}
+	if !normalizeDeploymentRunAs(c, &task) {
+		return
+	}
+	if err := services.ValidateDeploymentTask(&task); err != nil {
+		respondRunError(c, err)
+		return
+	}
+	if !ensureWebhookSecret(c, &task, "") {
+		return
+	}
 	task.Status = "pending"
 	task.CreatedAt = time.Now()
 	task.UpdatedAt = time.Now()
 
 	// 插入数据库
+	env, ports := services.EncodeDeploymentSettings(task)
 	result, err := database.DB.Exec(`
-		INSERT INTO deployment_tasks (name, github_url, branch, host_group_id, status, description, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, task.Name, task.GithubURL, task.Branch, task.HostGroupID, task.Status, task.Description, task.CreatedAt, task.UpdatedAt)
+		INSERT INTO deployment_tasks (name, github_url, git_credential_id, branch, host_group_id, status, description, run_as,
+		                              strategy, deploy_path, build_command, start_command, stop_command, env, ports,
+		                              process_manager, service_user, restart_policy, keep_releases, batch_size, health_check,
+		                              health_target, health_timeout, auto_rollback, canary, webhook_enabled, webhook_secret,
+		                              build_mode, build_image, artifact_path, install_command, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, task.Name, task.GithubURL, task.GitCredentialID, task.Branch, task.HostGroupID, task.Status, task.Description, task.RunAs,
+		task.Strategy, task.DeployPath, task.BuildCommand, task.StartCommand, task.StopCommand, env, ports,
+		task.ProcessManager, task.ServiceUser, task.RestartPolicy, task.KeepReleases, task.BatchSize, task.HealthCheck,
+		task.HealthTarget, task.HealthTimeout, task.AutoRollback, task.Canary, task.WebhookEnabled, task.WebhookSecret,
+		task.BuildMode, task.BuildImage, task.ArtifactPath, task.InstallCommand, task.CreatedAt, task.UpdatedAt)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -89,7 +144,7 @@ func CreateDeploymentTask(c *gin.Context) {
 	c.JSON(http.StatusCreated, task)
 }
 
-// ExecuteDeploymentTask 执行部署任务
+// ExecuteDeploymentTask 执行部署任务，可指定要部署的标签、提交或分支，未指定时部署任务分支的最新提交
 func ExecuteDeploymentTask(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -97,27 +152,35 @@ func ExecuteDeploymentTask(c *gin.Context) {
 		return
 	}
 
-	// 获取任务信息
-	var task models.DeploymentTask
-	err = database.DB.QueryRow(`
-		SELECT id, name, github_url, branch, host_group_id, status, description
-		FROM deployment_tasks WHERE id = ?
-	`, id).Scan(&task.ID, &task.Name, &task.GithubURL, &task.Branch, &task.HostGroupID, &task.Status, &task.Description)
+	var req struct {
+		Ref string `json:"ref"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Ref = strings.TrimSpace(req.Ref)
+	if err := services.ValidateDeploymentRef(req.Ref); err != nil {
+		respondRunError(c, err)
+		return
+	}
 
+	// 获取任务信息
+	task, err := services.LoadDeploymentTask(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
 		return
 	}
 
-	// 检查任务状态
-	if task.Status == "running" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Task is already running"})
+	// 将任务置为运行中，任务正在部署或等待金丝雀批准时拒绝
+	if err := services.ClaimDeploymentTask(task.ID); err != nil {
+		respondRunError(c, err)
 		return
 	}
 
 	// 异步执行部署
 	go func() {
-		if err := services.DeployProject(&task); err != nil {
+		if err := services.DeployProject(task, services.DeploymentRequest{Ref: req.Ref}); err != nil {
 			// 记录错误日志
 			database.DB.Exec("UPDATE deployment_tasks SET status = 'failed', updated_at = ? WHERE id = ?",
 				time.Now(), task.ID)
@@ -127,6 +190,85 @@ func ExecuteDeploymentTask(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Deployment started"})
 }
 
+// RollbackDeployment 回滚部署任务到之前的版本，未指定release时回滚到上一个版本
+func RollbackDeployment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return
+	}
+
+	var req struct {
+		Release string `json:"release"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateReleaseName(req.Release); err != nil {
+		respondRunError(c, err)
+		return
+	}
+
+	task, err := services.LoadDeploymentTask(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+		return
+	}
+	if err := services.ClaimDeploymentTask(task.ID); err != nil {
+		respondRunError(c, err)
+		return
+	}
+
+	// 异步执行回滚
+	go func() {
+		if err := services.RollbackDeployment(task, req.Release); err != nil {
+			database.DB.Exec("UPDATE deployment_tasks SET status = 'failed', updated_at = ? WHERE id = ?",
+				time.Now(), task.ID)
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rollback started"})
+}
+
+// ApproveDeployment 批准金丝雀部署，继续部署其余主机
+func ApproveDeployment(c *gin.Context) {
+	reviewCanaryDeployment(c, services.ApproveDeployment, "Deployment approved, rolling out to remaining hosts")
+}
+
+// RejectDeployment 拒绝金丝雀部署，回滚金丝雀主机
+func RejectDeployment(c *gin.Context) {
+	reviewCanaryDeployment(c, services.RejectDeployment, "Deployment rejected, rolling back canary hosts")
+}
+
+// reviewCanaryDeployment 检查任务处于待批准状态后异步执行批准或拒绝
+func reviewCanaryDeployment(c *gin.Context, review func(*models.DeploymentTask) error, message string) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return
+	}
+
+	task, err := services.LoadDeploymentTask(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+		return
+	}
+	if task.Status != "awaiting_approval" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Deployment is not awaiting approval"})
+		return
+	}
+
+	go func() {
+		if err := review(task); err != nil {
+			database.DB.Exec("UPDATE deployment_tasks SET status = 'failed', updated_at = ? WHERE id = ?",
+				time.Now(), task.ID)
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
 // GetDeploymentLogs 获取部署日志
 func GetDeploymentLogs(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -136,7 +278,8 @@ func GetDeploymentLogs(c *gin.Context) {
 	}
 
 	rows, err := database.DB.Query(`
-		SELECT id, task_id, host, status, output, error, deployed_at
+		SELECT id, task_id, host, status, output, error, service_status, release, commit_sha, commit_message,
+		       switched, deployed_at
 		FROM deployment_logs WHERE task_id = ?
 		ORDER BY deployed_at DESC
 	`, id)
@@ -150,7 +293,8 @@ func GetDeploymentLogs(c *gin.Context) {
 	for rows.Next() {
 		var log models.DeploymentLog
 		err := rows.Scan(&log.ID, &log.TaskID, &log.Host, &log.Status,
-			&log.Output, &log.Error, &log.DeployedAt)
+			&log.Output, &log.Error, &log.ServiceStatus, &log.Release, &log.CommitSHA, &log.CommitMessage,
+			&log.Switched, &log.DeployedAt)
 		if err != nil {
 			continue
 		}
@@ -160,6 +304,46 @@ func GetDeploymentLogs(c *gin.Context) {
 	c.JSON(http.StatusOK, logs)
 }
 
+// GetDeployedVersions 获取主机组中每台主机当前部署的版本，并指出各主机版本是否一致
+func GetDeployedVersions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return
+	}
+
+	task, err := services.LoadDeploymentTask(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+		return
+	}
+
+	versions, err := services.DeployedVersions(task)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"hosts": versions, "in_sync": services.VersionsInSync(versions)})
+}
+
+// GetDeploymentArtifacts 获取任务在本机构建并保留的制品
+func GetDeploymentArtifacts(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return
+	}
+
+	artifacts, err := services.ListDeploymentArtifacts(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, artifacts)
+}
+
 // DeleteDeploymentTask 删除部署任务
 func DeleteDeploymentTask(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -168,13 +352,23 @@ func DeleteDeploymentTask(c *gin.Context) {
 		return
 	}
 
-	// 删除相关日志
+	// 删除相关日志及日志文件
+	if _, _, err := services.DeleteLogFiles(services.LogTypeDeployment, "task_id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	_, err = database.DB.Exec("DELETE FROM deployment_logs WHERE task_id = ?", id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// 删除本机构建的制品
+	if err := services.DeleteDeploymentArtifacts(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 删除任务
 	_, err = database.DB.Exec("DELETE FROM deployment_tasks WHERE id = ?", id)
 	if err != nil {
@@ -194,7 +388,7 @@ func GetDeploymentSessions(c *gin.Context) {
 	}
 
 	rows, err := database.DB.Query(`
-		SELECT id, task_id, session_name, created_at
+		SELECT id, task_id, session_name, status, release, ref, commit_sha, created_at
 		FROM deployment_sessions WHERE task_id = ?
 		ORDER BY created_at DESC
 	`, id)
@@ -207,7 +401,8 @@ func GetDeploymentSessions(c *gin.Context) {
 	var sessions []models.DeploymentSession
 	for rows.Next() {
 		var session models.DeploymentSession
-		err := rows.Scan(&session.ID, &session.TaskID, &session.SessionName, &session.CreatedAt)
+		err := rows.Scan(&session.ID, &session.TaskID, &session.SessionName, &session.Status, &session.Release, &session.Ref, &session.CommitSHA,
+			&session.CreatedAt)
 		if err != nil {
 			continue
 		}
@@ -232,7 +427,8 @@ func GetDeploymentLogsBySession(c *gin.Context) {
 	}
 
 	rows, err := database.DB.Query(`
-		SELECT id, task_id, session_name, host, status, output, error, deployed_at
+		SELECT id, task_id, session_name, host, status, output, error, service_status, release, commit_sha,
+		       commit_message, switched, deployed_at
 		FROM deployment_logs WHERE task_id = ? AND session_name = ?
 		ORDER BY deployed_at DESC
 	`, id, sessionName)
@@ -246,7 +442,8 @@ func GetDeploymentLogsBySession(c *gin.Context) {
 	for rows.Next() {
 		var log models.DeploymentLog
 		err := rows.Scan(&log.ID, &log.TaskID, &log.SessionName, &log.Host,
-			&log.Status, &log.Output, &log.Error, &log.DeployedAt)
+			&log.Status, &log.Output, &log.Error, &log.ServiceStatus, &log.Release, &log.CommitSHA,
+			&log.CommitMessage, &log.Switched, &log.DeployedAt)
 		if err != nil {
 			continue
 		}
@@ -271,11 +468,11 @@ func UpdateDeploymentTask(c *gin.Context) {
 	// 检查任务是否存在
 	var existingTask models.DeploymentTask
 	err = database.DB.QueryRow(`
-		SELECT id, name, github_url, branch, host_group_id, status, description, created_at
+		SELECT id, name, github_url, branch, host_group_id, status, description, webhook_secret, created_at
 		FROM deployment_tasks WHERE id = ?
 	`, id).Scan(&existingTask.ID, &existingTask.Name, &existingTask.GithubURL,
 		&existingTask.Branch, &existingTask.HostGroupID, &existingTask.Status,
-		&existingTask.Description, &existingTask.CreatedAt)
+		&existingTask.Description, &existingTask.WebhookSecret, &existingTask.CreatedAt)
 
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
@@ -283,7 +480,7 @@ func UpdateDeploymentTask(c *gin.Context) {
 	}
 
 	// 检查任务状态，运行中的任务不能更新
-	if existingTask.Status == "running" {
+	if existingTask.Status == "running" || existingTask.Status == "awaiting_approval" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot update running task"})
 		return
 	}
@@ -292,14 +489,33 @@ func UpdateDeploymentTask(c *gin.Context) {
 	if task.Branch == "" {
 		task.Branch = "main"
 	}
+	if !normalizeDeploymentRunAs(c, &task) {
+		return
+	}
+	if err := services.ValidateDeploymentTask(&task); err != nil {
+		respondRunError(c, err)
+		return
+	}
+	if !ensureWebhookSecret(c, &task, existingTask.WebhookSecret) {
+		return
+	}
 	task.UpdatedAt = time.Now()
 
 	// 更新数据库
+	env, ports := services.EncodeDeploymentSettings(task)
 	_, err = database.DB.Exec(`
 		UPDATE deployment_tasks 
-		SET name = ?, github_url = ?, branch = ?, host_group_id = ?, description = ?, updated_at = ?
+		SET name = ?, github_url = ?, git_credential_id = ?, branch = ?, host_group_id = ?, description = ?, run_as = ?,
+		    strategy = ?, deploy_path = ?, build_command = ?, start_command = ?, stop_command = ?, env = ?, ports = ?,
+		    process_manager = ?, service_user = ?, restart_policy = ?, keep_releases = ?, batch_size = ?, health_check = ?,
+		    health_target = ?, health_timeout = ?, auto_rollback = ?, canary = ?, webhook_enabled = ?, webhook_secret = ?,
+		    build_mode = ?, build_image = ?, artifact_path = ?, install_command = ?, updated_at = ?
 		WHERE id = ?
-	`, task.Name, task.GithubURL, task.Branch, task.HostGroupID, task.Description, task.UpdatedAt, id)
+	`, task.Name, task.GithubURL, task.GitCredentialID, task.Branch, task.HostGroupID, task.Description, task.RunAs,
+		task.Strategy, task.DeployPath, task.BuildCommand, task.StartCommand, task.StopCommand, env, ports,
+		task.ProcessManager, task.ServiceUser, task.RestartPolicy, task.KeepReleases, task.BatchSize, task.HealthCheck,
+		task.HealthTarget, task.HealthTimeout, task.AutoRollback, task.Canary, task.WebhookEnabled, task.WebhookSecret,
+		task.BuildMode, task.BuildImage, task.ArtifactPath, task.InstallCommand, task.UpdatedAt, id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -313,3 +529,30 @@ func UpdateDeploymentTask(c *gin.Context) {
 
 	c.JSON(http.StatusOK, task)
 }
+
+// ensureWebhookSecret 未填写Webhook密钥时沿用原密钥，启用Webhook且没有密钥时生成随机密钥，失败时已写入响应
+func ensureWebhookSecret(c *gin.Context, task *models.DeploymentTask, existing string) bool {
+	task.WebhookSecret = strings.TrimSpace(task.WebhookSecret)
+	if task.WebhookSecret == "" {
+		task.WebhookSecret = existing
+	}
+	if task.WebhookEnabled && task.WebhookSecret == "" {
+		secret, err := services.GenerateWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		task.WebhookSecret = secret
+	}
+	return true
+}
+
+// normalizeDeploymentRunAs 校验部署脚本的执行身份，为空时使用登录用户，需要root权限时显式填写root
+func normalizeDeploymentRunAs(c *gin.Context, task *models.DeploymentTask) bool {
+	task.RunAs = strings.TrimSpace(task.RunAs)
+	if err := services.ValidateRunAs(task.RunAs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
@@ -7,7 +7,6 @@ import (
 	"runme-backend/models"
 	"runme-backend/services"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,7 +15,7 @@ import (
 // GetDockerTemplates 获取所有Docker模板
 func GetDockerTemplates(c *gin.Context) {
 	rows, err := database.DB.Query(`
-		SELECT id, name, docker_command, created_at, updated_at 
+		SELECT id, name, docker_command, run_as, created_at, updated_at 
 		FROM docker_templates ORDER BY created_at DESC
 	`)
 	if err != nil {
@@ -29,7 +28,7 @@ func GetDockerTemplates(c *gin.Context) {
 	for rows.Next() {
 		var template models.DockerTemplate
 		err := rows.Scan(
-			&template.ID, &template.Name, &template.DockerCommand,
+			&template.ID, &template.Name, &template.DockerCommand, &template.RunAs,
 			&template.CreatedAt, &template.UpdatedAt,
 		)
 		if err != nil {
@@ -48,10 +47,10 @@ func GetDockerTemplate(c *gin.Context) {
 
 	var template models.DockerTemplate
 	err := database.DB.QueryRow(`
-		SELECT id, name, docker_command, created_at, updated_at 
+		SELECT id, name, docker_command, run_as, created_at, updated_at 
 		FROM docker_templates WHERE id = ?
 	`, id).Scan(
-		&template.ID, &template.Name, &template.DockerCommand,
+		&template.ID, &template.Name, &template.DockerCommand, &template.RunAs,
 		&template.CreatedAt, &template.UpdatedAt,
 	)
 
@@ -65,8 +64,16 @@ func GetDockerTemplate(c *gin.Context) {
 
 // CreateDockerTemplate 创建Docker模板
 func CreateDockerTemplate(c *gin.Context) {
-	var template models.DockerTemplate
-	if err := c.ShouldBindJSON(&template); err != nil {
+	var req struct {
+		models.DockerTemplate
+		RevisionMessage string `json:"revision_message"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	template := req.DockerTemplate
+	if err := services.ValidateRunAs(template.RunAs); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -75,10 +82,10 @@ func CreateDockerTemplate(c *gin.Context) {
 	template.UpdatedAt = time.Now()
 
 	result, err := database.DB.Exec(`
-		INSERT INTO docker_templates (name, docker_command, created_at, updated_at) 
-		VALUES (?, ?, ?, ?)
+		INSERT INTO docker_templates (name, docker_command, run_as, created_at, updated_at) 
+		VALUES (?, ?, ?, ?, ?)
 	`,
-		template.Name, template.DockerCommand, template.CreatedAt, template.UpdatedAt,
+		template.Name, template.DockerCommand, template.RunAs, template.CreatedAt, template.UpdatedAt,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -91,6 +98,16 @@ func CreateDockerTemplate(c *gin.Context) {
 		return
 	}
 	template.ID = int(id)
+
+	// 记录初始版本
+	if req.RevisionMessage == "" {
+		req.RevisionMessage = "Create template"
+	}
+	if _, err := services.CreateDockerTemplateRevision(template, c.GetString("username"), req.RevisionMessage); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to record revision: %v", err)})
+		return
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"data": template})
 }
 
@@ -98,8 +115,16 @@ func CreateDockerTemplate(c *gin.Context) {
 func UpdateDockerTemplate(c *gin.Context) {
 	id := c.Param("id")
 
-	var template models.DockerTemplate
-	if err := c.ShouldBindJSON(&template); err != nil {
+	var req struct {
+		models.DockerTemplate
+		RevisionMessage string `json:"revision_message"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	template := req.DockerTemplate
+	if err := services.ValidateRunAs(template.RunAs); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -108,10 +133,10 @@ func UpdateDockerTemplate(c *gin.Context) {
 
 	result, err := database.DB.Exec(`
 		UPDATE docker_templates 
-		SET name = ?, docker_command = ?, updated_at = ? 
+		SET name = ?, docker_command = ?, run_as = ?, updated_at = ? 
 		WHERE id = ?
 	`,
-		template.Name, template.DockerCommand, template.UpdatedAt, id,
+		template.Name, template.DockerCommand, template.RunAs, template.UpdatedAt, id,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -132,6 +157,13 @@ func UpdateDockerTemplate(c *gin.Context) {
 	// 获取更新后的模板
 	idInt, _ := strconv.Atoi(id)
 	template.ID = idInt
+
+	// 每次保存都记录一个不可变版本
+	if _, err := services.CreateDockerTemplateRevision(template, c.GetString("username"), req.RevisionMessage); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to record revision: %v", err)})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"data": template})
 }
 
@@ -156,6 +188,12 @@ func DeleteDockerTemplate(c *gin.Context) {
 		return
 	}
 
+	idInt, _ := strconv.Atoi(id)
+	if err := services.DeleteRevisions(services.RevisionTypeDockerTemplate, idInt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Docker template deleted successfully"})
 }
 
@@ -165,7 +203,7 @@ func ExecuteDockerTemplate(c *gin.Context) {
 
 	var req struct {
 		HostID        int    `json:"host_id" binding:"required"`
-		DockerCommand string `json:"docker_command" binding:"required"`
+		DockerCommand string `json:"docker_command"` // 为空时使用模板中保存的命令
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -173,54 +211,48 @@ func ExecuteDockerTemplate(c *gin.Context) {
 		return
 	}
 
-	// 获取主机信息
-	var host models.Host
-	err := database.DB.QueryRow(`
-		SELECT id, ip, port, username, password, host_group_id 
-		FROM hosts WHERE id = ?
-	`, req.HostID).Scan(
-		&host.ID, &host.IP, &host.Port, &host.Username, &host.Password, &host.HostGroupID,
-	)
-
+	id, err := strconv.Atoi(templateID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Host not found"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
 		return
 	}
 
-	// 先检查Docker是否可用
-	dockerCheckCmd := "docker --version && docker info"
-	checkResult, checkErr := services.ExecuteSSHCommand(host.IP, host.Username, host.Password, host.Port, dockerCheckCmd)
-
-	var errorDetails string
-	if checkErr != nil {
-		errorDetails = fmt.Sprintf("Docker环境检查失败: %v\n检查结果: %s\n", checkErr, checkResult)
+	// 获取模板信息
+	template, err := services.LoadDockerTemplate(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Docker template not found"})
+		return
 	}
 
-	// 执行Docker命令，如果失败则尝试使用sudo
-	result, err := services.ExecuteSSHCommand(host.IP, host.Username, host.Password, host.Port, req.DockerCommand)
-	if err != nil && strings.Contains(err.Error(), "status 125") {
-		// 尝试使用sudo执行
-		sudoCommand := "sudo " + req.DockerCommand
-		result, err = services.ExecuteSSHCommand(host.IP, host.Username, host.Password, host.Port, sudoCommand)
-	}
+	// 获取主机信息
+	host, err := services.LoadHostByID(req.HostID)
 	if err != nil {
-		fullError := fmt.Sprintf("Failed to execute command: %v", err)
-		if errorDetails != "" {
-			fullError = errorDetails + fullError
-		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host not found"})
+		return
+	}
 
+	run, err := services.RunDockerTemplate(*template, *host, req.DockerCommand)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if run.Error != "" {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":        fullError,
-			"result":       result,
-			"docker_check": checkResult,
+			"error":            run.Error,
+			"result":           run.Result,
+			"docker_check":     run.DockerCheck,
+			"revision":         run.Revision,
+			"command_modified": run.CommandModified,
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":     "Command executed successfully",
-		"result":      result,
-		"template_id": templateID,
-		"host_id":     req.HostID,
+		"message":          "Command executed successfully",
+		"result":           run.Result,
+		"template_id":      templateID,
+		"host_id":          req.HostID,
+		"revision":         run.Revision,
+		"command_modified": run.CommandModified,
 	})
 }
@@ -30,7 +30,7 @@ func GetHostsByGroupID(c *gin.Context) {
 		return
 	}
 
-	rows, err := database.DB.Query("SELECT id, ip, port, username, password, host_group_id, os_info, created_at, updated_at FROM hosts WHERE host_group_id = ?", groupID)
+	rows, err := database.DB.Query("SELECT id, ip, port, username, password, become_password, host_group_id, os_info, hostname, tags, created_at, updated_at FROM hosts WHERE host_group_id = ?", groupID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch hosts"})
 		return
@@ -40,7 +40,7 @@ func GetHostsByGroupID(c *gin.Context) {
 	var hosts []models.Host
 	for rows.Next() {
 		var host models.Host
-		err := rows.Scan(&host.ID, &host.IP, &host.Port, &host.Username, &host.Password, &host.HostGroupID, &host.OSInfo, &host.CreatedAt, &host.UpdatedAt)
+		err := rows.Scan(&host.ID, &host.IP, &host.Port, &host.Username, &host.Password, &host.BecomePassword, &host.HostGroupID, &host.OSInfo, &host.Hostname, &host.Tags, &host.CreatedAt, &host.UpdatedAt)
 		if err != nil {
 			continue
 		}
@@ -66,8 +66,8 @@ func CreateHost(c *gin.Context) {
 	host.CreatedAt = time.Now()
 	host.UpdatedAt = time.Now()
 
-	result, err := database.DB.Exec("INSERT INTO hosts (ip, port, username, password, host_group_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
-		host.IP, host.Port, host.Username, host.Password, host.HostGroupID, host.CreatedAt, host.UpdatedAt)
+	result, err := database.DB.Exec("INSERT INTO hosts (ip, port, username, password, become_password, host_group_id, hostname, tags, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		host.IP, host.Port, host.Username, host.Password, host.BecomePassword, host.HostGroupID, host.Hostname, host.Tags, host.CreatedAt, host.UpdatedAt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create host"})
 		return
@@ -96,8 +96,8 @@ func UpdateHost(c *gin.Context) {
 
 	host.UpdatedAt = time.Now()
 
-	_, err = database.DB.Exec("UPDATE hosts SET ip = ?, port = ?, username = ?, password = ?, updated_at = ? WHERE id = ?",
-		host.IP, host.Port, host.Username, host.Password, host.UpdatedAt, hostID)
+	_, err = database.DB.Exec("UPDATE hosts SET ip = ?, port = ?, username = ?, password = ?, become_password = ?, hostname = ?, tags = ?, updated_at = ? WHERE id = ?",
+		host.IP, host.Port, host.Username, host.Password, host.BecomePassword, host.Hostname, host.Tags, host.UpdatedAt, hostID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update host"})
 		return
@@ -153,8 +153,8 @@ func GetHostByID(c *gin.Context) {
 	}
 
 	var host models.Host
-	err = database.DB.QueryRow("SELECT id, ip, port, username, password, host_group_id, os_info, created_at, updated_at FROM hosts WHERE id = ?", hostID).Scan(
-		&host.ID, &host.IP, &host.Port, &host.Username, &host.Password, &host.HostGroupID, &host.OSInfo, &host.CreatedAt, &host.UpdatedAt)
+	err = database.DB.QueryRow("SELECT id, ip, port, username, password, become_password, host_group_id, os_info, hostname, tags, created_at, updated_at FROM hosts WHERE id = ?", hostID).Scan(
+		&host.ID, &host.IP, &host.Port, &host.Username, &host.Password, &host.BecomePassword, &host.HostGroupID, &host.OSInfo, &host.Hostname, &host.Tags, &host.CreatedAt, &host.UpdatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -190,6 +190,12 @@ func DeleteHost(c *gin.Context) {
 		return
 	}
 
+	// 删除主机变量，未启用外键约束，不会级联删除
+	if _, err = database.DB.Exec("DELETE FROM host_vars WHERE host_id = ?", hostID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete host variables"})
+		return
+	}
+
 	// 删除主机
 	_, err = database.DB.Exec("DELETE FROM hosts WHERE id = ?", hostID)
 	if err != nil {
@@ -200,6 +206,60 @@ func DeleteHost(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Host deleted successfully"})
 }
 
+// GetHostVars 获取主机变量
+func GetHostVars(c *gin.Context) {
+	hostID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid host ID"})
+		return
+	}
+
+	vars, err := services.GetHostVars(hostID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": vars})
+}
+
+// UpdateHostVars 替换主机变量
+func UpdateHostVars(c *gin.Context) {
+	hostID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid host ID"})
+		return
+	}
+
+	var vars map[string]string
+	if err := c.ShouldBindJSON(&vars); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateVarKeys(vars); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var exists bool
+	err = database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM hosts WHERE id = ?)", hostID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check host existence"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host not found"})
+		return
+	}
+
+	if err := services.ReplaceHostVars(hostID, vars); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": vars})
+}
+
 // PingHost Ping指定主机
 func PingHost(c *gin.Context) {
 	hostIDStr := c.Param("id")
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"runme-backend/database"
 	"runme-backend/models"
+	"runme-backend/services"
 	"strconv"
 	"strings"
 	"time"
@@ -126,6 +127,12 @@ func DeleteHostGroup(c *gin.Context) {
 		return
 	}
 
+	// 删除主机组变量，未启用外键约束，不会级联删除
+	if _, err = database.DB.Exec("DELETE FROM host_group_vars WHERE host_group_id=?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	_, err = database.DB.Exec("DELETE FROM host_groups WHERE id=?", id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -134,3 +141,57 @@ func DeleteHostGroup(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Host group deleted successfully"})
 }
+
+// GetHostGroupVars 获取主机组变量
+func GetHostGroupVars(c *gin.Context) {
+	groupID, err := strconv.Atoi(c.Param("groupId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	vars, err := services.GetHostGroupVars(groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": vars})
+}
+
+// UpdateHostGroupVars 替换主机组变量
+func UpdateHostGroupVars(c *gin.Context) {
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var vars map[string]string
+	if err := c.ShouldBindJSON(&vars); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateVarKeys(vars); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var exists bool
+	err = database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM host_groups WHERE id = ?)", groupID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host group not found"})
+		return
+	}
+
+	if err := services.ReplaceHostGroupVars(groupID, vars); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": vars})
+}
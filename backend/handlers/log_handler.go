@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"runme-backend/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetLogChunk 分段读取执行日志
+// 查询参数：stream（output/stdout/stderr，默认output）、offset（字节偏移）、limit（读取长度）
+func GetLogChunk(c *gin.Context) {
+	logID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid log ID"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	chunk, err := services.ReadLogChunk(c.Param("type"), logID, c.DefaultQuery("stream", "output"), offset, limit)
+	if err != nil {
+		respondLogError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, chunk)
+}
+
+// DownloadLog 下载完整执行日志
+func DownloadLog(c *gin.Context) {
+	logType := c.Param("type")
+	logID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid log ID"})
+		return
+	}
+	stream := c.DefaultQuery("stream", "output")
+
+	reader, size, _, err := services.OpenLog(logType, logID, stream)
+	if err != nil {
+		respondLogError(c, err)
+		return
+	}
+	defer reader.Close()
+
+	filename := fmt.Sprintf("%s-%d-%s.log", logType, logID, stream)
+	c.DataFromReader(http.StatusOK, size, "text/plain; charset=utf-8", reader, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, filename),
+	})
+}
+
+// respondLogError 日志不存在时返回404，其余错误按执行错误处理
+func respondLogError(c *gin.Context, err error) {
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+		return
+	}
+	respondRunError(c, err)
+}
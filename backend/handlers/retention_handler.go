@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"runme-backend/models"
+	"runme-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetRetentionPolicies 获取执行历史保留策略
+func GetRetentionPolicies(c *gin.Context) {
+	policies, err := services.ListRetentionPolicies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, policies)
+}
+
+// UpdateRetentionPolicies 更新执行历史保留策略
+func UpdateRetentionPolicies(c *gin.Context) {
+	var policies []models.RetentionPolicy
+	if err := c.ShouldBindJSON(&policies); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.SaveRetentionPolicies(policies); err != nil {
+		respondRunError(c, err)
+		return
+	}
+
+	GetRetentionPolicies(c)
+}
+
+// PurgeExecutionHistory 立即按保留策略清理执行历史，默认执行VACUUM，vacuum=false时跳过
+func PurgeExecutionHistory(c *gin.Context) {
+	report, err := services.PurgeExecutionHistory(c.DefaultQuery("vacuum", "true") != "false")
+	if err != nil {
+		respondRunError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
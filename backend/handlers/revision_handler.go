@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"runme-backend/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetRevisions 获取资源的版本列表
+func GetRevisions(resourceType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+			return
+		}
+
+		revisions, err := services.ListRevisions(resourceType, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, revisions)
+	}
+}
+
+// GetRevision 获取资源的指定版本
+func GetRevision(resourceType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+			return
+		}
+		rev, err := strconv.Atoi(c.Param("revision"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+			return
+		}
+
+		revision, err := services.GetRevision(resourceType, id, rev)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, revision)
+	}
+}
+
+// DiffRevisions 对比资源的两个版本
+func DiffRevisions(resourceType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+			return
+		}
+		fromRev, err := strconv.Atoi(c.Query("from"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from revision"})
+			return
+		}
+		toRev, err := strconv.Atoi(c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to revision"})
+			return
+		}
+
+		from, err := services.GetRevision(resourceType, id, fromRev)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Revision %d not found", fromRev)})
+			return
+		}
+		to, err := services.GetRevision(resourceType, id, toRev)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Revision %d not found", toRev)})
+			return
+		}
+
+		diff := services.DiffText(from.Content, to.Content,
+			fmt.Sprintf("revision %d", from.Revision), fmt.Sprintf("revision %d", to.Revision))
+
+		c.JSON(http.StatusOK, gin.H{
+			"from": from.Revision,
+			"to":   to.Revision,
+			"diff": diff,
+		})
+	}
+}
+
+// RestoreRevision 将资源恢复到指定版本
+func RestoreRevision(resourceType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+			return
+		}
+		rev, err := strconv.Atoi(c.Param("revision"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+			return
+		}
+
+		revision, err := services.RestoreRevision(resourceType, id, rev, c.GetString("username"))
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+			} else {
+				respondRunError(c, err)
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, revision)
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"runme-backend/database"
+	"runme-backend/models"
+	"runme-backend/services"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSchedules 获取所有定时计划
+func GetSchedules(c *gin.Context) {
+	rows, err := database.DB.Query(`
+		SELECT id, name, target_type, target_id, cron_expr, payload, enabled, last_run_at, next_run_at,
+		       last_status, last_message, created_at, updated_at
+		FROM schedules ORDER BY created_at DESC
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var schedules []models.Schedule
+	for rows.Next() {
+		var s models.Schedule
+		err := rows.Scan(&s.ID, &s.Name, &s.TargetType, &s.TargetID, &s.CronExpr, &s.Payload, &s.Enabled,
+			&s.LastRunAt, &s.NextRunAt, &s.LastStatus, &s.LastMessage, &s.CreatedAt, &s.UpdatedAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		schedules = append(schedules, s)
+	}
+
+	c.JSON(http.StatusOK, schedules)
+}
+
+// GetSchedule 获取单个定时计划
+func GetSchedule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	schedule, err := services.LoadSchedule(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// CreateSchedule 创建定时计划
+func CreateSchedule(c *gin.Context) {
+	var schedule models.Schedule
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !validateSchedule(c, &schedule) {
+		return
+	}
+
+	schedule.CreatedAt = time.Now()
+	schedule.UpdatedAt = time.Now()
+	result, err := database.DB.Exec(`
+		INSERT INTO schedules (name, target_type, target_id, cron_expr, payload, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, schedule.Name, schedule.TargetType, schedule.TargetID, schedule.CronExpr, schedule.Payload,
+		schedule.Enabled, schedule.CreatedAt, schedule.UpdatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	if err := services.ReloadSchedule(int(id)); err != nil {
+		log.Printf("Failed to register schedule %d: %v", id, err)
+	}
+
+	created, err := services.LoadSchedule(int(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateSchedule 更新定时计划
+func UpdateSchedule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var schedule models.Schedule
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !validateSchedule(c, &schedule) {
+		return
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE schedules SET name = ?, target_type = ?, target_id = ?, cron_expr = ?, payload = ?, enabled = ?, updated_at = ?
+		WHERE id = ?
+	`, schedule.Name, schedule.TargetType, schedule.TargetID, schedule.CronExpr, schedule.Payload,
+		schedule.Enabled, time.Now(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	if err := services.ReloadSchedule(id); err != nil {
+		log.Printf("Failed to register schedule %d: %v", id, err)
+	}
+
+	updated, err := services.LoadSchedule(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteSchedule 删除定时计划
+func DeleteSchedule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	services.RemoveSchedule(id)
+
+	_, err = database.DB.Exec("DELETE FROM schedules WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
+}
+
+// RunSchedule 立即执行一次定时计划
+func RunSchedule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if _, err := services.LoadSchedule(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if err := services.TriggerSchedule(id); err != nil {
+		respondRunError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule triggered"})
+}
+
+// validateSchedule 校验计划内容，不合法时直接返回400
+func validateSchedule(c *gin.Context, schedule *models.Schedule) bool {
+	schedule.Name = strings.TrimSpace(schedule.Name)
+	schedule.CronExpr = strings.TrimSpace(schedule.CronExpr)
+	if schedule.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return false
+	}
+	if err := services.ValidateCronExpr(schedule.CronExpr); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if err := services.ValidateTarget(schedule.TargetType, schedule.TargetID, schedule.Payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"runme-backend/database"
@@ -17,7 +18,7 @@ import (
 // GetScripts 获取所有脚本
 func GetScripts(c *gin.Context) {
 	rows, err := database.DB.Query(`
-		SELECT s.id, s.name, s.content, s.host_group_id, s.created_at, s.updated_at, hg.name as host_group_name
+		SELECT s.id, s.name, s.content, s.host_group_id, s.parameters, s.run_as, s.success_exit_codes, s.warning_exit_codes, s.created_at, s.updated_at, hg.name as host_group_name
 		FROM scripts s
 		LEFT JOIN host_groups hg ON s.host_group_id = hg.id
 	`)
@@ -36,12 +37,21 @@ func GetScripts(c *gin.Context) {
 	for rows.Next() {
 		var s ScriptWithHostGroup
 		var hostGroupName sql.NullString
-		err := rows.Scan(&s.ID, &s.Name, &s.Content, &s.HostGroupID, &s.CreatedAt, &s.UpdatedAt, &hostGroupName)
+		var parameters string
+		err := rows.Scan(&s.ID, &s.Name, &s.Content, &s.HostGroupID, &parameters, &s.RunAs, &s.SuccessExitCodes, &s.WarningExitCodes, &s.CreatedAt, &s.UpdatedAt, &hostGroupName)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
+		s.Parameters, err = services.ParseScriptParameters(parameters)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// secret参数的默认值只写不读
+		s.Parameters = services.MaskParameterDefaults(s.Parameters)
+
 		// 处理可能为NULL的host_group_name
 		if hostGroupName.Valid {
 			s.HostGroupName = hostGroupName.String
@@ -57,18 +67,49 @@ func GetScripts(c *gin.Context) {
 
 // CreateScript 创建脚本
 func CreateScript(c *gin.Context) {
-	var script models.Script
-	if err := c.ShouldBindJSON(&script); err != nil {
+	var req struct {
+		models.Script
+		RevisionMessage string `json:"revision_message"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	script := req.Script
+	// 新建脚本没有已保存的secret默认值，提交的脱敏值视为空
+	services.KeepSecretDefaults(script.Parameters, nil)
+
+	if err := services.ValidateParameterDefinitions(script.Parameters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateRunAs(script.RunAs); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if script.SuccessExitCodes == "" {
+		script.SuccessExitCodes = "0"
+	}
+	for _, codes := range []string{script.SuccessExitCodes, script.WarningExitCodes} {
+		if err := services.ValidateExitCodes(codes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	parameters, err := services.EncodeScriptParameters(script.Parameters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	script.CreatedAt = time.Now()
 	script.UpdatedAt = time.Now()
 
 	result, err := database.DB.Exec(
-		"INSERT INTO scripts (name, content, host_group_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
-		script.Name, script.Content, script.HostGroupID, script.CreatedAt, script.UpdatedAt,
+		`INSERT INTO scripts (name, content, host_group_id, parameters, run_as, success_exit_codes, warning_exit_codes, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		script.Name, script.Content, script.HostGroupID, parameters, script.RunAs,
+		script.SuccessExitCodes, script.WarningExitCodes, script.CreatedAt, script.UpdatedAt,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -77,6 +118,17 @@ func CreateScript(c *gin.Context) {
 
 	id, _ := result.LastInsertId()
 	script.ID = int(id)
+
+	// 记录初始版本
+	if req.RevisionMessage == "" {
+		req.RevisionMessage = "Create script"
+	}
+	if _, err := services.CreateScriptRevision(script, c.GetString("username"), req.RevisionMessage); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to record revision: %v", err)})
+		return
+	}
+
+	script.Parameters = services.MaskParameterDefaults(script.Parameters)
 	c.JSON(http.StatusCreated, script)
 }
 
@@ -88,87 +140,54 @@ func ExecuteScript(c *gin.Context) {
 		return
 	}
 
-	// 获取脚本信息
-	var script models.Script
-	err = database.DB.QueryRow("SELECT id, name, content, host_group_id FROM scripts WHERE id = ?", scriptID).Scan(
-		&script.ID, &script.Name, &script.Content, &script.HostGroupID)
+	// 执行请求（可选），包含参数值和目标主机选择条件
+	var req struct {
+		services.ScriptRunRequest
+		DryRun bool `json:"dry_run"` // 仅返回解析出的目标主机，不执行
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
+	// 获取脚本信息
+	script, err := services.LoadScript(scriptID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Script not found"})
 		return
 	}
 
-	// 获取主机组下的所有主机
-	rows, err := database.DB.Query("SELECT ip, port, username, password FROM hosts WHERE host_group_id = ?", script.HostGroupID)
+	run, err := services.PrepareScriptRun(*script, req.ScriptRunRequest)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch hosts"})
+		respondRunError(c, err)
 		return
 	}
-	defer rows.Close()
-
-	var hosts []models.Host
-	for rows.Next() {
-		var host models.Host
-		err := rows.Scan(&host.IP, &host.Port, &host.Username, &host.Password)
-		if err != nil {
-			continue
-		}
-		hosts = append(hosts, host)
-	}
 
-	if len(hosts) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid hosts found"})
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run":  true,
+			"revision": run.Revision,
+			"targets":  services.SummarizeTargets(run.Hosts),
+		})
 		return
 	}
 
-	// 创建执行会话
-	sessionName := fmt.Sprintf("%s_%s", script.Name, time.Now().Format("2006-01-02_15:04:05"))
-	sessionResult, err := database.DB.Exec(
-		"INSERT INTO execution_sessions (script_id, session_name, created_at) VALUES (?, ?, ?)",
-		scriptID, sessionName, time.Now(),
-	)
+	result, err := run.Execute()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create execution session"})
+		respondRunError(c, err)
 		return
 	}
 
-	// 执行脚本
-	fmt.Printf("[DEBUG] Starting script execution on %d hosts\n", len(hosts))
-	for i, host := range hosts {
-		fmt.Printf("[DEBUG] Host %d: %s\n", i, host.IP)
-	}
-
-	var results []services.ExecutionResult
-	for _, host := range hosts {
-		result := services.ExecuteScriptOnHost(host.IP, host.Username, host.Password, host.Port, script.Content)
-		results = append(results, result)
-	}
-	fmt.Printf("[DEBUG] Script execution completed, got %d results\n", len(results))
-
-	// 保存执行日志
-	for i, result := range results {
-		fmt.Printf("[DEBUG] Result %d: Host=%s, Status=%s, Output=%s, Error=%s\n",
-			i, result.Host, result.Status, result.Output, result.Error)
+	c.JSON(http.StatusOK, result)
+}
 
-		_, err := database.DB.Exec(
-			"INSERT INTO execution_logs (script_id, host, status, output, error, executed_at) VALUES (?, ?, ?, ?, ?, ?)",
-			scriptID, result.Host, result.Status, result.Output, result.Error, time.Now(),
-		)
-		if err != nil {
-			fmt.Printf("[DEBUG] Failed to save execution log for host %s: %v\n", result.Host, err)
-		} else {
-			fmt.Printf("[DEBUG] Successfully saved log for host %s\n", result.Host)
-		}
+// respondRunError 根据错误类型返回400或500
+func respondRunError(c *gin.Context, err error) {
+	if _, ok := err.(*services.InvalidRequestError); ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-
-	sessionID, _ := sessionResult.LastInsertId()
-	fmt.Printf("[DEBUG] Returning response with sessionID: %d, sessionName: %s\n", sessionID, sessionName)
-
-	c.JSON(http.StatusOK, gin.H{
-		"session_id":   sessionID,
-		"session_name": sessionName,
-		"results":      results,
-	})
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 }
 
 // GetExecutionSessions 获取脚本的执行会话列表
@@ -182,7 +201,7 @@ func GetExecutionSessions(c *gin.Context) {
 	fmt.Printf("[DEBUG] GetExecutionSessions - scriptID: %d\n", scriptID)
 
 	rows, err := database.DB.Query(
-		"SELECT id, script_id, session_name, created_at FROM execution_sessions WHERE script_id = ? ORDER BY created_at DESC",
+		"SELECT id, script_id, session_name, parameters, revision, created_at FROM execution_sessions WHERE script_id = ? ORDER BY created_at DESC",
 		scriptID,
 	)
 	if err != nil {
@@ -195,7 +214,7 @@ func GetExecutionSessions(c *gin.Context) {
 	var sessions []models.ExecutionSession
 	for rows.Next() {
 		var session models.ExecutionSession
-		err := rows.Scan(&session.ID, &session.ScriptID, &session.SessionName, &session.CreatedAt)
+		err := rows.Scan(&session.ID, &session.ScriptID, &session.SessionName, &session.Parameters, &session.Revision, &session.CreatedAt)
 		if err != nil {
 			fmt.Printf("[DEBUG] Session scan error: %v\n", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -269,20 +288,20 @@ func GetExecutionLogs(c *gin.Context) {
 	fmt.Printf("[DEBUG] Found sessionID: %d\n", sessionID)
 
 	// 获取该会话的执行日志
+	// 新日志通过session_id关联会话，旧日志（session_id为0）按会话创建时间匹配
 	query := `
-		SELECT el.id, el.script_id, el.host, el.status, el.output, el.error, el.executed_at
+		SELECT el.id, el.script_id, el.session_id, el.host, el.status, el.output, el.stdout, el.stderr,
+		       el.exit_code, el.error, el.started_at, el.finished_at, el.duration_ms, el.executed_at
 		FROM execution_logs el
-		JOIN execution_sessions es ON el.script_id = es.script_id
-		WHERE el.script_id = ? AND es.session_name = ?
-		AND el.executed_at >= (
-			SELECT created_at FROM execution_sessions 
-			WHERE script_id = ? AND session_name = ?
-		)
+		WHERE el.script_id = ?
+		AND (el.session_id = ? OR (el.session_id = 0 AND el.executed_at >= (
+			SELECT created_at FROM execution_sessions WHERE id = ?
+		)))
 		ORDER BY el.executed_at ASC
 	`
 	fmt.Printf("[DEBUG] Executing logs query with scriptID: %d, sessionName: %s\n", scriptID, decodedSessionName)
 
-	rows, err := database.DB.Query(query, scriptID, decodedSessionName, scriptID, decodedSessionName)
+	rows, err := database.DB.Query(query, scriptID, sessionID, sessionID)
 	if err != nil {
 		fmt.Printf("[DEBUG] Query error: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -293,7 +312,8 @@ func GetExecutionLogs(c *gin.Context) {
 	var logs []models.ExecutionLog
 	for rows.Next() {
 		var log models.ExecutionLog
-		err := rows.Scan(&log.ID, &log.ScriptID, &log.Host, &log.Status, &log.Output, &log.Error, &log.ExecutedAt)
+		err := rows.Scan(&log.ID, &log.ScriptID, &log.SessionID, &log.Host, &log.Status, &log.Output, &log.Stdout, &log.Stderr,
+			&log.ExitCode, &log.Error, &log.StartedAt, &log.FinishedAt, &log.DurationMs, &log.ExecutedAt)
 		if err != nil {
 			fmt.Printf("[DEBUG] Row scan error: %v\n", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -318,24 +338,83 @@ func UpdateScript(c *gin.Context) {
 		return
 	}
 
-	var script models.Script
-	if err := c.ShouldBindJSON(&script); err != nil {
+	var req struct {
+		models.Script
+		RevisionMessage string `json:"revision_message"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	script := req.Script
+
+	// 提交的secret默认值为脱敏值时沿用已保存的默认值
+	saved, err := services.LoadScript(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Script not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	services.KeepSecretDefaults(script.Parameters, saved.Parameters)
+
+	if err := services.ValidateParameterDefinitions(script.Parameters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateRunAs(script.RunAs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if script.SuccessExitCodes == "" {
+		script.SuccessExitCodes = "0"
+	}
+	for _, codes := range []string{script.SuccessExitCodes, script.WarningExitCodes} {
+		if err := services.ValidateExitCodes(codes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	parameters, err := services.EncodeScriptParameters(script.Parameters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	script.UpdatedAt = time.Now()
 
-	_, err = database.DB.Exec(
-		"UPDATE scripts SET name=?, content=?, host_group_id=?, updated_at=? WHERE id=?",
-		script.Name, script.Content, script.HostGroupID, script.UpdatedAt, id,
+	result, err := database.DB.Exec(
+		"UPDATE scripts SET name=?, content=?, host_group_id=?, parameters=?, run_as=?, success_exit_codes=?, warning_exit_codes=?, updated_at=? WHERE id=?",
+		script.Name, script.Content, script.HostGroupID, parameters, script.RunAs,
+		script.SuccessExitCodes, script.WarningExitCodes, script.UpdatedAt, id,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Script not found"})
+		return
+	}
+
 	script.ID = id
+
+	// 每次保存都记录一个不可变版本
+	if _, err := services.CreateScriptRevision(script, c.GetString("username"), req.RevisionMessage); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to record revision: %v", err)})
+		return
+	}
+
+	script.Parameters = services.MaskParameterDefaults(script.Parameters)
 	c.JSON(http.StatusOK, script)
 }
 
@@ -353,5 +432,10 @@ func DeleteScript(c *gin.Context) {
 		return
 	}
 
+	if err := services.DeleteRevisions(services.RevisionTypeScript, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Script deleted successfully"})
 }
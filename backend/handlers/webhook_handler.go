@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"runme-backend/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhookMaxBodySize 推送事件请求体的大小上限
+const webhookMaxBodySize = 5 << 20
+
+// DeploymentWebhook 接收GitHub、GitLab和Gitea的推送事件，签名校验通过且分支与任务一致时排队部署
+func DeploymentWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return
+	}
+
+	task, err := services.LoadDeploymentTask(id)
+	if err != nil || !task.WebhookEnabled || task.WebhookSecret == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, webhookMaxBodySize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	provider, err := services.VerifyWebhook(c.Request.Header, body, task.WebhookSecret)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	event, err := services.ParseWebhookEvent(provider, c.Request.Header, body)
+	if err != nil {
+		respondRunError(c, err)
+		return
+	}
+	if event.Event != "push" {
+		c.JSON(http.StatusOK, gin.H{"message": "Event " + event.Event + " ignored"})
+		return
+	}
+	if event.Branch != task.Branch || event.CommitSHA == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "Push does not match branch " + task.Branch + ", ignored"})
+		return
+	}
+
+	// 部署推送的提交，而不是部署开始时分支的最新提交
+	services.EnqueueDeployment(task.ID, services.DeploymentRequest{Ref: event.CommitSHA, CommitSHA: event.CommitSHA})
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "Deployment queued",
+		"provider":   provider,
+		"branch":     event.Branch,
+		"commit_sha": event.CommitSHA,
+	})
+}
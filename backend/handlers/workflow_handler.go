@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"runme-backend/database"
+	"runme-backend/models"
+	"runme-backend/services"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetWorkflows 获取所有工作流
+func GetWorkflows(c *gin.Context) {
+	rows, err := database.DB.Query(`
+		SELECT id, name, description, steps, created_at, updated_at FROM workflows ORDER BY created_at DESC
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var workflows []models.Workflow
+	for rows.Next() {
+		var wf models.Workflow
+		var steps string
+		if err := rows.Scan(&wf.ID, &wf.Name, &wf.Description, &steps, &wf.CreatedAt, &wf.UpdatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		wf.Steps, err = services.ParseWorkflowSteps(steps)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		workflows = append(workflows, wf)
+	}
+
+	c.JSON(http.StatusOK, workflows)
+}
+
+// GetWorkflow 获取单个工作流
+func GetWorkflow(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	wf, err := services.LoadWorkflow(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, wf)
+}
+
+// CreateWorkflow 创建工作流
+func CreateWorkflow(c *gin.Context) {
+	var wf models.Workflow
+	if err := c.ShouldBindJSON(&wf); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	steps, ok := validateWorkflow(c, &wf)
+	if !ok {
+		return
+	}
+
+	wf.CreatedAt = time.Now()
+	wf.UpdatedAt = time.Now()
+	result, err := database.DB.Exec(
+		"INSERT INTO workflows (name, description, steps, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		wf.Name, wf.Description, steps, wf.CreatedAt, wf.UpdatedAt,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	wf.ID = int(id)
+	c.JSON(http.StatusCreated, wf)
+}
+
+// UpdateWorkflow 更新工作流
+func UpdateWorkflow(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var wf models.Workflow
+	if err := c.ShouldBindJSON(&wf); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	steps, ok := validateWorkflow(c, &wf)
+	if !ok {
+		return
+	}
+
+	wf.ID = id
+	wf.UpdatedAt = time.Now()
+	result, err := database.DB.Exec(
+		"UPDATE workflows SET name = ?, description = ?, steps = ?, updated_at = ? WHERE id = ?",
+		wf.Name, wf.Description, steps, wf.UpdatedAt, id,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, wf)
+}
+
+// DeleteWorkflow 删除工作流及其运行记录
+func DeleteWorkflow(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if err := services.DeleteWorkflowRuns(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = database.DB.Exec("DELETE FROM workflows WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workflow deleted successfully"})
+}
+
+// RunWorkflow 启动工作流，步骤在后台执行
+func RunWorkflow(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	wf, err := services.LoadWorkflow(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	runID, err := services.StartWorkflowRun(*wf, c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Workflow started",
+		"run_id":  runID,
+	})
+}
+
+// GetWorkflowRuns 获取工作流运行历史
+func GetWorkflowRuns(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	runs, err := services.ListWorkflowRuns(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, runs)
+}
+
+// GetWorkflowRun 获取单次运行的步骤状态
+func GetWorkflowRun(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	runID, err := strconv.Atoi(c.Param("runId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid run ID"})
+		return
+	}
+
+	run, err := services.GetWorkflowRun(id, runID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow run not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// validateWorkflow 校验工作流并返回编码后的步骤定义，不合法时直接返回400
+func validateWorkflow(c *gin.Context, wf *models.Workflow) (string, bool) {
+	wf.Name = strings.TrimSpace(wf.Name)
+	if wf.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return "", false
+	}
+
+	steps, err := services.NormalizeWorkflowSteps(wf.Steps)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	wf.Steps = steps
+
+	encoded, err := json.Marshal(steps)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return string(encoded), true
+}
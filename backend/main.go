@@ -5,6 +5,7 @@ import (
 	"runme-backend/database"
 	"runme-backend/handlers"
 	"runme-backend/middleware"
+	"runme-backend/services"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -14,6 +15,18 @@ func main() {
 	// 初始化数据库
 	database.InitDB()
 	defer database.DB.Close()
+	// 启动定时任务调度器
+	if err := services.StartScheduler(); err != nil {
+		log.Fatal("Failed to start scheduler:", err)
+	}
+	// 服务重启前未结束的Playbook会话标记为失败
+	if err := services.MarkInterruptedAnsibleSessions(); err != nil {
+		log.Printf("Failed to mark interrupted ansible sessions: %v", err)
+	}
+	// 定期清理过期的日志文件
+	services.StartLogCleanup()
+	// 按保留策略定期清理执行历史
+	services.StartRetentionJanitor()
 	r := gin.Default()
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
@@ -34,6 +47,11 @@ func main() {
 		{
 			terminal.GET("/:hostId", handlers.HandleSSHTerminalByHostID)
 		}
+		// Webhook路由（通过任务的Webhook密钥校验签名，不需要身份验证）
+		webhooks := api.Group("/webhooks")
+		{
+			webhooks.POST("/deployment/:id", handlers.DeploymentWebhook)
+		}
 		// 认证路由
 		protected := api.Group("/")
 		protected.Use(middleware.AuthMiddleware())
@@ -50,6 +68,8 @@ func main() {
 				hostGroupRoutes.GET("/:groupId/hosts", handlers.GetHostsByGroupID)
 				hostGroupRoutes.POST("/:groupId/ping", handlers.PingHostsByGroup)
 				hostGroupRoutes.POST("/:groupId/ssh-test", handlers.TestSSHConnectionsByGroup)
+				hostGroupRoutes.GET("/:groupId/vars", handlers.GetHostGroupVars)
+				hostGroupRoutes.PUT("/:id/vars", handlers.UpdateHostGroupVars)
 			}
 			// 主机路由
 			hostRoutes := protected.Group("/hosts")
@@ -61,6 +81,8 @@ func main() {
 				hostRoutes.GET("/:id/osinfo", handlers.GetHostOSInfo)
 				hostRoutes.POST("/:id/ping", handlers.PingHost)
 				hostRoutes.POST("/:id/ssh-test", handlers.TestSSHConnection)
+				hostRoutes.GET("/:id/vars", handlers.GetHostVars)
+				hostRoutes.PUT("/:id/vars", handlers.UpdateHostVars)
 			}
 			// Shell脚本路由
 			scriptRoutes := protected.Group("/scripts")
@@ -72,6 +94,10 @@ func main() {
 				scriptRoutes.POST("/:id/execute", handlers.ExecuteScript)
 				scriptRoutes.GET("/:id/sessions", handlers.GetExecutionSessions)
 				scriptRoutes.GET("/:id/logs", handlers.GetExecutionLogs)
+				scriptRoutes.GET("/:id/revisions", handlers.GetRevisions(services.RevisionTypeScript))
+				scriptRoutes.GET("/:id/revisions/diff", handlers.DiffRevisions(services.RevisionTypeScript))
+				scriptRoutes.GET("/:id/revisions/:revision", handlers.GetRevision(services.RevisionTypeScript))
+				scriptRoutes.POST("/:id/revisions/:revision/restore", handlers.RestoreRevision(services.RevisionTypeScript))
 			}
 			// Ansible路由
 			ansible := api.Group("/ansible")
@@ -84,6 +110,27 @@ func main() {
 				ansible.GET("/:id/sessions", handlers.GetAnsibleExecutionSessions)
 				ansible.GET("/:id/logs", handlers.GetAnsibleExecutionLogs)
 			}
+			// Playbook版本路由
+			ansibleRevisions := protected.Group("/ansible")
+			{
+				ansibleRevisions.GET("/:id/revisions", handlers.GetRevisions(services.RevisionTypeAnsible))
+				ansibleRevisions.GET("/:id/revisions/diff", handlers.DiffRevisions(services.RevisionTypeAnsible))
+				ansibleRevisions.GET("/:id/revisions/:revision", handlers.GetRevision(services.RevisionTypeAnsible))
+				ansibleRevisions.POST("/:id/revisions/:revision/restore", handlers.RestoreRevision(services.RevisionTypeAnsible))
+			}
+			// Playbook逐任务执行结果
+			protected.GET("/ansible/:id/logs/:logId/tasks", handlers.GetAnsibleTaskResults)
+			// Playbook执行输出流，浏览器的WebSocket通过token查询参数认证
+			protected.GET("/ansible/:id/sessions/:sessionId/stream", handlers.StreamAnsibleSession)
+			// Playbook项目文件路由，可以写入控制机上执行的文件，需要身份验证
+			ansibleProject := protected.Group("/ansible")
+			{
+				ansibleProject.GET("/:id/files", handlers.GetPlaybookFiles)
+				ansibleProject.GET("/:id/files/*path", handlers.GetPlaybookFile)
+				ansibleProject.PUT("/:id/files/*path", handlers.SavePlaybookFile)
+				ansibleProject.DELETE("/:id/files/*path", handlers.DeletePlaybookFile)
+				ansibleProject.POST("/:id/import", handlers.ImportPlaybookProject)
+			}
 			// 监控路由
 			monitoring := api.Group("/monitoring")
 			{
@@ -100,6 +147,15 @@ func main() {
 				deployment.GET("/:id/logs", handlers.GetDeploymentLogsBySession)
 				deployment.DELETE("/:id", handlers.DeleteDeploymentTask)
 			}
+			// 部署回滚路由
+			protected.POST("/deployment/:id/rollback", handlers.RollbackDeployment)
+			// 金丝雀部署审批路由
+			protected.POST("/deployment/:id/approve", handlers.ApproveDeployment)
+			protected.POST("/deployment/:id/reject", handlers.RejectDeployment)
+			// 各主机已部署的版本
+			protected.GET("/deployment/:id/versions", handlers.GetDeployedVersions)
+			// 本机构建的部署制品
+			protected.GET("/deployment/:id/artifacts", handlers.GetDeploymentArtifacts)
 			// AI建议路由
 			ai := api.Group("/ai")
 			{
@@ -117,6 +173,28 @@ func main() {
 				certificates.GET("/:id/download", handlers.DownloadCertificate)
 				certificates.GET("/:id/logs", handlers.GetCertificateLogs)
 			}
+			// 定时任务路由
+			schedules := protected.Group("/schedules")
+			{
+				schedules.GET("", handlers.GetSchedules)
+				schedules.GET("/:id", handlers.GetSchedule)
+				schedules.POST("", handlers.CreateSchedule)
+				schedules.PUT("/:id", handlers.UpdateSchedule)
+				schedules.DELETE("/:id", handlers.DeleteSchedule)
+				schedules.POST("/:id/run", handlers.RunSchedule)
+			}
+			// 工作流路由
+			workflows := protected.Group("/workflows")
+			{
+				workflows.GET("", handlers.GetWorkflows)
+				workflows.GET("/:id", handlers.GetWorkflow)
+				workflows.POST("", handlers.CreateWorkflow)
+				workflows.PUT("/:id", handlers.UpdateWorkflow)
+				workflows.DELETE("/:id", handlers.DeleteWorkflow)
+				workflows.POST("/:id/run", handlers.RunWorkflow)
+				workflows.GET("/:id/runs", handlers.GetWorkflowRuns)
+				workflows.GET("/:id/runs/:runId", handlers.GetWorkflowRun)
+			}
 			// Docker模板管理路由
 			dockerTemplates := protected.Group("/docker-templates")
 			{
@@ -126,6 +204,34 @@ func main() {
 				dockerTemplates.PUT("/:id", handlers.UpdateDockerTemplate)
 				dockerTemplates.DELETE("/:id", handlers.DeleteDockerTemplate)
 				dockerTemplates.POST("/:id/execute", handlers.ExecuteDockerTemplate)
+				dockerTemplates.GET("/:id/revisions", handlers.GetRevisions(services.RevisionTypeDockerTemplate))
+				dockerTemplates.GET("/:id/revisions/diff", handlers.DiffRevisions(services.RevisionTypeDockerTemplate))
+				dockerTemplates.GET("/:id/revisions/:revision", handlers.GetRevision(services.RevisionTypeDockerTemplate))
+				dockerTemplates.POST("/:id/revisions/:revision/restore", handlers.RestoreRevision(services.RevisionTypeDockerTemplate))
+			}
+			// Ansible动态inventory，供RunMe之外的ansible使用
+			protected.GET("/ansible/inventory", handlers.GetAnsibleInventory)
+			// 凭据路由，secret只写不读
+			credentials := protected.Group("/credentials")
+			{
+				credentials.GET("", handlers.GetCredentials)
+				credentials.POST("", handlers.CreateCredential)
+				credentials.PUT("/:id", handlers.UpdateCredential)
+				credentials.DELETE("/:id", handlers.DeleteCredential)
+			}
+			// 执行日志分段读取和下载，type为script、ansible或deployment
+			logs := protected.Group("/logs")
+			{
+				logs.GET("/:type/:id", handlers.GetLogChunk)
+				logs.GET("/:type/:id/download", handlers.DownloadLog)
+			}
+			// 管理员路由
+			admin := protected.Group("/admin")
+			admin.Use(middleware.AdminMiddleware())
+			{
+				admin.GET("/retention", handlers.GetRetentionPolicies)
+				admin.PUT("/retention", handlers.UpdateRetentionPolicies)
+				admin.POST("/retention/purge", handlers.PurgeExecutionHistory)
 			}
 		}
 	}
@@ -42,6 +42,10 @@ func GenerateToken(user *models.User) (string, error) {
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		// 浏览器的WebSocket无法设置请求头，升级请求可以通过token查询参数传递
+		if authHeader == "" && strings.EqualFold(c.GetHeader("Upgrade"), "websocket") && c.Query("token") != "" {
+			authHeader = "Bearer " + c.Query("token")
+		}
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
@@ -74,6 +78,18 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// AdminMiddleware 管理员权限中间件，需在AuthMiddleware之后使用
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("role") != "admin" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin privileges required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 // GetCurrentUser 从上下文获取当前用户信息
 func GetCurrentUser(c *gin.Context) (*models.User, error) {
 	userID, exists := c.Get("user_id")
@@ -1,20 +1,40 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
 // Host 主机模型
 type Host struct {
-	ID          int       `json:"id" db:"id"`
-	IP          string    `json:"ip" db:"ip"`
-	Port        int       `json:"port" db:"port"`         // 新增端口字段
-	Username    string    `json:"username" db:"username"` // 新增用户名字段
-	Password    string    `json:"password" db:"password"` // 新增密码字段
-	HostGroupID int       `json:"host_group_id" db:"host_group_id"`
-	OSInfo      string    `json:"os_info" db:"os_info"` // 操作系统信息字段
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID             int       `json:"id" db:"id"`
+	IP             string    `json:"ip" db:"ip"`
+	Port           int       `json:"port" db:"port"`         // 新增端口字段
+	Username       string    `json:"username" db:"username"` // 新增用户名字段
+	Password       string    `json:"password" db:"password"` // 新增密码字段
+	HostGroupID    int       `json:"host_group_id" db:"host_group_id"`
+	OSInfo         string    `json:"os_info" db:"os_info"`                 // 操作系统信息字段
+	Hostname       string    `json:"hostname" db:"hostname"`               // 主机名，为空时使用IP
+	Tags           string    `json:"tags" db:"tags"`                       // 逗号分隔的主机标签
+	BecomePassword string    `json:"become_password" db:"become_password"` // sudo提权密码，为空时使用登录密码
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// HostVar 主机变量模型
+type HostVar struct {
+	ID     int    `json:"id" db:"id"`
+	HostID int    `json:"host_id" db:"host_id"`
+	Key    string `json:"key" db:"key"`
+	Value  string `json:"value" db:"value"`
+}
+
+// HostGroupVar 主机组变量模型
+type HostGroupVar struct {
+	ID          int    `json:"id" db:"id"`
+	HostGroupID int    `json:"host_group_id" db:"host_group_id"`
+	Key         string `json:"key" db:"key"`
+	Value       string `json:"value" db:"value"`
 }
 
 // HostGroup 主机组模型 - 简化版本
@@ -27,45 +47,103 @@ type HostGroup struct {
 
 // Script 脚本模型
 type Script struct {
-	ID          int       `json:"id" db:"id"`
-	Name        string    `json:"name" db:"name"`
-	Content     string    `json:"content" db:"content"`
-	HostGroupID int       `json:"host_group_id" db:"host_group_id"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID               int               `json:"id" db:"id"`
+	Name             string            `json:"name" db:"name"`
+	Content          string            `json:"content" db:"content"`
+	HostGroupID      int               `json:"host_group_id" db:"host_group_id"`
+	Parameters       []ScriptParameter `json:"parameters" db:"parameters"`                 // 以JSON格式存储的参数定义
+	RunAs            string            `json:"run_as" db:"run_as"`                         // 执行身份，为空时使用登录用户
+	SuccessExitCodes string            `json:"success_exit_codes" db:"success_exit_codes"` // 视为成功的退出码，逗号分隔，默认0
+	WarningExitCodes string            `json:"warning_exit_codes" db:"warning_exit_codes"` // 视为警告的退出码，逗号分隔
+	CreatedAt        time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at" db:"updated_at"`
+}
+
+// ScriptParameter 脚本参数定义
+type ScriptParameter struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"` // string, int, enum, secret, bool
+	Default     string   `json:"default"`
+	Required    bool     `json:"required"`
+	Options     []string `json:"options,omitempty"` // enum类型的可选值
+	Description string   `json:"description"`
 }
 
 // AnsiblePlaybook Ansible Playbook模型
 type AnsiblePlaybook struct {
-	ID          int       `json:"id" db:"id"`
-	Name        string    `json:"name" db:"name"`
-	Content     string    `json:"content" db:"content"`
-	Variables   string    `json:"variables" db:"variables"` // YAML格式的变量
-	HostGroupID int       `json:"host_group_id" db:"host_group_id"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID                int       `json:"id" db:"id"`
+	Name              string    `json:"name" db:"name"`
+	Content           string    `json:"content" db:"content"`
+	Variables         string    `json:"variables" db:"variables"` // YAML格式的变量
+	HostGroupID       int       `json:"host_group_id" db:"host_group_id"`
+	HostGroupIDs      []int     `json:"host_group_ids" db:"host_group_ids"`           // 除HostGroupID外的其他目标主机组，每个主机组对应inventory中的一个组
+	EntryPoint        string    `json:"entry_point" db:"entry_point"`                 // 项目文件中要执行的Playbook路径，为空时执行Content
+	Source            string    `json:"source" db:"source"`                           // 项目文件来源：空（手动上传）、git、archive
+	SourceURL         string    `json:"source_url" db:"source_url"`                   // Git仓库地址
+	SourceRef         string    `json:"source_ref" db:"source_ref"`                   // 导入的分支、标签或提交
+	SourceCommit      string    `json:"source_commit" db:"source_commit"`             // 导入时解析出的提交
+	VaultCredentialID int       `json:"vault_credential_id" db:"vault_credential_id"` // 解密Vault加密变量和文件的凭据，0表示不使用Vault
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// AnsiblePlaybookFile Playbook项目中的文件，如roles、templates、group_vars、requirements.yml
+type AnsiblePlaybookFile struct {
+	ID         int       `json:"id" db:"id"`
+	PlaybookID int       `json:"playbook_id" db:"playbook_id"`
+	Path       string    `json:"path" db:"path"` // 项目内的相对路径
+	Mode       int       `json:"mode" db:"mode"` // 文件权限，如0644、0755
+	Size       int       `json:"size" db:"size"`
+	Content    []byte    `json:"-" db:"content"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
 }
 
 // ExecutionLog 执行日志模型
 type ExecutionLog struct {
-	ID         int       `json:"id" db:"id"`
-	ScriptID   int       `json:"script_id" db:"script_id"`
-	Host       string    `json:"host" db:"host"`
-	Status     string    `json:"status" db:"status"` // success, failed, timeout
-	Output     string    `json:"output" db:"output"`
-	Error      string    `json:"error" db:"error"`
-	ExecutedAt time.Time `json:"executed_at" db:"executed_at"`
+	ID         int        `json:"id" db:"id"`
+	ScriptID   int        `json:"script_id" db:"script_id"`
+	SessionID  int        `json:"session_id" db:"session_id"`
+	Host       string     `json:"host" db:"host"`
+	Status     string     `json:"status" db:"status"` // success, warning, failed, timeout
+	Output     string     `json:"output" db:"output"` // stdout和stderr合并输出
+	Stdout     string     `json:"stdout" db:"stdout"`
+	Stderr     string     `json:"stderr" db:"stderr"`
+	ExitCode   *int       `json:"exit_code" db:"exit_code"` // 旧数据为空
+	Error      string     `json:"error" db:"error"`
+	StartedAt  *time.Time `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at" db:"finished_at"`
+	DurationMs int64      `json:"duration_ms" db:"duration_ms"`
+	ExecutedAt time.Time  `json:"executed_at" db:"executed_at"`
 }
 
 // AnsibleExecutionLog Ansible执行日志模型
 type AnsibleExecutionLog struct {
-	ID         int       `json:"id" db:"id"`
-	PlaybookID int       `json:"playbook_id" db:"playbook_id"`
-	Host       string    `json:"host" db:"host"`
-	Status     string    `json:"status" db:"status"` // success, failed, timeout
-	Output     string    `json:"output" db:"output"`
-	Error      string    `json:"error" db:"error"`
-	ExecutedAt time.Time `json:"executed_at" db:"executed_at"`
+	ID               int       `json:"id" db:"id"`
+	PlaybookID       int       `json:"playbook_id" db:"playbook_id"`
+	SessionID        int       `json:"session_id" db:"session_id"`
+	Host             string    `json:"host" db:"host"`
+	Status           string    `json:"status" db:"status"` // success, failed, skipped, timeout
+	Output           string    `json:"output" db:"output"`
+	Error            string    `json:"error" db:"error"`
+	OkCount          int       `json:"ok" db:"ok_count"` // 以下为PLAY RECAP中的统计
+	ChangedCount     int       `json:"changed" db:"changed_count"`
+	FailedCount      int       `json:"failed" db:"failed_count"`
+	SkippedCount     int       `json:"skipped" db:"skipped_count"`
+	UnreachableCount int       `json:"unreachable" db:"unreachable_count"`
+	ExecutedAt       time.Time `json:"executed_at" db:"executed_at"`
+}
+
+// AnsibleTaskResult 单个主机上单个任务的执行结果
+type AnsibleTaskResult struct {
+	ID         int        `json:"id" db:"id"`
+	LogID      int        `json:"log_id" db:"log_id"`
+	Host       string     `json:"host" db:"host"`
+	Play       string     `json:"play" db:"play"`
+	Task       string     `json:"task" db:"task"`
+	Status     string     `json:"status" db:"status"` // ok, changed, failed, ignored, skipped, unreachable
+	Message    string     `json:"message" db:"message"`
+	StartedAt  *time.Time `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at" db:"finished_at"`
 }
 
 // ExecutionSession 执行会话模型
@@ -73,15 +151,34 @@ type ExecutionSession struct {
 	ID          int       `json:"id" db:"id"`
 	ScriptID    int       `json:"script_id" db:"script_id"`
 	SessionName string    `json:"session_name" db:"session_name"`
+	Parameters  string    `json:"parameters" db:"parameters"` // 本次执行使用的参数值（JSON，secret已脱敏）
+	Revision    int       `json:"revision" db:"revision"`     // 本次执行的脚本版本号
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
 // AnsibleExecutionSession Ansible执行会话模型
 type AnsibleExecutionSession struct {
-	ID          int       `json:"id" db:"id"`
-	PlaybookID  int       `json:"playbook_id" db:"playbook_id"`
-	SessionName string    `json:"session_name" db:"session_name"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	ID          int        `json:"id" db:"id"`
+	PlaybookID  int        `json:"playbook_id" db:"playbook_id"`
+	SessionName string     `json:"session_name" db:"session_name"`
+	Revision    int        `json:"revision" db:"revision"` // 本次执行的Playbook版本号
+	Status      string     `json:"status" db:"status"`     // running, success, failed，旧会话为空
+	Options     string     `json:"options" db:"options"`   // 执行选项JSON，如check、tags、limit
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at" db:"finished_at"`
+}
+
+// Revision 脚本、Playbook、Docker模板的不可变版本记录
+type Revision struct {
+	ID           int       `json:"id" db:"id"`
+	ResourceType string    `json:"resource_type" db:"resource_type"` // script, ansible_playbook, docker_template
+	ResourceID   int       `json:"resource_id" db:"resource_id"`
+	Revision     int       `json:"revision" db:"revision"`
+	Content      string    `json:"content" db:"content"`   // 用于对比的文本内容
+	Snapshot     string    `json:"snapshot" db:"snapshot"` // 资源的JSON快照，用于恢复
+	Author       string    `json:"author" db:"author"`
+	Message      string    `json:"message" db:"message"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 }
 
 // SystemInfo 系统信息模型
@@ -99,27 +196,57 @@ type SystemInfo struct {
 
 // DeploymentTask 部署任务模型
 type DeploymentTask struct {
-	ID          int       `json:"id" db:"id"`
-	Name        string    `json:"name" db:"name"`
-	GithubURL   string    `json:"github_url" db:"github_url"`
-	Branch      string    `json:"branch" db:"branch"`
-	HostGroupID int       `json:"host_group_id" db:"host_group_id"`
-	Status      string    `json:"status" db:"status"` // pending, running, success, failed
-	Description string    `json:"description" db:"description"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID              int               `json:"id" db:"id"`
+	Name            string            `json:"name" db:"name"`
+	GithubURL       string            `json:"github_url" db:"github_url"`               // 仓库地址，支持任意Git远程：HTTPS、ssh://、git@host:path或本地路径
+	GitCredentialID int               `json:"git_credential_id" db:"git_credential_id"` // 克隆仓库使用的凭据（git_token或ssh_key），0表示公开仓库
+	Branch          string            `json:"branch" db:"branch"`
+	HostGroupID     int               `json:"host_group_id" db:"host_group_id"`
+	Status          string            `json:"status" db:"status"` // pending, running, awaiting_approval, success, failed
+	Description     string            `json:"description" db:"description"`
+	RunAs           string            `json:"run_as" db:"run_as"`           // 部署脚本的执行身份，为空时使用登录用户；升级前创建的任务为root
+	Strategy        string            `json:"strategy" db:"strategy"`       // auto（仓库中的.runme.yml或自动检测）、node、python、go、docker、custom
+	DeployPath      string            `json:"deploy_path" db:"deploy_path"` // 部署根目录（包含releases、current和shared），为空时使用/opt/deployments下的默认目录
+	BuildCommand    string            `json:"build_command" db:"build_command"`
+	StartCommand    string            `json:"start_command" db:"start_command"`
+	StopCommand     string            `json:"stop_command" db:"stop_command"`
+	Env             map[string]string `json:"env" db:"env"`                                 // 构建和运行时的环境变量
+	Ports           []int             `json:"ports" db:"ports"`                             // 应用端口，第一个端口作为PORT环境变量，docker策略映射全部端口
+	ProcessManager  string            `json:"process_manager" db:"process_manager"`         // nohup（后台进程）或systemd（为任务安装systemd服务）
+	ServiceUser     string            `json:"service_user" db:"service_user"`               // systemd服务的运行用户，默认root
+	RestartPolicy   string            `json:"restart_policy" db:"restart_policy"`           // systemd的Restart配置，默认on-failure
+	KeepReleases    int               `json:"keep_releases" db:"keep_releases"`             // 保留的版本目录数量，默认5
+	BatchSize       int               `json:"batch_size" db:"batch_size"`                   // 滚动部署每批的主机数，0表示所有主机一批
+	HealthCheck     string            `json:"health_check" db:"health_check"`               // 部署后的健康检查：空（不检查）、http、tcp、command
+	HealthTarget    string            `json:"health_target" db:"health_target"`             // http为URL或路径，tcp为端口或host:port，command为检查命令
+	HealthTimeout   int               `json:"health_timeout" db:"health_timeout"`           // 等待健康检查通过的秒数，默认30
+	AutoRollback    bool              `json:"auto_rollback" db:"auto_rollback"`             // 批次失败时将本次已切换版本的主机回滚到上一个版本
+	Canary          bool              `json:"canary" db:"canary"`                           // 先部署到第一台主机，批准后再部署其余主机
+	WebhookEnabled  bool              `json:"webhook_enabled" db:"webhook_enabled"`         // 是否接受推送触发的部署
+	WebhookSecret   string            `json:"webhook_secret,omitempty" db:"webhook_secret"` // 校验推送签名的密钥，只在创建和更新时返回
+	BuildMode       string            `json:"build_mode" db:"build_mode"`                   // remote（各主机克隆并构建）或local（在RunMe所在机器上构建一次，通过SFTP分发制品）
+	BuildImage      string            `json:"build_image" db:"build_image"`                 // local模式下执行构建的Docker镜像，local模式必填
+	ArtifactPath    string            `json:"artifact_path" db:"artifact_path"`             // local模式下打包的目录（相对仓库根目录），为空时打包整个仓库
+	InstallCommand  string            `json:"install_command" db:"install_command"`         // local模式下解压制品后在主机上执行的安装命令，替代构建命令
+	CreatedAt       time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at" db:"updated_at"`
 }
 
 // DeploymentLog 部署日志模型
 type DeploymentLog struct {
-	ID          int       `json:"id" db:"id"`
-	TaskID      int       `json:"task_id" db:"task_id"`
-	SessionName string    `json:"session_name" db:"session_name"` // 添加会话名称
-	Host        string    `json:"host" db:"host"`
-	Status      string    `json:"status" db:"status"` // success, failed, running
-	Output      string    `json:"output" db:"output"`
-	Error       string    `json:"error" db:"error"`
-	DeployedAt  time.Time `json:"deployed_at" db:"deployed_at"`
+	ID            int       `json:"id" db:"id"`
+	TaskID        int       `json:"task_id" db:"task_id"`
+	SessionName   string    `json:"session_name" db:"session_name"` // 添加会话名称
+	Host          string    `json:"host" db:"host"`
+	Status        string    `json:"status" db:"status"` // success, failed, running, skipped
+	Output        string    `json:"output" db:"output"`
+	Error         string    `json:"error" db:"error"`
+	ServiceStatus string    `json:"service_status" db:"service_status"` // systemd服务部署后的状态（systemctl is-active），nohup方式为空
+	Release       string    `json:"release" db:"release"`               // 本次部署或回滚到的版本目录名
+	CommitSHA     string    `json:"commit_sha" db:"commit_sha"`         // 版本目录检出的提交
+	CommitMessage string    `json:"commit_message" db:"commit_message"` // 提交说明的第一行
+	Switched      bool      `json:"switched" db:"switched"`             // current是否已切换到该版本，用于确定主机当前部署的版本
+	DeployedAt    time.Time `json:"deployed_at" db:"deployed_at"`
 }
 
 // DeploymentSession 部署会话模型
@@ -127,9 +254,37 @@ type DeploymentSession struct {
 	ID          int       `json:"id" db:"id"`
 	TaskID      int       `json:"task_id" db:"task_id"`
 	SessionName string    `json:"session_name" db:"session_name"`
+	Status      string    `json:"status" db:"status"`         // running, awaiting_approval, success, failed, aborted
+	Release     string    `json:"release" db:"release"`       // 本次部署的版本目录名，回滚会话为空
+	Ref         string    `json:"ref" db:"ref"`               // 部署时指定的标签、提交或分支，为空表示任务分支
+	CommitSHA   string    `json:"commit_sha" db:"commit_sha"` // 触发部署的推送提交，开启金丝雀时为金丝雀检出的提交
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
+// DeploymentArtifact 在RunMe所在机器上构建的部署制品
+type DeploymentArtifact struct {
+	ID            int       `json:"id" db:"id"`
+	TaskID        int       `json:"task_id" db:"task_id"`
+	SessionName   string    `json:"session_name" db:"session_name"`
+	Release       string    `json:"release" db:"release"` // 使用该制品的版本目录名
+	CommitSHA     string    `json:"commit_sha" db:"commit_sha"`
+	CommitMessage string    `json:"commit_message" db:"commit_message"`
+	Path          string    `json:"-" db:"path"`            // 制品文件在数据目录中的路径
+	Size          int64     `json:"size" db:"size"`         // 字节数
+	Checksum      string    `json:"checksum" db:"checksum"` // SHA-256，上传到主机后校验
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// DeployedVersion 主机上当前部署的版本
+type DeployedVersion struct {
+	Host          string     `json:"host"`
+	Release       string     `json:"release"`
+	CommitSHA     string     `json:"commit_sha"`
+	CommitMessage string     `json:"commit_message"`
+	SessionName   string     `json:"session_name"`
+	DeployedAt    *time.Time `json:"deployed_at"` // 从未部署时为空
+}
+
 // User 用户模型
 type User struct {
 	ID        int       `json:"id" db:"id"`
@@ -189,6 +344,90 @@ type DockerTemplate struct {
 	ID            int       `json:"id" db:"id"`
 	Name          string    `json:"name" db:"name"`
 	DockerCommand string    `json:"docker_command" db:"docker_command"` // Docker命令
+	RunAs         string    `json:"run_as" db:"run_as"`                 // 执行身份，为空时使用登录用户
 	CreatedAt     time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
 }
+
+// Schedule 定时执行计划模型
+type Schedule struct {
+	ID          int        `json:"id" db:"id"`
+	Name        string     `json:"name" db:"name"`
+	TargetType  string     `json:"target_type" db:"target_type"` // script, ansible, docker_template, deployment
+	TargetID    int        `json:"target_id" db:"target_id"`
+	CronExpr    string     `json:"cron_expr" db:"cron_expr"` // 标准5段cron表达式
+	Payload     string     `json:"payload" db:"payload"`     // 执行请求（JSON），例如脚本参数和目标主机
+	Enabled     bool       `json:"enabled" db:"enabled"`
+	LastRunAt   *time.Time `json:"last_run_at" db:"last_run_at"`
+	NextRunAt   *time.Time `json:"next_run_at" db:"next_run_at"`
+	LastStatus  string     `json:"last_status" db:"last_status"` // success, failed, running
+	LastMessage string     `json:"last_message" db:"last_message"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Workflow 工作流模型，由多个按依赖关系执行的步骤组成
+type Workflow struct {
+	ID          int            `json:"id" db:"id"`
+	Name        string         `json:"name" db:"name"`
+	Description string         `json:"description" db:"description"`
+	Steps       []WorkflowStep `json:"steps" db:"steps"` // 数据库中以JSON保存
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// WorkflowStep 工作流步骤定义
+type WorkflowStep struct {
+	Key       string          `json:"key"`               // 步骤标识，在工作流内唯一
+	Name      string          `json:"name"`              // 步骤名称
+	Type      string          `json:"type"`              // script, ansible, docker_template, deployment
+	TargetID  int             `json:"target_id"`         // 引用的脚本、Playbook、Docker模板或部署任务ID
+	DependsOn []string        `json:"depends_on"`        // 依赖的步骤，省略时依赖上一个步骤
+	Condition string          `json:"condition"`         // on_success（默认）, on_failure, always
+	Payload   json.RawMessage `json:"payload,omitempty"` // 执行请求，例如脚本参数或Docker模板的host_id
+}
+
+// WorkflowRun 工作流运行记录
+type WorkflowRun struct {
+	ID          int               `json:"id" db:"id"`
+	WorkflowID  int               `json:"workflow_id" db:"workflow_id"`
+	Status      string            `json:"status" db:"status"` // running, success, failed
+	TriggeredBy string            `json:"triggered_by" db:"triggered_by"`
+	StartedAt   time.Time         `json:"started_at" db:"started_at"`
+	FinishedAt  *time.Time        `json:"finished_at" db:"finished_at"`
+	Steps       []WorkflowStepRun `json:"steps,omitempty"`
+}
+
+// WorkflowStepRun 工作流步骤运行记录
+type WorkflowStepRun struct {
+	ID         int        `json:"id" db:"id"`
+	RunID      int        `json:"run_id" db:"run_id"`
+	StepKey    string     `json:"step_key" db:"step_key"`
+	StepName   string     `json:"step_name" db:"step_name"`
+	StepType   string     `json:"step_type" db:"step_type"`
+	TargetID   int        `json:"target_id" db:"target_id"`
+	Status     string     `json:"status" db:"status"` // pending, running, success, failed, skipped
+	Message    string     `json:"message" db:"message"`
+	StartedAt  *time.Time `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at" db:"finished_at"`
+}
+
+// Credential 凭据，secret只用于执行，不通过接口返回
+type Credential struct {
+	ID          int       `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	Type        string    `json:"type" db:"type"`         // vault_password, git_token, ssh_key
+	Username    string    `json:"username" db:"username"` // git_token的HTTPS用户名，为空时使用oauth2
+	Description string    `json:"description" db:"description"`
+	Secret      string    `json:"-" db:"secret"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// RetentionPolicy 执行历史保留策略，0表示不限制
+type RetentionPolicy struct {
+	LogType     string    `json:"log_type"`     // script, ansible, deployment, certificate
+	MaxAgeDays  int       `json:"max_age_days"` // 超过天数的会话及日志会被清理
+	MaxSessions int       `json:"max_sessions"` // 每个脚本/Playbook/部署任务/证书保留的最近会话数
+	UpdatedAt   time.Time `json:"updated_at"`
+}
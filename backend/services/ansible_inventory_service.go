@@ -0,0 +1,299 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"runme-backend/database"
+	"runme-backend/models"
+	"strconv"
+	"strings"
+)
+
+// AnsibleTargetsGroup 执行时包含Playbook全部目标主机的组，未指定hosts的Play默认使用该组
+const AnsibleTargetsGroup = "targets"
+
+var (
+	// inventory主机名允许的字符，其余字符替换为下划线
+	ansibleHostNameInvalid = regexp.MustCompile(`[^A-Za-z0-9._:-]`)
+	// Ansible组名只允许字母、数字和下划线
+	ansibleGroupNameInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+// ansibleReservedGroups Ansible内置组和执行时使用的组，主机组不能使用这些名称
+var ansibleReservedGroups = map[string]bool{
+	"all":               true,
+	"ungrouped":         true,
+	AnsibleTargetsGroup: true,
+}
+
+// AnsibleInventoryGroup inventory中的组，对应一个主机组
+type AnsibleInventoryGroup struct {
+	HostGroupID int
+	Name        string
+	Hosts       []string
+	Vars        map[string]string
+}
+
+// AnsibleInventory 由主机组、主机和变量生成的inventory
+type AnsibleInventory struct {
+	Hosts    []models.Host
+	Names    []string // 与Hosts一一对应的inventory主机名
+	Groups   []AnsibleInventoryGroup
+	HostVars map[string]map[string]interface{}
+}
+
+// ParseHostGroupIDs 解析数据库中以JSON存储的主机组ID列表
+func ParseHostGroupIDs(raw string) ([]int, error) {
+	ids := []int{}
+	if strings.TrimSpace(raw) == "" {
+		return ids, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &ids); err != nil {
+		return nil, fmt.Errorf("invalid host group ids: %v", err)
+	}
+	return ids, nil
+}
+
+// EncodeHostGroupIDs 将主机组ID列表编码为JSON
+func EncodeHostGroupIDs(ids []int) string {
+	if ids == nil {
+		ids = []int{}
+	}
+	data, _ := json.Marshal(ids)
+	return string(data)
+}
+
+// PlaybookHostGroupIDs Playbook的全部目标主机组，HostGroupID在前，去重
+func PlaybookHostGroupIDs(playbook models.AnsiblePlaybook) []int {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, id := range append([]int{playbook.HostGroupID}, playbook.HostGroupIDs...) {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ValidateHostGroupIDs 校验主机组存在
+func ValidateHostGroupIDs(ids []int) error {
+	for _, id := range ids {
+		var exists bool
+		if err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM host_groups WHERE id = ?)", id).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return &InvalidRequestError{Message: fmt.Sprintf("host group %d not found", id)}
+		}
+	}
+	return nil
+}
+
+// BuildAnsibleInventory 为指定主机组生成inventory，groupIDs为空时包含所有主机组
+// includeSecrets为false时不包含登录密码和提权密码，用于对外提供的动态inventory
+func BuildAnsibleInventory(groupIDs []int, includeSecrets bool) (*AnsibleInventory, error) {
+	type hostGroup struct {
+		id   int
+		name string
+	}
+	var groups []hostGroup
+	if len(groupIDs) == 0 {
+		rows, err := database.DB.Query("SELECT id, name FROM host_groups ORDER BY id")
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var g hostGroup
+			if err := rows.Scan(&g.id, &g.name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			groups = append(groups, g)
+		}
+		rows.Close()
+	} else {
+		for _, id := range groupIDs {
+			g := hostGroup{id: id}
+			if err := database.DB.QueryRow("SELECT name FROM host_groups WHERE id = ?", id).Scan(&g.name); err != nil {
+				return nil, fmt.Errorf("host group %d not found: %v", id, err)
+			}
+			groups = append(groups, g)
+		}
+	}
+
+	inv := &AnsibleInventory{HostVars: make(map[string]map[string]interface{})}
+	usedGroups := make(map[string]bool)
+	usedHosts := make(map[string]bool)
+	for _, g := range groups {
+		groupName := uniqueName(ansibleGroupName(g.name), g.id, usedGroups)
+		groupVars, err := GetHostGroupVars(g.id)
+		if err != nil {
+			return nil, err
+		}
+		group := AnsibleInventoryGroup{HostGroupID: g.id, Name: groupName, Hosts: []string{}, Vars: groupVars}
+
+		hosts, err := LoadHostsByGroupID(g.id)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			name := ansibleHostName(host)
+			if usedHosts[name] {
+				// 主机名重复时使用IP，IP也重复时追加主机ID
+				name = uniqueName(ansibleHostNameInvalid.ReplaceAllString(host.IP, "_"), host.ID, usedHosts)
+			}
+			usedHosts[name] = true
+
+			hostVars, err := ansibleHostVars(host, groupName, includeSecrets)
+			if err != nil {
+				return nil, err
+			}
+			inv.Hosts = append(inv.Hosts, host)
+			inv.Names = append(inv.Names, name)
+			inv.HostVars[name] = hostVars
+			group.Hosts = append(group.Hosts, name)
+		}
+		inv.Groups = append(inv.Groups, group)
+	}
+	return inv, nil
+}
+
+// ansibleHostName 主机在inventory中的名称，优先使用主机名，为空时使用IP
+func ansibleHostName(host models.Host) string {
+	name := strings.TrimSpace(host.Hostname)
+	if name == "" {
+		name = host.IP
+	}
+	return ansibleHostNameInvalid.ReplaceAllString(name, "_")
+}
+
+// ansibleGroupName 将主机组名称转换为合法的Ansible组名
+func ansibleGroupName(name string) string {
+	group := ansibleGroupNameInvalid.ReplaceAllString(strings.TrimSpace(name), "_")
+	if group == "" || (group[0] >= '0' && group[0] <= '9') {
+		group = "group_" + group
+	}
+	if ansibleReservedGroups[group] {
+		group = "group_" + group
+	}
+	return group
+}
+
+// uniqueName 名称已被使用时追加ID
+func uniqueName(name string, id int, used map[string]bool) string {
+	if used[name] {
+		name = fmt.Sprintf("%s_%d", name, id)
+	}
+	used[name] = true
+	return name
+}
+
+// ansibleHostVars 主机的连接参数、RunMe元数据和主机变量，主机变量可以覆盖前两者
+func ansibleHostVars(host models.Host, groupName string, includeSecrets bool) (map[string]interface{}, error) {
+	vars := map[string]interface{}{
+		"ansible_host":    host.IP,
+		"ansible_port":    host.Port,
+		"ansible_user":    host.Username,
+		"runme_host_id":   host.ID,
+		"runme_hostname":  host.Hostname,
+		"runme_group_id":  host.HostGroupID,
+		"runme_group":     groupName,
+		"runme_host_tags": splitTags(host.Tags),
+	}
+	if includeSecrets {
+		vars["ansible_password"] = host.Password
+		if host.BecomePassword != "" {
+			vars["ansible_become_password"] = host.BecomePassword
+		} else if host.Password != "" {
+			vars["ansible_become_password"] = host.Password
+		}
+	}
+
+	hostVars, err := GetHostVars(host.ID)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range hostVars {
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+// splitTags 拆分逗号分隔的主机标签
+func splitTags(tags string) []string {
+	result := []string{}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// DynamicInventory 生成动态inventory脚本格式（ansible-inventory --list）的JSON结构
+func (inv *AnsibleInventory) DynamicInventory() map[string]interface{} {
+	result := make(map[string]interface{})
+	children := []string{}
+	for _, group := range inv.Groups {
+		entry := map[string]interface{}{"hosts": group.Hosts}
+		if len(group.Vars) > 0 {
+			entry["vars"] = group.Vars
+		}
+		result[group.Name] = entry
+		children = append(children, group.Name)
+	}
+	result["all"] = map[string]interface{}{"children": children}
+	result["_meta"] = map[string]interface{}{"hostvars": inv.HostVars}
+	return result
+}
+
+// executionInventory 生成执行用的YAML inventory结构（JSON是合法的YAML），额外包含targets组
+func (inv *AnsibleInventory) executionInventory() map[string]interface{} {
+	children := make(map[string]interface{})
+	targets := make(map[string]interface{})
+	for _, group := range inv.Groups {
+		hosts := make(map[string]interface{})
+		for _, name := range group.Hosts {
+			hosts[name] = inv.HostVars[name]
+			targets[name] = nil
+		}
+		entry := map[string]interface{}{"hosts": hosts}
+		if len(group.Vars) > 0 {
+			entry["vars"] = group.Vars
+		}
+		children[group.Name] = entry
+	}
+	children[AnsibleTargetsGroup] = map[string]interface{}{"hosts": targets}
+
+	return map[string]interface{}{
+		"all": map[string]interface{}{
+			"vars": map[string]interface{}{
+				"ansible_ssh_common_args": "-o StrictHostKeyChecking=no",
+			},
+			"children": children,
+		},
+	}
+}
+
+// ParseInventoryGroupIDs 解析逗号分隔或重复的group_id参数并校验主机组存在
+func ParseInventoryGroupIDs(values []string) ([]int, error) {
+	var ids []int
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			id, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, &InvalidRequestError{Message: fmt.Sprintf("invalid group_id %q", part)}
+			}
+			ids = append(ids, id)
+		}
+	}
+	if err := ValidateHostGroupIDs(ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
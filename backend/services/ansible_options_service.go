@@ -0,0 +1,125 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runme-backend/models"
+	"strings"
+)
+
+// ansibleMaxForks 并发主机数上限
+const ansibleMaxForks = 200
+
+// ansibleTagPattern 标签名称，不允许逗号和空白，避免拼接参数时被拆分
+var ansibleTagPattern = regexp.MustCompile(`^[A-Za-z0-9_.:@/-]+$`)
+
+// AnsibleRunOptions Playbook的执行选项，保存在执行会话中用于复现
+type AnsibleRunOptions struct {
+	Check     bool                   `json:"check,omitempty"`
+	Diff      bool                   `json:"diff,omitempty"`
+	Tags      []string               `json:"tags,omitempty"`
+	SkipTags  []string               `json:"skip_tags,omitempty"`
+	Limit     []string               `json:"limit,omitempty"` // 主机IP或主机名，只在这些主机上执行
+	Forks     int                    `json:"forks,omitempty"`
+	ExtraVars map[string]interface{} `json:"extra_vars,omitempty"` // 覆盖Playbook变量
+}
+
+// ValidateAnsibleRunOptions 校验执行选项，limit中的主机在执行时按Playbook的主机组解析
+func ValidateAnsibleRunOptions(opts AnsibleRunOptions) error {
+	for _, list := range []struct {
+		name string
+		tags []string
+	}{{"tags", opts.Tags}, {"skip_tags", opts.SkipTags}} {
+		for _, tag := range list.tags {
+			if !ansibleTagPattern.MatchString(tag) {
+				return &InvalidRequestError{Message: fmt.Sprintf("invalid tag %q in %s", tag, list.name)}
+			}
+		}
+	}
+	if opts.Forks < 0 || opts.Forks > ansibleMaxForks {
+		return &InvalidRequestError{Message: fmt.Sprintf("forks must be between 1 and %d", ansibleMaxForks)}
+	}
+	for _, entry := range opts.Limit {
+		if strings.TrimSpace(entry) == "" {
+			return &InvalidRequestError{Message: "limit must not contain empty hosts"}
+		}
+	}
+	return nil
+}
+
+// ResolveAnsibleLimit 将limit中的主机IP或主机名解析为主机下标，未指定limit时返回nil
+func ResolveAnsibleLimit(hosts []models.Host, limit []string) ([]int, error) {
+	if len(limit) == 0 {
+		return nil, nil
+	}
+	selected := make(map[int]bool)
+	for _, entry := range limit {
+		entry = strings.TrimSpace(entry)
+		found := false
+		for i, host := range hosts {
+			if host.IP == entry || host.Hostname == entry {
+				selected[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, &InvalidRequestError{Message: fmt.Sprintf("limit host %q is not in the playbook's host groups", entry)}
+		}
+	}
+
+	indexes := make([]int, 0, len(selected))
+	for i := range hosts {
+		if selected[i] {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+// commandArgs 生成ansible-playbook参数，extra vars写入临时目录，在Playbook变量之后加载以覆盖同名变量
+func (opts AnsibleRunOptions) commandArgs(inventory *AnsibleInventory, tempDir string) ([]string, error) {
+	var args []string
+	if opts.Check {
+		args = append(args, "--check")
+	}
+	if opts.Diff {
+		args = append(args, "--diff")
+	}
+	if len(opts.Tags) > 0 {
+		args = append(args, "--tags="+strings.Join(opts.Tags, ","))
+	}
+	if len(opts.SkipTags) > 0 {
+		args = append(args, "--skip-tags="+strings.Join(opts.SkipTags, ","))
+	}
+	if opts.Forks > 0 {
+		args = append(args, fmt.Sprintf("--forks=%d", opts.Forks))
+	}
+
+	indexes, err := ResolveAnsibleLimit(inventory.Hosts, opts.Limit)
+	if err != nil {
+		return nil, err
+	}
+	if indexes != nil {
+		names := make([]string, len(indexes))
+		for i, idx := range indexes {
+			names[i] = inventory.Names[idx]
+		}
+		args = append(args, "--limit="+strings.Join(names, ","))
+	}
+
+	if len(opts.ExtraVars) > 0 {
+		data, err := json.Marshal(opts.ExtraVars)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode extra vars: %v", err)
+		}
+		path := filepath.Join(tempDir, "extra_vars.json")
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to create extra vars file: %v", err)
+		}
+		args = append(args, "-e", "@"+path)
+	}
+	return args, nil
+}
@@ -0,0 +1,574 @@
+package services
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runme-backend/database"
+	"runme-backend/models"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Playbook项目文件来源
+const (
+	PlaybookSourceGit     = "git"
+	PlaybookSourceArchive = "archive"
+)
+
+const (
+	// PlaybookFileMaxSize 单个项目文件的大小上限
+	PlaybookFileMaxSize = 4 * 1024 * 1024
+	// PlaybookProjectMaxSize 项目文件总大小上限
+	PlaybookProjectMaxSize = 64 * 1024 * 1024
+	// PlaybookProjectMaxFiles 项目文件数量上限
+	PlaybookProjectMaxFiles = 5000
+	// playbookImportTimeout 从Git仓库导入的超时时间
+	playbookImportTimeout = 5 * time.Minute
+)
+
+// ansibleRequirementFiles 执行前安装依赖的requirements文件及其中包含的依赖类型
+var ansibleRequirementFiles = []struct {
+	path        string
+	roles       bool
+	collections bool
+}{
+	{"requirements.yml", true, true},
+	{"roles/requirements.yml", true, false},
+	{"collections/requirements.yml", false, true},
+}
+
+// PlaybookImportResult 导入项目文件的结果
+type PlaybookImportResult struct {
+	Files   int      `json:"files"`
+	Size    int64    `json:"size"`
+	Skipped []string `json:"skipped"` // 未导入的符号链接等特殊文件
+	Commit  string   `json:"commit,omitempty"`
+}
+
+// CleanPlaybookFilePath 校验并规范化项目内的相对路径
+func CleanPlaybookFilePath(p string) (string, error) {
+	p = strings.TrimSpace(strings.ReplaceAll(p, "\\", "/"))
+	if p == "" {
+		return "", &InvalidRequestError{Message: "file path is required"}
+	}
+	if strings.HasPrefix(p, "/") {
+		return "", &InvalidRequestError{Message: fmt.Sprintf("file path %q must be relative", p)}
+	}
+	cleaned := path.Clean(p)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", &InvalidRequestError{Message: fmt.Sprintf("file path %q is outside the project", p)}
+	}
+	for _, part := range strings.Split(cleaned, "/") {
+		if part == ".git" {
+			return "", &InvalidRequestError{Message: fmt.Sprintf("file path %q must not be inside .git", p)}
+		}
+	}
+	return cleaned, nil
+}
+
+// ListPlaybookFiles 获取Playbook的项目文件列表（不含内容）
+func ListPlaybookFiles(playbookID int) ([]models.AnsiblePlaybookFile, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, playbook_id, path, mode, length(content), updated_at
+		FROM ansible_playbook_files WHERE playbook_id = ? ORDER BY path
+	`, playbookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []models.AnsiblePlaybookFile{}
+	for rows.Next() {
+		var f models.AnsiblePlaybookFile
+		if err := rows.Scan(&f.ID, &f.PlaybookID, &f.Path, &f.Mode, &f.Size, &f.UpdatedAt); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// LoadPlaybookFile 获取单个项目文件及其内容
+func LoadPlaybookFile(playbookID int, filePath string) (*models.AnsiblePlaybookFile, error) {
+	filePath, err := CleanPlaybookFilePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var f models.AnsiblePlaybookFile
+	err = database.DB.QueryRow(`
+		SELECT id, playbook_id, path, mode, content, updated_at
+		FROM ansible_playbook_files WHERE playbook_id = ? AND path = ?
+	`, playbookID, filePath).Scan(&f.ID, &f.PlaybookID, &f.Path, &f.Mode, &f.Content, &f.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	f.Size = len(f.Content)
+	return &f, nil
+}
+
+// SavePlaybookFile 新增或覆盖项目文件，mode为0时保留原权限（新文件为0644）
+func SavePlaybookFile(playbookID int, filePath string, content []byte, mode int) (*models.AnsiblePlaybookFile, error) {
+	filePath, err := CleanPlaybookFilePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > PlaybookFileMaxSize {
+		return nil, &InvalidRequestError{Message: fmt.Sprintf("file %s exceeds %d bytes", filePath, PlaybookFileMaxSize)}
+	}
+	if mode < 0 || mode > 0777 {
+		return nil, &InvalidRequestError{Message: "mode must be between 0 and 0777"}
+	}
+
+	var count int
+	var total int64
+	err = database.DB.QueryRow(
+		"SELECT COUNT(*), COALESCE(SUM(length(content)), 0) FROM ansible_playbook_files WHERE playbook_id = ? AND path != ?",
+		playbookID, filePath,
+	).Scan(&count, &total)
+	if err != nil {
+		return nil, err
+	}
+	if count+1 > PlaybookProjectMaxFiles {
+		return nil, &InvalidRequestError{Message: fmt.Sprintf("project must not contain more than %d files", PlaybookProjectMaxFiles)}
+	}
+	if total+int64(len(content)) > PlaybookProjectMaxSize {
+		return nil, &InvalidRequestError{Message: fmt.Sprintf("project must not exceed %d bytes", PlaybookProjectMaxSize)}
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO ansible_playbook_files (playbook_id, path, content, mode, updated_at)
+		VALUES (?, ?, ?, CASE WHEN ? = 0 THEN 420 ELSE ? END, ?)
+		ON CONFLICT (playbook_id, path) DO UPDATE SET
+			content = excluded.content,
+			mode = CASE WHEN ? = 0 THEN ansible_playbook_files.mode ELSE excluded.mode END,
+			updated_at = excluded.updated_at
+	`, playbookID, filePath, content, mode, mode, time.Now(), mode)
+	if err != nil {
+		return nil, err
+	}
+	return LoadPlaybookFile(playbookID, filePath)
+}
+
+// DeletePlaybookFile 删除项目文件，返回是否存在
+func DeletePlaybookFile(playbookID int, filePath string) (bool, error) {
+	filePath, err := CleanPlaybookFilePath(filePath)
+	if err != nil {
+		return false, err
+	}
+	result, err := database.DB.Exec(
+		"DELETE FROM ansible_playbook_files WHERE playbook_id = ? AND path = ?",
+		playbookID, filePath,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// DeletePlaybookFiles 删除Playbook的全部项目文件
+func DeletePlaybookFiles(playbookID int) error {
+	_, err := database.DB.Exec("DELETE FROM ansible_playbook_files WHERE playbook_id = ?", playbookID)
+	return err
+}
+
+// playbookProject 导入过程中收集的项目文件
+type playbookProject struct {
+	files   []models.AnsiblePlaybookFile
+	size    int64
+	skipped []string
+}
+
+// add 校验并加入一个文件
+func (p *playbookProject) add(filePath string, content []byte, mode os.FileMode) error {
+	cleaned, err := CleanPlaybookFilePath(filePath)
+	if err != nil {
+		return err
+	}
+	if len(content) > PlaybookFileMaxSize {
+		return &InvalidRequestError{Message: fmt.Sprintf("file %s exceeds %d bytes", cleaned, PlaybookFileMaxSize)}
+	}
+	if len(p.files)+1 > PlaybookProjectMaxFiles {
+		return &InvalidRequestError{Message: fmt.Sprintf("project must not contain more than %d files", PlaybookProjectMaxFiles)}
+	}
+	p.size += int64(len(content))
+	if p.size > PlaybookProjectMaxSize {
+		return &InvalidRequestError{Message: fmt.Sprintf("project must not exceed %d bytes", PlaybookProjectMaxSize)}
+	}
+
+	// 只保留可执行位，避免导入特殊权限
+	fileMode := 0644
+	if mode&0111 != 0 {
+		fileMode = 0755
+	}
+	p.files = append(p.files, models.AnsiblePlaybookFile{Path: cleaned, Mode: fileMode, Content: content, Size: len(content)})
+	return nil
+}
+
+// replace 用导入的文件替换Playbook的全部项目文件，并记录来源
+func (p *playbookProject) replace(playbook models.AnsiblePlaybook) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM ansible_playbook_files WHERE playbook_id = ?", playbook.ID); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, f := range p.files {
+		_, err := tx.Exec(
+			"INSERT INTO ansible_playbook_files (playbook_id, path, content, mode, updated_at) VALUES (?, ?, ?, ?, ?)",
+			playbook.ID, f.Path, f.Content, f.Mode, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`
+		UPDATE ansible_playbooks SET entry_point = ?, source = ?, source_url = ?, source_ref = ?, source_commit = ?, updated_at = ?
+		WHERE id = ?
+	`, playbook.EntryPoint, playbook.Source, playbook.SourceURL, playbook.SourceRef, playbook.SourceCommit, now, playbook.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *playbookProject) result(commit string) *PlaybookImportResult {
+	skipped := p.skipped
+	if skipped == nil {
+		skipped = []string{}
+	}
+	return &PlaybookImportResult{Files: len(p.files), Size: p.size, Skipped: skipped, Commit: commit}
+}
+
+// hasFile 项目中是否包含指定文件
+func (p *playbookProject) hasFile(filePath string) bool {
+	for _, f := range p.files {
+		if f.Path == filePath {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEntryPoint 校验入口Playbook存在于导入的文件中
+func (p *playbookProject) checkEntryPoint(entryPoint string) error {
+	if entryPoint == "" || p.hasFile(entryPoint) {
+		return nil
+	}
+	return &InvalidRequestError{Message: fmt.Sprintf("entry point %s not found in the imported files", entryPoint)}
+}
+
+// ImportPlaybookFromGit 从Git仓库导入项目文件，替换原有文件，ref可以是分支、标签或提交，为空时使用默认分支
+func ImportPlaybookFromGit(playbook models.AnsiblePlaybook, repoURL, ref, entryPoint string) (*PlaybookImportResult, error) {
+	repoURL = strings.TrimSpace(repoURL)
+	ref = strings.TrimSpace(ref)
+	if repoURL == "" {
+		return nil, &InvalidRequestError{Message: "git_url is required"}
+	}
+	if strings.HasPrefix(repoURL, "-") || strings.HasPrefix(ref, "-") {
+		return nil, &InvalidRequestError{Message: "git_url and git_ref must not start with '-'"}
+	}
+	entryPoint, err := CleanPlaybookEntryPoint(entryPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "playbook_import_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), playbookImportTimeout)
+	defer cancel()
+
+	fetchRef := ref
+	if fetchRef == "" {
+		fetchRef = "HEAD"
+	}
+	// fetch而不是clone --branch，以便ref可以是提交
+	steps := [][]string{
+		{"init", "--quiet"},
+		{"fetch", "--quiet", "--depth", "1", "--", repoURL, fetchRef},
+		{"checkout", "--quiet", "FETCH_HEAD"},
+	}
+	for _, args := range steps {
+		if _, err := runGit(ctx, tempDir, args...); err != nil {
+			return nil, err
+		}
+	}
+	commit, err := runGit(ctx, tempDir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	project := &playbookProject{}
+	err = filepath.WalkDir(tempDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(tempDir, p)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			project.skipped = append(project.skipped, rel)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return project.add(rel, content, info.Mode())
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := project.checkEntryPoint(entryPoint); err != nil {
+		return nil, err
+	}
+
+	playbook.EntryPoint = entryPoint
+	playbook.Source = PlaybookSourceGit
+	playbook.SourceURL = repoURL
+	playbook.SourceRef = ref
+	playbook.SourceCommit = commit
+	if err := project.replace(playbook); err != nil {
+		return nil, err
+	}
+	return project.result(commit), nil
+}
+
+// runGit 在dir中执行git命令，只允许网络协议，避免读取本地文件或执行ext::命令
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ALLOW_PROTOCOL=https:http:ssh:git",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("git %s timed out", args[0])
+		}
+		return "", &InvalidRequestError{Message: fmt.Sprintf("git %s failed: %s", args[0], strings.TrimSpace(string(output)))}
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ImportPlaybookFromArchive 从tar或tar.gz归档导入项目文件，替换原有文件，stripComponents为去掉的路径前缀层数
+func ImportPlaybookFromArchive(playbook models.AnsiblePlaybook, r io.Reader, stripComponents int, entryPoint string) (*PlaybookImportResult, error) {
+	if stripComponents < 0 {
+		return nil, &InvalidRequestError{Message: "strip_components must not be negative"}
+	}
+	entryPoint, err := CleanPlaybookEntryPoint(entryPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// 根据文件头判断是否为gzip压缩
+	br := bufio.NewReader(r)
+	var reader io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, &InvalidRequestError{Message: fmt.Sprintf("invalid gzip archive: %v", err)}
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	project := &playbookProject{}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &InvalidRequestError{Message: fmt.Sprintf("invalid tar archive: %v", err)}
+		}
+
+		name := strings.TrimPrefix(header.Name, "./")
+		parts := strings.Split(strings.Trim(name, "/"), "/")
+		if len(parts) <= stripComponents {
+			continue
+		}
+		name = strings.Join(parts[stripComponents:], "/")
+
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeReg:
+		default:
+			project.skipped = append(project.skipped, name)
+			continue
+		}
+		if header.Size > PlaybookFileMaxSize {
+			return nil, &InvalidRequestError{Message: fmt.Sprintf("file %s exceeds %d bytes", name, PlaybookFileMaxSize)}
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, &InvalidRequestError{Message: fmt.Sprintf("invalid tar archive: %v", err)}
+		}
+		if err := project.add(name, content, os.FileMode(header.Mode)); err != nil {
+			return nil, err
+		}
+	}
+	if len(project.files) == 0 {
+		return nil, &InvalidRequestError{Message: "archive contains no files"}
+	}
+	if err := project.checkEntryPoint(entryPoint); err != nil {
+		return nil, err
+	}
+
+	playbook.EntryPoint = entryPoint
+	playbook.Source = PlaybookSourceArchive
+	playbook.SourceURL = ""
+	playbook.SourceRef = ""
+	playbook.SourceCommit = ""
+	if err := project.replace(playbook); err != nil {
+		return nil, err
+	}
+	return project.result(""), nil
+}
+
+// CleanPlaybookEntryPoint 规范化入口Playbook路径，空表示执行Playbook内容
+func CleanPlaybookEntryPoint(entryPoint string) (string, error) {
+	if strings.TrimSpace(entryPoint) == "" {
+		return "", nil
+	}
+	return CleanPlaybookFilePath(entryPoint)
+}
+
+// materializePlaybookProject 将项目文件写入dir，返回写入的文件数
+func materializePlaybookProject(playbookID int, dir string) (int, error) {
+	rows, err := database.DB.Query(
+		"SELECT path, mode, content FROM ansible_playbook_files WHERE playbook_id = ?", playbookID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load project files: %v", err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var filePath string
+		var mode int
+		var content []byte
+		if err := rows.Scan(&filePath, &mode, &content); err != nil {
+			return count, fmt.Errorf("failed to load project files: %v", err)
+		}
+		// 路径在保存时已校验，这里再次确认不会写到项目目录之外
+		cleaned, err := CleanPlaybookFilePath(filePath)
+		if err != nil {
+			return count, err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(cleaned))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return count, fmt.Errorf("failed to create directory for %s: %v", cleaned, err)
+		}
+		if err := os.WriteFile(target, content, os.FileMode(mode)); err != nil {
+			return count, fmt.Errorf("failed to write %s: %v", cleaned, err)
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+// installAnsibleRequirements 执行前使用ansible-galaxy安装项目中requirements文件列出的roles和collections
+// 使用-p分别安装到项目的roles和collections目录，不写入控制机的全局目录
+func installAnsibleRequirements(projectDir string, env []string, onLine func(line string, isStderr bool)) error {
+	for _, req := range ansibleRequirementFiles {
+		reqPath := filepath.Join(projectDir, filepath.FromSlash(req.path))
+		content, err := os.ReadFile(reqPath)
+		if err != nil {
+			continue
+		}
+		onLine(fmt.Sprintf("Installing requirements from %s", req.path), false)
+
+		hasRoles, hasCollections := req.roles, req.collections
+		if req.roles && req.collections {
+			hasRoles, hasCollections = ansibleRequirementKinds(content)
+		}
+		var commands [][]string
+		if hasRoles {
+			commands = append(commands, []string{"role", "install", "-r", reqPath, "-p", filepath.Join(projectDir, "roles")})
+		}
+		if hasCollections {
+			commands = append(commands, []string{"collection", "install", "-r", reqPath, "-p", filepath.Join(projectDir, "collections")})
+		}
+		for _, args := range commands {
+			cmd := exec.Command("ansible-galaxy", args...)
+			cmd.Dir = projectDir
+			cmd.Env = env
+			if err := runStreaming(cmd, onLine); err != nil {
+				return fmt.Errorf("failed to install requirements from %s: %v", req.path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ansibleRequirementKinds 判断顶层requirements文件包含的依赖类型
+// 旧格式为roles列表；新格式为包含roles和collections的映射；无法解析时交给ansible-galaxy按roles处理并报告错误
+func ansibleRequirementKinds(content []byte) (roles, collections bool) {
+	var requirements interface{}
+	if err := yaml.Unmarshal(content, &requirements); err != nil {
+		return true, false
+	}
+	switch value := requirements.(type) {
+	case []interface{}:
+		return len(value) > 0, false
+	case map[string]interface{}:
+		roleList, _ := value["roles"].([]interface{})
+		collectionList, _ := value["collections"].([]interface{})
+		return len(roleList) > 0, len(collectionList) > 0
+	}
+	return false, false
+}
+
+// ansibleProjectEnv 将项目的roles和collections目录放在搜索路径最前面，优先使用ansible-galaxy安装到项目中的依赖
+func ansibleProjectEnv(projectDir string) []string {
+	home, _ := os.UserHomeDir()
+	rolesPath := strings.Join([]string{
+		filepath.Join(projectDir, "roles"),
+		filepath.Join(home, ".ansible", "roles"),
+		"/usr/share/ansible/roles",
+		"/etc/ansible/roles",
+	}, ":")
+	collectionsPath := strings.Join([]string{
+		filepath.Join(projectDir, "collections"),
+		filepath.Join(home, ".ansible", "collections"),
+		"/usr/share/ansible/collections",
+	}, ":")
+	return []string{
+		"ANSIBLE_ROLES_PATH=" + rolesPath,
+		"ANSIBLE_COLLECTIONS_PATH=" + collectionsPath,
+	}
+}
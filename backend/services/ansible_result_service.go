@@ -0,0 +1,306 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"runme-backend/database"
+	"runme-backend/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 默认回调插件（ANSIBLE_NOCOLOR=1）输出中需要识别的行
+var (
+	ansiblePlayLine   = regexp.MustCompile(`^PLAY \[(.*)\] \**\s*$`)
+	ansibleTaskLine   = regexp.MustCompile(`^(?:TASK|RUNNING HANDLER) \[(.*)\] \**\s*$`)
+	ansibleRecapTitle = regexp.MustCompile(`^PLAY RECAP \**\s*$`)
+	ansibleResultLine = regexp.MustCompile(`^(ok|changed|skipping|fatal|failed): \[([^\]]+)\]:?(?: (FAILED!|UNREACHABLE!))?(?: \(item=.*?\))?(?: => (.*))?$`)
+	ansibleRecapLine  = regexp.MustCompile(`^(\S+)\s+: ok=(\d+)\s+changed=(\d+)\s+unreachable=(\d+)\s+failed=(\d+)(?:\s+skipped=(\d+))?`)
+)
+
+// ansibleMaxResultLines 结果JSON跨多行时最多读取的行数
+const ansibleMaxResultLines = 2000
+
+// 任务状态的优先级，循环任务按最严重的单项结果记录
+var ansibleStatusRank = map[string]int{
+	"skipped":     1,
+	"ok":          2,
+	"changed":     3,
+	"ignored":     4,
+	"failed":      5,
+	"unreachable": 6,
+}
+
+// ansibleModuleResult 模块返回值中用于生成说明的字段
+type ansibleModuleResult struct {
+	Msg    json.RawMessage `json:"msg"`
+	Stderr string          `json:"stderr"`
+}
+
+// AnsibleRecapStats PLAY RECAP中的主机统计
+type AnsibleRecapStats struct {
+	Ok          int
+	Changed     int
+	Failures    int
+	Skipped     int
+	Unreachable int
+}
+
+// AnsibleHostReport 单个主机的执行结果
+type AnsibleHostReport struct {
+	Stats AnsibleRecapStats
+	Tasks []models.AnsibleTaskResult
+}
+
+// AnsibleOutputParser 逐行解析ansible-playbook的输出，生成每个主机每个任务的结果
+type AnsibleOutputParser struct {
+	play      string
+	task      string
+	startedAt time.Time
+	current   map[string]int // 当前任务在各主机结果中的下标
+	reports   map[string]*AnsibleHostReport
+	recap     bool
+	lastAlias string // 最近一条结果所属主机，用于处理...ignoring
+
+	// 跨多行的结果JSON
+	pending      *strings.Builder
+	pendingAlias string
+	pendingLines int
+}
+
+// NewAnsibleOutputParser 创建输出解析器
+func NewAnsibleOutputParser() *AnsibleOutputParser {
+	return &AnsibleOutputParser{
+		current: make(map[string]int),
+		reports: make(map[string]*AnsibleHostReport),
+	}
+}
+
+// Feed 解析一行输出（不含换行符）
+func (p *AnsibleOutputParser) Feed(line string) {
+	line = strings.TrimRight(line, "\r")
+
+	if p.pending != nil {
+		p.pending.WriteString(line)
+		p.pending.WriteString("\n")
+		p.pendingLines++
+		if json.Valid([]byte(p.pending.String())) {
+			p.applyResultJSON(p.pendingAlias, p.pending.String())
+			p.pending = nil
+		} else if p.pendingLines > ansibleMaxResultLines {
+			p.pending = nil
+		}
+		return
+	}
+
+	switch {
+	case ansibleRecapTitle.MatchString(line):
+		p.recap = true
+	case p.recap:
+		if m := ansibleRecapLine.FindStringSubmatch(line); m != nil {
+			p.report(m[1]).Stats = AnsibleRecapStats{
+				Ok:          atoiOrZero(m[2]),
+				Changed:     atoiOrZero(m[3]),
+				Unreachable: atoiOrZero(m[4]),
+				Failures:    atoiOrZero(m[5]),
+				Skipped:     atoiOrZero(m[6]),
+			}
+		}
+	case ansiblePlayLine.MatchString(line):
+		p.play = ansiblePlayLine.FindStringSubmatch(line)[1]
+	case ansibleTaskLine.MatchString(line):
+		p.task = ansibleTaskLine.FindStringSubmatch(line)[1]
+		p.startedAt = time.Now()
+		p.current = make(map[string]int)
+	case strings.TrimSpace(line) == "...ignoring":
+		p.setStatus(p.lastAlias, "ignored", true)
+	default:
+		if m := ansibleResultLine.FindStringSubmatch(line); m != nil {
+			p.applyResult(m)
+		}
+	}
+}
+
+// applyResult 记录结果行，m依次为状态、主机、FAILED!/UNREACHABLE!、结果JSON
+func (p *AnsibleOutputParser) applyResult(m []string) {
+	// 委派执行时格式为 [host -> delegate]
+	alias := strings.SplitN(m[2], " -> ", 2)[0]
+	status := m[1]
+	switch {
+	case m[3] == "UNREACHABLE!":
+		status = "unreachable"
+	case status == "fatal":
+		status = "failed"
+	case status == "skipping":
+		status = "skipped"
+	}
+	p.setStatus(alias, status, false)
+	p.lastAlias = alias
+
+	result := strings.TrimSpace(m[4])
+	if !strings.HasPrefix(result, "{") {
+		return
+	}
+	if json.Valid([]byte(result)) {
+		p.applyResultJSON(alias, result)
+		return
+	}
+	p.pending = &strings.Builder{}
+	p.pending.WriteString(result)
+	p.pending.WriteString("\n")
+	p.pendingAlias = alias
+	p.pendingLines = 1
+}
+
+// applyResultJSON 从结果JSON中提取说明
+func (p *AnsibleOutputParser) applyResultJSON(alias, raw string) {
+	var result ansibleModuleResult
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		return
+	}
+	idx, ok := p.current[alias]
+	if !ok {
+		return
+	}
+	task := &p.reports[alias].Tasks[idx]
+
+	var msg string
+	if len(result.Msg) > 0 && string(result.Msg) != "null" {
+		if err := json.Unmarshal(result.Msg, &msg); err != nil {
+			msg = string(result.Msg)
+		}
+	}
+	if (task.Status == "failed" || task.Status == "unreachable") && result.Stderr != "" {
+		if msg != "" {
+			msg += "\n"
+		}
+		msg += result.Stderr
+	}
+	// 循环任务保留第一条说明，通常比汇总信息更具体
+	if msg != "" && task.Message == "" {
+		task.Message = msg
+	}
+}
+
+// setStatus 更新主机在当前任务上的状态，force为false时只保留更严重的状态
+func (p *AnsibleOutputParser) setStatus(alias, status string, force bool) {
+	if alias == "" {
+		return
+	}
+	r := p.report(alias)
+	now := time.Now()
+	if idx, ok := p.current[alias]; ok {
+		task := &r.Tasks[idx]
+		if force || ansibleStatusRank[status] > ansibleStatusRank[task.Status] {
+			task.Status = status
+		}
+		task.FinishedAt = &now
+		return
+	}
+
+	startedAt := p.startedAt
+	r.Tasks = append(r.Tasks, models.AnsibleTaskResult{
+		Play:       p.play,
+		Task:       p.task,
+		Status:     status,
+		StartedAt:  &startedAt,
+		FinishedAt: &now,
+	})
+	p.current[alias] = len(r.Tasks) - 1
+}
+
+func (p *AnsibleOutputParser) report(alias string) *AnsibleHostReport {
+	if p.reports[alias] == nil {
+		p.reports[alias] = &AnsibleHostReport{}
+	}
+	return p.reports[alias]
+}
+
+// Completed 是否已解析到PLAY RECAP
+func (p *AnsibleOutputParser) Completed() bool {
+	return p.recap
+}
+
+// Reports 按inventory中的主机别名返回结果
+func (p *AnsibleOutputParser) Reports() map[string]*AnsibleHostReport {
+	return p.reports
+}
+
+func atoiOrZero(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// Failed 主机是否有失败或不可达的任务
+func (r *AnsibleHostReport) Failed() bool {
+	return r.Stats.Failures > 0 || r.Stats.Unreachable > 0
+}
+
+// FirstError 第一个失败任务的说明
+func (r *AnsibleHostReport) FirstError() string {
+	for _, task := range r.Tasks {
+		if task.Status == "failed" || task.Status == "unreachable" {
+			return fmt.Sprintf("TASK [%s] %s: %s", task.Task, task.Status, task.Message)
+		}
+	}
+	return fmt.Sprintf("%d tasks failed, %d unreachable", r.Stats.Failures, r.Stats.Unreachable)
+}
+
+// Render 生成该主机的可读执行输出
+func (r *AnsibleHostReport) Render() string {
+	var b strings.Builder
+	play := ""
+	for i, task := range r.Tasks {
+		if i == 0 || task.Play != play {
+			play = task.Play
+			fmt.Fprintf(&b, "PLAY [%s]\n", play)
+		}
+		fmt.Fprintf(&b, "TASK [%s] %s", task.Task, task.Status)
+		if task.Message != "" {
+			fmt.Fprintf(&b, ": %s", task.Message)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "PLAY RECAP ok=%d changed=%d unreachable=%d failed=%d skipped=%d\n",
+		r.Stats.Ok, r.Stats.Changed, r.Stats.Unreachable, r.Stats.Failures, r.Stats.Skipped)
+	return b.String()
+}
+
+// SaveAnsibleTaskResults 保存主机的任务结果
+func SaveAnsibleTaskResults(logID int64, host string, tasks []models.AnsibleTaskResult) error {
+	for _, task := range tasks {
+		_, err := database.DB.Exec(`
+			INSERT INTO ansible_task_results (log_id, host, play, task, status, message, started_at, finished_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, logID, host, task.Play, task.Task, task.Status, task.Message, task.StartedAt, task.FinishedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListAnsibleTaskResults 获取执行日志的任务结果
+func ListAnsibleTaskResults(logID int) ([]models.AnsibleTaskResult, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, log_id, host, play, task, status, message, started_at, finished_at
+		FROM ansible_task_results WHERE log_id = ? ORDER BY id
+	`, logID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []models.AnsibleTaskResult{}
+	for rows.Next() {
+		var t models.AnsibleTaskResult
+		err := rows.Scan(&t.ID, &t.LogID, &t.Host, &t.Play, &t.Task, &t.Status, &t.Message, &t.StartedAt, &t.FinishedAt)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, nil
+}
@@ -1,97 +1,329 @@
 package services
 
 import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
 	"fmt"
-	"os"
+	"io"
+	"log"
 	"os/exec"
 	"path/filepath"
+	"runme-backend/database"
 	"runme-backend/models"
 	"strings"
+	"sync"
+	"time"
 )
 
-// ExecuteAnsiblePlaybook 在当前机器上执行Ansible Playbook，连接到多台目标主机
-func ExecuteAnsiblePlaybook(hosts []models.Host, playbookContent, variables string) (string, error) {
-	// 创建临时目录
-	tempDir, err := os.MkdirTemp("", "ansible_*")
+// AnsibleRun 已创建会话、等待执行的Playbook任务
+type AnsibleRun struct {
+	Playbook    models.AnsiblePlaybook
+	Hosts       []models.Host
+	Inventory   *AnsibleInventory
+	SessionID   int64
+	SessionName string
+	Revision    int
+	Options     AnsibleRunOptions
+	stream      *ansibleStream // 创建会话时打开，客户端拿到session_id后即可订阅
+}
+
+// LoadAnsiblePlaybook 根据ID获取Playbook
+func LoadAnsiblePlaybook(id int) (*models.AnsiblePlaybook, error) {
+	var playbook models.AnsiblePlaybook
+	var hostGroupIDs string
+	err := database.DB.QueryRow(`
+		SELECT id, name, content, variables, host_group_id, host_group_ids, entry_point, source, source_url, source_ref,
+		       source_commit, vault_credential_id
+		FROM ansible_playbooks
+		WHERE id = ?
+	`, id).Scan(&playbook.ID, &playbook.Name, &playbook.Content, &playbook.Variables, &playbook.HostGroupID, &hostGroupIDs,
+		&playbook.EntryPoint, &playbook.Source, &playbook.SourceURL, &playbook.SourceRef, &playbook.SourceCommit,
+		&playbook.VaultCredentialID)
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp directory: %v", err)
+		return nil, err
+	}
+	if playbook.HostGroupIDs, err = ParseHostGroupIDs(hostGroupIDs); err != nil {
+		return nil, err
 	}
-	defer os.RemoveAll(tempDir)
+	return &playbook, nil
+}
 
-	// 创建inventory文件，包含所有主机
-	inventoryPath := filepath.Join(tempDir, "inventory")
-	var inventoryContent strings.Builder
-	inventoryContent.WriteString("[targets]\n")
+// PrepareAnsibleRun 获取Playbook的目标主机，校验执行选项，记录执行版本并创建执行会话
+func PrepareAnsibleRun(playbook models.AnsiblePlaybook, opts AnsibleRunOptions) (*AnsibleRun, error) {
+	if err := ValidateAnsibleRunOptions(opts); err != nil {
+		return nil, err
+	}
+	inventory, err := BuildAnsibleInventory(PlaybookHostGroupIDs(playbook), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch hosts: %v", err)
+	}
+	hosts := inventory.Hosts
+	if len(hosts) == 0 {
+		return nil, &InvalidRequestError{Message: "No valid hosts found"}
+	}
+	if _, err := ResolveAnsibleLimit(hosts, opts.Limit); err != nil {
+		return nil, err
+	}
+	// 执行前按YAML结构校验Playbook和hosts模式
+	content, err := loadPlaybookSource(playbook)
+	if err == sql.ErrNoRows {
+		return nil, &InvalidRequestError{Message: fmt.Sprintf("entry point %s not found in project files", playbook.EntryPoint)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, plays, err := preparePlaybookContent(content)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPlaybookHostPatterns(plays, inventory); err != nil {
+		return nil, err
+	}
+	optionsJSON, err := json.Marshal(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode run options: %v", err)
+	}
 
-	for i, host := range hosts {
-		inventoryContent.WriteString(fmt.Sprintf(
-			"%s ansible_host=%s ansible_user=%s ansible_password=%s ansible_port=%d ansible_ssh_common_args='-o StrictHostKeyChecking=no'\n",
-			fmt.Sprintf("host%d", i+1), host.IP, host.Username, host.Password, host.Port,
-		))
+	// 记录本次执行的Playbook版本
+	revision, err := EnsurePlaybookRevision(playbook)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve playbook revision: %v", err)
 	}
 
-	err = os.WriteFile(inventoryPath, []byte(inventoryContent.String()), 0644)
+	// 创建执行会话
+	sessionName := fmt.Sprintf("%s_%d", playbook.Name, time.Now().Unix())
+	result, err := database.DB.Exec(
+		"INSERT INTO ansible_execution_sessions (playbook_id, session_name, revision, status, options, created_at) VALUES (?, ?, ?, 'running', ?, ?)",
+		playbook.ID, sessionName, revision, string(optionsJSON), time.Now(),
+	)
 	if err != nil {
-		return "", fmt.Errorf("failed to create inventory file: %v", err)
-	}
-
-	// 创建playbook文件，确保hosts设置为all或targets
-	playbookPath := filepath.Join(tempDir, "playbook.yml")
-
-	// 如果playbook中没有指定hosts，自动添加hosts: targets
-	processedPlaybook := playbookContent
-	if !strings.Contains(playbookContent, "hosts:") {
-		// 在playbook开头添加hosts配置
-		lines := strings.Split(playbookContent, "\n")
-		if len(lines) > 0 && strings.HasPrefix(lines[0], "---") {
-			// 如果有YAML开头标记，在第二行插入
-			processedPlaybook = lines[0] + "\n- hosts: targets\n" + strings.Join(lines[1:], "\n")
-		} else {
-			// 否则在开头插入
-			processedPlaybook = "- hosts: targets\n" + playbookContent
+		return nil, fmt.Errorf("failed to create execution session: %v", err)
+	}
+	sessionID, _ := result.LastInsertId()
+
+	return &AnsibleRun{
+		Playbook:    playbook,
+		Hosts:       hosts,
+		Inventory:   inventory,
+		SessionID:   sessionID,
+		SessionName: sessionName,
+		Revision:    revision,
+		Options:     opts,
+		stream:      openAnsibleStream(sessionID),
+	}, nil
+}
+
+// Execute 在当前机器上执行Playbook，输出实时推送给订阅者并定期保存到会话，结束后为每个主机保存执行日志和任务结果
+func (r *AnsibleRun) Execute() error {
+	stream := r.stream
+	stopFlushing := stream.startFlushing()
+	parser := NewAnsibleOutputParser()
+
+	err := ExecuteAnsiblePlaybook(r.Inventory, r.Playbook, r.Options, func(line string, isStderr bool) {
+		if !isStderr {
+			parser.Feed(line)
+		}
+		stream.publish(line)
+	})
+	if err != nil {
+		stream.publish(err.Error())
+	}
+	stopFlushing()
+	output := stream.Output()
+	reports := parser.Reports()
+
+	// 指定limit时只为选中的主机记录日志
+	limited, _ := ResolveAnsibleLimit(r.Hosts, r.Options.Limit)
+	selected := make(map[int]bool, len(limited))
+	for _, i := range limited {
+		selected[i] = true
+	}
+
+	for i, host := range r.Hosts {
+		if limited != nil && !selected[i] {
+			continue
+		}
+		entry := models.AnsibleExecutionLog{
+			PlaybookID: r.Playbook.ID,
+			Host:       host.IP,
+			ExecutedAt: time.Now(),
+		}
+
+		report := reports[r.Inventory.Names[i]]
+		switch {
+		case !parser.Completed():
+			// 没有PLAY RECAP（如Playbook语法错误），所有主机记录相同的输出
+			entry.Output = output
+			entry.Status = "success"
+			if err != nil {
+				entry.Status = "failed"
+				entry.Error = err.Error()
+			}
+		case report == nil:
+			entry.Status = "skipped"
+			entry.Output = "No tasks were run on this host\n"
+		default:
+			entry.Output = report.Render()
+			entry.OkCount = report.Stats.Ok
+			entry.ChangedCount = report.Stats.Changed
+			entry.FailedCount = report.Stats.Failures
+			entry.SkippedCount = report.Stats.Skipped
+			entry.UnreachableCount = report.Stats.Unreachable
+			entry.Status = "success"
+			if report.Failed() {
+				entry.Status = "failed"
+				entry.Error = report.FirstError()
+			}
+		}
+
+		// 保存执行日志，超过阈值的输出写入日志文件
+		inline, _ := TruncateOutput(entry.Output)
+		res, saveErr := database.DB.Exec(`
+			INSERT INTO ansible_execution_logs (playbook_id, session_id, host, status, output, error,
+			                                    ok_count, changed_count, failed_count, skipped_count, unreachable_count, executed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, entry.PlaybookID, r.SessionID, entry.Host, entry.Status, inline, entry.Error,
+			entry.OkCount, entry.ChangedCount, entry.FailedCount, entry.SkippedCount, entry.UnreachableCount, entry.ExecutedAt,
+		)
+		if saveErr != nil {
+			log.Printf("Failed to save execution log for host %s: %v", host.IP, saveErr)
+			continue
+		}
+		logID, _ := res.LastInsertId()
+		if err := SpillLogOutput(LogTypeAnsible, logID, "output", entry.Output); err != nil {
+			log.Printf("Failed to store output for host %s: %v", host.IP, err)
+		}
+		if report != nil {
+			if err := SaveAnsibleTaskResults(logID, host.IP, report.Tasks); err != nil {
+				log.Printf("Failed to save task results for host %s: %v", host.IP, err)
+			}
 		}
 	}
 
-	err = os.WriteFile(playbookPath, []byte(processedPlaybook), 0644)
+	r.finishSession(output, err)
+	stream.close()
+	return err
+}
+
+// finishSession 更新会话状态，超过阈值的输出写入日志文件
+func (r *AnsibleRun) finishSession(output string, runErr error) {
+	status := "success"
+	if runErr != nil {
+		status = "failed"
+	}
+	inline, _ := TruncateOutput(output)
+	_, err := database.DB.Exec(
+		"UPDATE ansible_execution_sessions SET status = ?, output = ?, finished_at = ? WHERE id = ?",
+		status, inline, time.Now(), r.SessionID,
+	)
 	if err != nil {
-		return "", fmt.Errorf("failed to create playbook file: %v", err)
+		log.Printf("Failed to finish ansible session %d: %v", r.SessionID, err)
+	}
+	if err := SpillLogOutput(LogTypeAnsibleSession, r.SessionID, "output", output); err != nil {
+		log.Printf("Failed to store output for ansible session %d: %v", r.SessionID, err)
 	}
+}
 
-	// 如果有变量，创建变量文件
-	var varsFile string
-	if variables != "" {
-		varsPath := filepath.Join(tempDir, "vars.yml")
-		err = os.WriteFile(varsPath, []byte(variables), 0644)
-		if err != nil {
-			return "", fmt.Errorf("failed to create vars file: %v", err)
+// ExecuteAnsiblePlaybookByID 加载Playbook并按指定选项同步执行，供定时任务和工作流调用
+func ExecuteAnsiblePlaybookByID(id int, opts AnsibleRunOptions) (*AnsibleRun, error) {
+	playbook, err := LoadAnsiblePlaybook(id)
+	if err != nil {
+		return nil, fmt.Errorf("playbook %d not found: %v", id, err)
+	}
+
+	run, err := PrepareAnsibleRun(*playbook, opts)
+	if err != nil {
+		return nil, err
+	}
+	return run, run.Execute()
+}
+
+// ExecuteAnsiblePlaybook 在当前机器上使用生成的inventory执行Ansible Playbook，连接到多台目标主机
+// 项目文件写入临时目录，存在requirements.yml时先安装依赖，执行前进行语法检查；输出按行回调，isStderr表示该行来自stderr（警告和错误信息）
+func ExecuteAnsiblePlaybook(inventory *AnsibleInventory, playbook models.AnsiblePlaybook, opts AnsibleRunOptions, onLine func(line string, isStderr bool)) error {
+	workspace, err := prepareAnsibleWorkspace(inventory, playbook)
+	if err != nil {
+		return err
+	}
+	defer workspace.cleanup()
+
+	// 输出中出现的Vault密码替换为掩码
+	if len(workspace.secrets) > 0 {
+		emit := onLine
+		onLine = func(line string, isStderr bool) {
+			emit(redactSecrets(line, workspace.secrets), isStderr)
 		}
-		varsFile = fmt.Sprintf("-e @%s", varsPath)
 	}
 
-	// 构建ansible-playbook命令
-	cmdArgs := []string{
-		"-i", inventoryPath,
-		playbookPath,
-		"--timeout=30",
-		"-v", // 增加详细输出
+	// 安装requirements.yml中的roles和collections
+	if err := installAnsibleRequirements(workspace.projectDir, workspace.env, onLine); err != nil {
+		return err
 	}
+	if err := workspace.syntaxCheck(onLine); err != nil {
+		return err
+	}
+
+	// 构建ansible-playbook命令
+	cmdArgs := append(append([]string{}, workspace.args...), "--timeout=30")
 
-	if varsFile != "" {
-		cmdArgs = append(cmdArgs, strings.Split(varsFile, " ")...)
+	// 执行选项，extra vars在Playbook变量之后加载
+	optionArgs, err := opts.commandArgs(inventory, workspace.dir)
+	if err != nil {
+		return err
 	}
+	cmdArgs = append(cmdArgs, optionArgs...)
 
-	// 执行命令
+	// 执行命令，工作目录为项目目录以便读取项目中的ansible.cfg
 	cmd := exec.Command("ansible-playbook", cmdArgs...)
-	cmd.Env = append(os.Environ(),
-		"ANSIBLE_HOST_KEY_CHECKING=False",
-		"ANSIBLE_TIMEOUT=30",
-		"ANSIBLE_SSH_RETRIES=3",
-	)
+	cmd.Dir = workspace.projectDir
+	cmd.Env = workspace.env
 
-	output, err := cmd.CombinedOutput()
+	if err := runStreaming(cmd, onLine); err != nil {
+		return fmt.Errorf("ansible-playbook execution failed: %v", err)
+	}
+	return nil
+}
+
+// runStreaming 执行命令并按行回调stdout和stderr，回调可能并发调用
+func runStreaming(cmd *exec.Cmd, onLine func(line string, isStderr bool)) error {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stdout: %v", err)
+	}
+	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return string(output), fmt.Errorf("ansible-playbook execution failed: %v", err)
+		return fmt.Errorf("failed to open stderr: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %v", filepath.Base(cmd.Path), err)
 	}
 
-	return string(output), nil
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		readLines(stdout, func(line string) { onLine(line, false) })
+	}()
+	go func() {
+		defer wg.Done()
+		readLines(stderr, func(line string) { onLine(line, true) })
+	}()
+	wg.Wait()
+
+	return cmd.Wait()
+}
+
+// readLines 逐行读取直到EOF，不限制单行长度
+func readLines(r io.Reader, onLine func(string)) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			onLine(strings.TrimRight(line, "\r\n"))
+		}
+		if err != nil {
+			return
+		}
+	}
 }
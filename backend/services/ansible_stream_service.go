@@ -0,0 +1,168 @@
+package services
+
+import (
+	"log"
+	"runme-backend/database"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ansibleStreamBuffer 每个订阅者的缓冲行数，写满时断开该订阅者，客户端重新订阅即可
+const ansibleStreamBuffer = 1024
+
+// ansibleFlushInterval 执行输出写入数据库的间隔
+const ansibleFlushInterval = time.Second
+
+// ansibleStream 正在执行的Playbook会话输出，供订阅者实时读取
+type ansibleStream struct {
+	mu          sync.Mutex
+	sessionID   int64
+	lines       []string
+	unflushed   strings.Builder
+	subscribers map[chan string]struct{}
+	closed      bool
+}
+
+var (
+	ansibleStreamsMu sync.Mutex
+	ansibleStreams   = make(map[int64]*ansibleStream)
+)
+
+// openAnsibleStream 为会话创建输出流
+func openAnsibleStream(sessionID int64) *ansibleStream {
+	stream := &ansibleStream{
+		sessionID:   sessionID,
+		subscribers: make(map[chan string]struct{}),
+	}
+	ansibleStreamsMu.Lock()
+	ansibleStreams[sessionID] = stream
+	ansibleStreamsMu.Unlock()
+	return stream
+}
+
+// SubscribeAnsibleStream 订阅会话的实时输出，返回已输出的内容和后续输出
+// 会话不在执行中时live为false，调用方应从数据库读取输出
+func SubscribeAnsibleStream(sessionID int64) (backlog []string, lines <-chan string, unsubscribe func(), live bool) {
+	ansibleStreamsMu.Lock()
+	stream := ansibleStreams[sessionID]
+	ansibleStreamsMu.Unlock()
+	if stream == nil {
+		return nil, nil, nil, false
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.closed {
+		return nil, nil, nil, false
+	}
+
+	ch := make(chan string, ansibleStreamBuffer)
+	stream.subscribers[ch] = struct{}{}
+	backlog = append([]string(nil), stream.lines...)
+	unsubscribe = func() {
+		stream.mu.Lock()
+		defer stream.mu.Unlock()
+		if _, ok := stream.subscribers[ch]; ok {
+			delete(stream.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, unsubscribe, true
+}
+
+// publish 追加一行输出并推送给订阅者
+func (s *ansibleStream) publish(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lines = append(s.lines, line)
+	s.unflushed.WriteString(line)
+	s.unflushed.WriteString("\n")
+	for ch := range s.subscribers {
+		select {
+		case ch <- line:
+		default:
+			// 订阅者读取过慢，断开连接避免阻塞执行
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Output 已输出的全部内容
+func (s *ansibleStream) Output() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.lines) == 0 {
+		return ""
+	}
+	return strings.Join(s.lines, "\n") + "\n"
+}
+
+// flush 将未保存的输出追加到会话记录
+func (s *ansibleStream) flush() {
+	s.mu.Lock()
+	chunk := s.unflushed.String()
+	s.unflushed.Reset()
+	s.mu.Unlock()
+
+	if chunk == "" {
+		return
+	}
+	_, err := database.DB.Exec(
+		"UPDATE ansible_execution_sessions SET output = output || ? WHERE id = ?",
+		chunk, s.sessionID,
+	)
+	if err != nil {
+		log.Printf("Failed to save output for ansible session %d: %v", s.sessionID, err)
+	}
+}
+
+// startFlushing 定期保存输出，返回的函数停止保存并写入剩余输出
+func (s *ansibleStream) startFlushing() func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ansibleFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.flush()
+			case <-done:
+				s.flush()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// close 结束输出流，关闭所有订阅
+func (s *ansibleStream) close() {
+	ansibleStreamsMu.Lock()
+	delete(ansibleStreams, s.sessionID)
+	ansibleStreamsMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// MarkInterruptedAnsibleSessions 服务重启后将仍处于running状态的会话标记为失败
+func MarkInterruptedAnsibleSessions() error {
+	_, err := database.DB.Exec(
+		"UPDATE ansible_execution_sessions SET status = 'failed', finished_at = ? WHERE status = 'running'",
+		time.Now(),
+	)
+	return err
+}
@@ -0,0 +1,446 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runme-backend/models"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// syntaxCheckMaxOutputLines 语法检查失败时错误信息中保留的输出行数
+const syntaxCheckMaxOutputLines = 20
+
+// ansibleVaultHeader Vault加密文件的开头
+const ansibleVaultHeader = "$ANSIBLE_VAULT;"
+
+// playbookPlay Playbook中的一个Play
+type playbookPlay struct {
+	Index  int        // 从1开始的序号，用于错误信息
+	Node   *yaml.Node // Play的映射节点
+	Hosts  []string   // hosts中的主机模式，未指定时为nil
+	Import bool       // import_playbook，不需要hosts
+}
+
+// ansibleImportPlaybookKeys 引入其他Playbook的Play关键字
+var ansibleImportPlaybookKeys = map[string]bool{
+	"import_playbook":                 true,
+	"ansible.builtin.import_playbook": true,
+}
+
+// parsePlaybookPlays 将Playbook解析为YAML节点并校验结构：单个文档，内容为Play列表，hosts为字符串或字符串列表
+func parsePlaybookPlays(content []byte) (*yaml.Node, []playbookPlay, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	var doc yaml.Node
+	if err := decoder.Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, nil, &InvalidRequestError{Message: "playbook is empty"}
+		}
+		return nil, nil, &InvalidRequestError{Message: fmt.Sprintf("invalid playbook YAML: %v", err)}
+	}
+	var extra yaml.Node
+	if err := decoder.Decode(&extra); err != io.EOF {
+		if err != nil {
+			return nil, nil, &InvalidRequestError{Message: fmt.Sprintf("invalid playbook YAML: %v", err)}
+		}
+		return nil, nil, &InvalidRequestError{Message: "playbook must contain a single YAML document"}
+	}
+
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.SequenceNode {
+		return nil, nil, &InvalidRequestError{Message: "playbook must be a list of plays"}
+	}
+	root := doc.Content[0]
+	if len(root.Content) == 0 {
+		return nil, nil, &InvalidRequestError{Message: "playbook contains no plays"}
+	}
+
+	plays := make([]playbookPlay, 0, len(root.Content))
+	for i, node := range root.Content {
+		play := playbookPlay{Index: i + 1, Node: resolveYAMLAlias(node)}
+		if play.Node.Kind != yaml.MappingNode {
+			return nil, nil, &InvalidRequestError{Message: fmt.Sprintf("play %d (line %d) must be a mapping", play.Index, node.Line)}
+		}
+		for j := 0; j+1 < len(play.Node.Content); j += 2 {
+			key, value := play.Node.Content[j].Value, resolveYAMLAlias(play.Node.Content[j+1])
+			if ansibleImportPlaybookKeys[key] {
+				play.Import = true
+			}
+			if key != "hosts" {
+				continue
+			}
+			hosts, err := playbookHostPatterns(value)
+			if err != nil {
+				return nil, nil, &InvalidRequestError{Message: fmt.Sprintf("play %d (line %d): %v", play.Index, value.Line, err)}
+			}
+			play.Hosts = hosts
+		}
+		plays = append(plays, play)
+	}
+	return &doc, plays, nil
+}
+
+// playbookHostPatterns 读取hosts的值，支持字符串（逗号分隔）和字符串列表
+func playbookHostPatterns(node *yaml.Node) ([]string, error) {
+	var values []string
+	switch node.Kind {
+	case yaml.ScalarNode:
+		values = []string{node.Value}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			item = resolveYAMLAlias(item)
+			if item.Kind != yaml.ScalarNode {
+				return nil, errors.New("hosts must be a string or a list of strings")
+			}
+			values = append(values, item.Value)
+		}
+	default:
+		return nil, errors.New("hosts must be a string or a list of strings")
+	}
+
+	patterns := []string{}
+	for _, value := range values {
+		for _, pattern := range strings.Split(value, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	if len(patterns) == 0 {
+		return nil, errors.New("hosts must not be empty")
+	}
+	return patterns, nil
+}
+
+// resolveYAMLAlias 返回别名指向的节点
+func resolveYAMLAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// rewritePlaybookHosts 为未指定hosts的Play添加hosts: targets，已指定的hosts保持不变，返回是否有修改
+func rewritePlaybookHosts(plays []playbookPlay) bool {
+	changed := false
+	for _, play := range plays {
+		if play.Import || play.Hosts != nil {
+			continue
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "hosts"}
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: AnsibleTargetsGroup}
+		play.Node.Content = append([]*yaml.Node{key, value}, play.Node.Content...)
+		changed = true
+	}
+	return changed
+}
+
+// preparePlaybookContent 解析Playbook并改写未指定的hosts，无需改写时原样返回以保留格式
+// 整个文件使用Vault加密时无法解析，原样返回，由语法检查校验
+func preparePlaybookContent(content []byte) ([]byte, []playbookPlay, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte(ansibleVaultHeader)) {
+		return content, nil, nil
+	}
+	doc, plays, err := parsePlaybookPlays(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !rewritePlaybookHosts(plays) {
+		return content, plays, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode playbook: %v", err)
+	}
+	encoder.Close()
+	return buf.Bytes(), plays, nil
+}
+
+// checkPlaybookHostPatterns 校验每个Play的主机模式能匹配inventory中的组或主机，避免Play被ansible静默跳过
+// 包含通配符、正则、下标或模板变量的模式无法静态判断，不做校验
+func checkPlaybookHostPatterns(plays []playbookPlay, inventory *AnsibleInventory) error {
+	known := map[string]bool{"all": true, "ungrouped": true, "localhost": true, AnsibleTargetsGroup: true}
+	for _, group := range inventory.Groups {
+		known[group.Name] = true
+	}
+	for _, name := range inventory.Names {
+		known[name] = true
+	}
+
+	for _, play := range plays {
+		for _, pattern := range play.Hosts {
+			for _, term := range splitHostPattern(pattern, known) {
+				term = strings.TrimLeft(term, "!&")
+				if term == "" || strings.ContainsAny(term, "*?[~{") || known[term] {
+					continue
+				}
+				return &InvalidRequestError{Message: fmt.Sprintf(
+					"play %d: hosts pattern %q matches no group or host of the playbook's host groups (available groups: %s)",
+					play.Index, term, strings.Join(inventoryGroupNames(inventory), ", "),
+				)}
+			}
+		}
+	}
+	return nil
+}
+
+// splitHostPattern 按ansible的旧语法用冒号拆分模式，整体是已知名称（如IPv6地址）时不拆分
+func splitHostPattern(pattern string, known map[string]bool) []string {
+	if known[strings.TrimLeft(pattern, "!&")] {
+		return []string{pattern}
+	}
+	return strings.Split(pattern, ":")
+}
+
+// inventoryGroupNames inventory中可用于hosts的组名
+func inventoryGroupNames(inventory *AnsibleInventory) []string {
+	names := []string{"all", AnsibleTargetsGroup}
+	for _, group := range inventory.Groups {
+		names = append(names, group.Name)
+	}
+	return names
+}
+
+// loadPlaybookSource 获取要执行的Playbook内容，设置了入口时读取项目文件；入口文件不存在时返回sql.ErrNoRows
+func loadPlaybookSource(playbook models.AnsiblePlaybook) ([]byte, error) {
+	if playbook.EntryPoint == "" {
+		return []byte(playbook.Content), nil
+	}
+	file, err := LoadPlaybookFile(playbook.ID, playbook.EntryPoint)
+	if err != nil {
+		return nil, err
+	}
+	return file.Content, nil
+}
+
+// validatePlaybookVariables 校验Playbook变量为YAML映射，变量以-e @file方式传入，必须是键值对
+// 整个变量文件使用Vault加密时无法解析，由ansible-playbook解密后校验
+func validatePlaybookVariables(variables string) error {
+	trimmed := strings.TrimSpace(variables)
+	if trimmed == "" || strings.HasPrefix(trimmed, ansibleVaultHeader) {
+		return nil
+	}
+	var vars interface{}
+	if err := yaml.Unmarshal([]byte(variables), &vars); err != nil {
+		return &InvalidRequestError{Message: fmt.Sprintf("invalid variables YAML: %v", err)}
+	}
+	if vars == nil {
+		return nil
+	}
+	if _, ok := vars.(map[string]interface{}); !ok {
+		return &InvalidRequestError{Message: "variables must be a YAML mapping"}
+	}
+	return nil
+}
+
+// ValidateAnsiblePlaybook 保存Playbook时校验内容和变量的YAML结构、hosts模式，并执行ansible-playbook --syntax-check
+// 新建的Playbook入口文件尚不存在，入口在执行时校验；项目需要安装依赖或本机未安装ansible时跳过语法检查
+func ValidateAnsiblePlaybook(playbook models.AnsiblePlaybook) error {
+	if err := validatePlaybookVariables(playbook.Variables); err != nil {
+		return err
+	}
+	content, err := loadPlaybookSource(playbook)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, plays, err := preparePlaybookContent(content)
+	if err != nil {
+		return err
+	}
+
+	inventory, err := BuildAnsibleInventory(PlaybookHostGroupIDs(playbook), false)
+	if err != nil {
+		return fmt.Errorf("failed to build inventory: %v", err)
+	}
+	if err := checkPlaybookHostPatterns(plays, inventory); err != nil {
+		return err
+	}
+
+	if _, err := exec.LookPath("ansible-playbook"); err != nil {
+		return nil
+	}
+	workspace, err := prepareAnsibleWorkspace(inventory, playbook)
+	if err != nil {
+		return err
+	}
+	defer workspace.cleanup()
+	if workspace.hasRequirements() {
+		return nil
+	}
+	return workspace.syntaxCheck(nil)
+}
+
+// ansibleWorkspace 执行Playbook的临时目录，包含inventory、项目文件、改写后的Playbook、变量和Vault密码文件
+type ansibleWorkspace struct {
+	dir        string
+	projectDir string
+	args       []string // inventory、Playbook、变量和Vault密码参数
+	env        []string
+	secrets    []string // 需要在输出中脱敏的secret
+	vaultPath  string
+}
+
+// prepareAnsibleWorkspace 创建临时目录并写入执行所需的文件，调用方负责cleanup
+func prepareAnsibleWorkspace(inventory *AnsibleInventory, playbook models.AnsiblePlaybook) (*ansibleWorkspace, error) {
+	tempDir, err := os.MkdirTemp("", "ansible_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	w := &ansibleWorkspace{dir: tempDir, projectDir: filepath.Join(tempDir, "project")}
+	if err := w.populate(inventory, playbook); err != nil {
+		w.cleanup()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *ansibleWorkspace) populate(inventory *AnsibleInventory, playbook models.AnsiblePlaybook) error {
+	// 创建inventory文件，每个主机组一个组，另有包含所有目标主机的targets组；可能包含登录密码，仅当前用户可读
+	inventoryPath := filepath.Join(w.dir, "inventory.yml")
+	inventoryContent, err := json.MarshalIndent(inventory.executionInventory(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode inventory: %v", err)
+	}
+	if err := os.WriteFile(inventoryPath, inventoryContent, 0600); err != nil {
+		return fmt.Errorf("failed to create inventory file: %v", err)
+	}
+
+	// 项目文件（roles、templates、group_vars等）写入单独目录，Playbook与其放在一起以便按相对路径查找
+	if err := os.MkdirAll(w.projectDir, 0755); err != nil {
+		return fmt.Errorf("failed to create project directory: %v", err)
+	}
+	if _, err := materializePlaybookProject(playbook.ID, w.projectDir); err != nil {
+		return err
+	}
+
+	// 入口文件或Playbook内容按YAML结构改写hosts后写入项目目录
+	playbookPath := filepath.Join(w.projectDir, "playbook.yml")
+	var content []byte
+	if playbook.EntryPoint != "" {
+		entryPoint, err := CleanPlaybookFilePath(playbook.EntryPoint)
+		if err != nil {
+			return err
+		}
+		playbookPath = filepath.Join(w.projectDir, filepath.FromSlash(entryPoint))
+		if content, err = os.ReadFile(playbookPath); err != nil {
+			return fmt.Errorf("entry point %s not found in project files", entryPoint)
+		}
+	} else {
+		content = []byte(playbook.Content)
+	}
+	processed, _, err := preparePlaybookContent(content)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(playbookPath, processed, 0644); err != nil {
+		return fmt.Errorf("failed to create playbook file: %v", err)
+	}
+	w.args = []string{"-i", inventoryPath, playbookPath}
+
+	// 如果有变量，创建变量文件
+	if playbook.Variables != "" {
+		varsPath := filepath.Join(w.dir, "vars.yml")
+		if err := os.WriteFile(varsPath, []byte(playbook.Variables), 0644); err != nil {
+			return fmt.Errorf("failed to create vars file: %v", err)
+		}
+		w.args = append(w.args, "-e", "@"+varsPath)
+	}
+
+	// Vault密码写入临时文件，cleanup时覆盖删除
+	if playbook.VaultCredentialID != 0 {
+		credential, err := LoadCredential(playbook.VaultCredentialID)
+		if err != nil {
+			return fmt.Errorf("failed to load vault credential %d: %v", playbook.VaultCredentialID, err)
+		}
+		w.vaultPath = filepath.Join(w.dir, "vault_password")
+		if err := writeSecretFile(w.vaultPath, credential.Secret); err != nil {
+			return fmt.Errorf("failed to create vault password file: %v", err)
+		}
+		w.args = append(w.args, "--vault-password-file="+w.vaultPath)
+		w.secrets = []string{credential.Secret, strings.TrimSpace(credential.Secret)}
+	}
+
+	w.env = append(os.Environ(),
+		"ANSIBLE_HOST_KEY_CHECKING=False",
+		"ANSIBLE_TIMEOUT=30",
+		"ANSIBLE_SSH_RETRIES=3",
+		"ANSIBLE_NOCOLOR=1",
+		"ANSIBLE_FORCE_COLOR=0",
+		"PYTHONUNBUFFERED=1",
+	)
+	w.env = append(w.env, ansibleProjectEnv(w.projectDir)...)
+	// 逐主机结果由 AnsibleOutputParser 从 default 回调的文本输出中解析，
+	// 环境变量优先于 ansible.cfg，防止项目或控制机配置切换回调/结果格式
+	w.env = append(w.env,
+		"ANSIBLE_STDOUT_CALLBACK=default",
+		"ANSIBLE_LOAD_CALLBACK_PLUGINS=1",
+		"ANSIBLE_CALLBACK_RESULT_FORMAT=json",
+	)
+	return nil
+}
+
+// hasRequirements 项目是否包含需要ansible-galaxy安装的依赖
+func (w *ansibleWorkspace) hasRequirements() bool {
+	for _, req := range ansibleRequirementFiles {
+		if _, err := os.Stat(filepath.Join(w.projectDir, filepath.FromSlash(req.path))); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// syntaxCheck 执行ansible-playbook --syntax-check，失败时输出按行回调（onLine可为nil），返回包含输出末尾的错误
+func (w *ansibleWorkspace) syntaxCheck(onLine func(line string, isStderr bool)) error {
+	cmd := exec.Command("ansible-playbook", append(append([]string{}, w.args...), "--syntax-check")...)
+	cmd.Dir = w.projectDir
+	cmd.Env = w.env
+
+	var mu sync.Mutex
+	var lines []string
+	err := runStreaming(cmd, func(line string, isStderr bool) {
+		// 临时目录对用户没有意义，文件路径显示为项目内的相对路径
+		line = strings.ReplaceAll(redactSecrets(line, w.secrets), w.projectDir+string(filepath.Separator), "")
+		if strings.TrimSpace(line) != "" {
+			mu.Lock()
+			lines = append(lines, line)
+			mu.Unlock()
+		}
+	})
+	if err == nil {
+		return nil
+	}
+	if onLine != nil {
+		for _, line := range lines {
+			onLine(line, true)
+		}
+	}
+	if len(lines) > syntaxCheckMaxOutputLines {
+		lines = lines[len(lines)-syntaxCheckMaxOutputLines:]
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return fmt.Errorf("failed to run syntax check: %v", err)
+	}
+	return &InvalidRequestError{Message: "playbook syntax check failed: " + strings.Join(lines, "\n")}
+}
+
+// cleanup 覆盖删除Vault密码文件并删除临时目录
+func (w *ansibleWorkspace) cleanup() {
+	if w.vaultPath != "" {
+		shredFile(w.vaultPath)
+	}
+	os.RemoveAll(w.dir)
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"runme-backend/models"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// becomePrompt 自定义的sudo密码提示符，用于在PTY输出中识别何时需要输入密码
+const becomePrompt = "[runme-become-password]:"
+
+// 合法的执行身份（Linux用户名）
+var runAsPattern = regexp.MustCompile(`^[a-z_][a-z0-9_.-]*\$?$`)
+
+// ValidateRunAs 校验执行身份，为空表示使用登录用户
+func ValidateRunAs(runAs string) error {
+	if runAs == "" || runAsPattern.MatchString(runAs) {
+		return nil
+	}
+	return fmt.Errorf("invalid run_as user %q", runAs)
+}
+
+// BuildBecomeCommand 生成以指定用户身份执行脚本的sudo命令
+func BuildBecomeCommand(runAs, script string) string {
+	return fmt.Sprintf("sudo -H -u %s -p %s -- /bin/bash -c %s",
+		ShellQuote(runAs), ShellQuote(becomePrompt), ShellQuote(script))
+}
+
+// needsBecome 执行身份为空或与登录用户相同时不需要提权
+func needsBecome(host models.Host, runAs string) bool {
+	return runAs != "" && runAs != host.Username
+}
+
+// ExecuteAsUser 以runAs身份在主机上执行命令
+func ExecuteAsUser(host models.Host, runAs, script string) (string, error) {
+	if !needsBecome(host, runAs) {
+		return ExecuteSSHCommand(host.IP, host.Username, host.Password, host.Port, script)
+	}
+	output, _, err := executeWithBecome(host, runAs, script)
+	return output, err
+}
+
+// ExecuteScriptOnHostAs 以runAs身份在单个主机上执行脚本
+// 提权执行通过PTY完成，终端会合并stderr，此时全部输出记录在Stdout中
+func ExecuteScriptOnHostAs(host models.Host, runAs, script string) (result ExecutionResult) {
+	if !needsBecome(host, runAs) {
+		return ExecuteScriptOnHost(host.IP, host.Username, host.Password, host.Port, script)
+	}
+
+	result = ExecutionResult{
+		Host:      host.IP,
+		Status:    "success",
+		StartedAt: time.Now(),
+	}
+	defer result.finish()
+
+	output, exitCode, err := executeWithBecome(host, runAs, script)
+	result.Output = output
+	result.Stdout = output
+	result.ExitCode = exitCode
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// executeWithBecome 通过PTY执行sudo命令，出现密码提示时写入主机的提权密码，返回输出和退出码
+func executeWithBecome(host models.Host, runAs, script string) (string, int, error) {
+	// SSH配置
+	config := &ssh.ClientConfig{
+		User: host.Username,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(host.Password),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         30 * time.Second,
+	}
+
+	// 连接SSH
+	addr := net.JoinHostPort(host.IP, fmt.Sprintf("%d", host.Port))
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return "", -1, fmt.Errorf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// 创建会话
+	session, err := conn.NewSession()
+	if err != nil {
+		return "", -1, fmt.Errorf("failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	// sudo在requiretty等配置下需要终端，关闭回显避免密码出现在输出中
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty("xterm", 40, 200, modes); err != nil {
+		return "", -1, fmt.Errorf("failed to request pty: %v", err)
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return "", -1, fmt.Errorf("failed to open stdin: %v", err)
+	}
+
+	password := host.BecomePassword
+	if password == "" {
+		password = host.Password
+	}
+	watcher := &becomeWatcher{stdin: stdin, password: password}
+	session.Stdout = watcher
+	session.Stderr = watcher
+
+	err = session.Run(BuildBecomeCommand(runAs, script))
+	output := watcher.Output()
+	if watcher.Rejected() {
+		// 提权失败时脚本并未执行，不使用sudo的退出码
+		return output, -1, fmt.Errorf("become password for %s was rejected by sudo", host.Username)
+	}
+	if err != nil {
+		return output, ExitCodeOf(err), fmt.Errorf("script execution failed: %v", err)
+	}
+	return output, 0, nil
+}
+
+// becomeWatcher 收集PTY输出，识别sudo密码提示并自动应答
+type becomeWatcher struct {
+	mu       sync.Mutex
+	raw      bytes.Buffer
+	scanned  int
+	prompts  int
+	rejected bool
+	stdin    io.WriteCloser
+	password string
+}
+
+func (w *becomeWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.raw.Write(p)
+	data := w.raw.Bytes()
+	for !w.rejected {
+		idx := bytes.Index(data[w.scanned:], []byte(becomePrompt))
+		if idx < 0 {
+			break
+		}
+		w.scanned += idx + len(becomePrompt)
+		w.prompts++
+
+		if w.prompts > 1 {
+			// 再次出现提示说明密码错误，中断sudo避免无限等待
+			w.rejected = true
+			io.WriteString(w.stdin, "\x03")
+			w.stdin.Close()
+			break
+		}
+		io.WriteString(w.stdin, w.password+"\n")
+	}
+
+	// 保留可能被截断的提示符前缀，下次写入时继续匹配
+	if keep := len(data) - len(becomePrompt) + 1; keep > w.scanned {
+		w.scanned = keep
+	}
+	return len(p), nil
+}
+
+// Output 返回去除密码提示后的输出
+func (w *becomeWatcher) Output() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	output := w.raw.String()
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	output = strings.ReplaceAll(output, becomePrompt+"\n", "")
+	return strings.ReplaceAll(output, becomePrompt, "")
+}
+
+// Rejected sudo是否拒绝了提权密码
+func (w *becomeWatcher) Rejected() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rejected
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"runme-backend/database"
+	"runme-backend/models"
+	"strings"
+)
+
+// 支持的凭据类型
+const (
+	CredentialTypeVaultPassword = "vault_password"
+	CredentialTypeGitToken      = "git_token" // HTTPS仓库的访问令牌
+	CredentialTypeSSHKey        = "ssh_key"   // SSH仓库的部署密钥（私钥）
+)
+
+var credentialTypes = map[string]bool{
+	CredentialTypeVaultPassword: true,
+	CredentialTypeGitToken:      true,
+	CredentialTypeSSHKey:        true,
+}
+
+// ValidateCredential 校验凭据名称和类型，requireSecret为false时允许secret为空（更新时保留原值）
+func ValidateCredential(credential models.Credential, requireSecret bool) error {
+	if strings.TrimSpace(credential.Name) == "" {
+		return &InvalidRequestError{Message: "name is required"}
+	}
+	if !credentialTypes[credential.Type] {
+		return &InvalidRequestError{Message: fmt.Sprintf("unsupported credential type %q", credential.Type)}
+	}
+	if requireSecret && credential.Secret == "" {
+		return &InvalidRequestError{Message: "secret is required"}
+	}
+	if credential.Type == CredentialTypeSSHKey && credential.Secret != "" && !strings.Contains(credential.Secret, "PRIVATE KEY") {
+		return &InvalidRequestError{Message: "ssh_key secret must be a PEM or OpenSSH private key"}
+	}
+	if strings.ContainsAny(credential.Username, " \t\r\n") {
+		return &InvalidRequestError{Message: "username must not contain whitespace"}
+	}
+	return nil
+}
+
+// LoadCredential 获取凭据（包含secret，仅供执行使用）
+func LoadCredential(id int) (*models.Credential, error) {
+	var credential models.Credential
+	err := database.DB.QueryRow(`
+		SELECT id, name, type, username, description, secret, created_at, updated_at
+		FROM credentials WHERE id = ?
+	`, id).Scan(&credential.ID, &credential.Name, &credential.Type, &credential.Username, &credential.Description, &credential.Secret,
+		&credential.CreatedAt, &credential.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// ValidateCredentialReference 校验引用的凭据存在且类型匹配，id为0表示不引用
+func ValidateCredentialReference(id int, credentialType string) error {
+	if id == 0 {
+		return nil
+	}
+	credential, err := LoadCredential(id)
+	if err == sql.ErrNoRows {
+		return &InvalidRequestError{Message: fmt.Sprintf("credential %d not found", id)}
+	}
+	if err != nil {
+		return err
+	}
+	if credential.Type != credentialType {
+		return &InvalidRequestError{Message: fmt.Sprintf("credential %d is not a %s", id, credentialType)}
+	}
+	return nil
+}
+
+// CredentialUsage 返回引用该凭据的资源说明，为空表示未被使用
+func CredentialUsage(id int) ([]string, error) {
+	references := []struct {
+		query string
+		kind  string
+	}{
+		{"SELECT name FROM ansible_playbooks WHERE vault_credential_id = ?", "playbook"},
+		{"SELECT name FROM deployment_tasks WHERE git_credential_id = ?", "deployment"},
+	}
+
+	var usage []string
+	for _, ref := range references {
+		rows, err := database.DB.Query(ref.query, id)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			usage = append(usage, ref.kind+" "+name)
+		}
+		rows.Close()
+	}
+	return usage, nil
+}
+
+// writeSecretFile 将secret写入仅当前用户可读的文件
+func writeSecretFile(path, secret string) error {
+	return os.WriteFile(path, []byte(secret), 0600)
+}
+
+// shredFile 用零覆盖文件内容并同步到磁盘后删除
+func shredFile(path string) {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		if info, err := file.Stat(); err == nil {
+			file.Write(make([]byte, info.Size()))
+			file.Sync()
+		}
+		file.Close()
+	}
+	os.Remove(path)
+}
+
+// redactMinLength 参与输出脱敏的secret最短长度，过短的secret会误伤正常输出（如ok、changed）
+const redactMinLength = 6
+
+// redactSecrets 将输出中出现的secret替换为掩码
+func redactSecrets(line string, secrets []string) string {
+	for _, secret := range secrets {
+		if len(secret) >= redactMinLength {
+			line = strings.ReplaceAll(line, secret, SecretMask)
+		}
+	}
+	return line
+}
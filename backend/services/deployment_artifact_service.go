@@ -0,0 +1,487 @@
+package services
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runme-backend/database"
+	"runme-backend/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// 部署的构建方式
+const (
+	DeploymentBuildRemote = "remote"
+	DeploymentBuildLocal  = "local"
+)
+
+// artifactBuildHost 本机构建制品的日志使用的主机名
+const artifactBuildHost = "controller"
+
+// buildImagePattern 执行构建的Docker镜像名
+var buildImagePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/:@-]*$`)
+
+// validateDeploymentBuild 校验构建方式，local方式必须在容器中构建，制品目录必须位于仓库内
+func validateDeploymentBuild(task *models.DeploymentTask) error {
+	task.BuildMode = strings.TrimSpace(task.BuildMode)
+	task.BuildImage = strings.TrimSpace(task.BuildImage)
+	task.ArtifactPath = strings.TrimSpace(task.ArtifactPath)
+	if task.BuildMode == "" {
+		task.BuildMode = DeploymentBuildRemote
+	}
+
+	switch task.BuildMode {
+	case DeploymentBuildRemote:
+		if task.BuildImage != "" || task.ArtifactPath != "" || strings.TrimSpace(task.InstallCommand) != "" {
+			return &InvalidRequestError{Message: "build_image, artifact_path and install_command require build_mode local"}
+		}
+		return nil
+	case DeploymentBuildLocal:
+	default:
+		return &InvalidRequestError{Message: fmt.Sprintf("unsupported build_mode %q", task.BuildMode)}
+	}
+
+	if task.Strategy == DeploymentStrategyDocker {
+		return &InvalidRequestError{Message: "docker strategy builds images on each host and requires build_mode remote"}
+	}
+	// 构建命令来自仓库，不能直接在RunMe所在机器上执行，否则可读取数据库中的主机密码和凭据
+	if task.BuildImage == "" {
+		return &InvalidRequestError{Message: "build_mode local requires a build_image to run the build in"}
+	}
+	if !buildImagePattern.MatchString(task.BuildImage) {
+		return &InvalidRequestError{Message: fmt.Sprintf("invalid build_image %q", task.BuildImage)}
+	}
+	if task.ArtifactPath != "" {
+		cleaned := path.Clean(task.ArtifactPath)
+		if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return &InvalidRequestError{Message: "artifact_path must be a path inside the repository"}
+		}
+		if cleaned == "." {
+			cleaned = ""
+		}
+		task.ArtifactPath = cleaned
+	}
+	return nil
+}
+
+// deploymentArtifactDir 任务制品在数据目录中的存放目录
+func deploymentArtifactDir(taskID int) string {
+	return filepath.Join(database.DataDir, "artifacts", strconv.Itoa(taskID))
+}
+
+// LoadDeploymentArtifact 获取任务某个版本的制品，该版本不是本机构建时返回nil
+func LoadDeploymentArtifact(taskID int, release string) (*models.DeploymentArtifact, error) {
+	var artifact models.DeploymentArtifact
+	err := database.DB.QueryRow(`
+		SELECT id, task_id, session_name, release, commit_sha, commit_message, path, size, checksum, created_at
+		FROM deployment_artifacts WHERE task_id = ? AND release = ?
+	`, taskID, release).Scan(&artifact.ID, &artifact.TaskID, &artifact.SessionName, &artifact.Release,
+		&artifact.CommitSHA, &artifact.CommitMessage, &artifact.Path, &artifact.Size, &artifact.Checksum, &artifact.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+// ListDeploymentArtifacts 获取任务保留的制品，最新的在前
+func ListDeploymentArtifacts(taskID int) ([]models.DeploymentArtifact, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, task_id, session_name, release, commit_sha, commit_message, path, size, checksum, created_at
+		FROM deployment_artifacts WHERE task_id = ?
+		ORDER BY id DESC
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artifacts := []models.DeploymentArtifact{}
+	for rows.Next() {
+		var artifact models.DeploymentArtifact
+		if err := rows.Scan(&artifact.ID, &artifact.TaskID, &artifact.SessionName, &artifact.Release, &artifact.CommitSHA,
+			&artifact.CommitMessage, &artifact.Path, &artifact.Size, &artifact.Checksum, &artifact.CreatedAt); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, rows.Err()
+}
+
+// DeleteDeploymentArtifacts 删除任务的所有制品记录和文件
+func DeleteDeploymentArtifacts(taskID int) error {
+	if _, err := database.DB.Exec("DELETE FROM deployment_artifacts WHERE task_id = ?", taskID); err != nil {
+		return err
+	}
+	return os.RemoveAll(deploymentArtifactDir(taskID))
+}
+
+// pruneDeploymentArtifacts 删除超出保留版本数的旧制品，主机上的版本目录不受影响，仍可回滚
+func pruneDeploymentArtifacts(task *models.DeploymentTask) {
+	keep := task.KeepReleases
+	if keep <= 0 {
+		keep = DefaultKeepReleases
+	}
+	rows, err := database.DB.Query(`
+		SELECT id, path FROM deployment_artifacts WHERE task_id = ?
+		ORDER BY id DESC LIMIT -1 OFFSET ?
+	`, task.ID, keep)
+	if err != nil {
+		log.Printf("Failed to list old artifacts for task %s: %v", task.Name, err)
+		return
+	}
+	type oldArtifact struct {
+		ID   int
+		Path string
+	}
+	var old []oldArtifact
+	for rows.Next() {
+		var artifact oldArtifact
+		if err := rows.Scan(&artifact.ID, &artifact.Path); err == nil {
+			old = append(old, artifact)
+		}
+	}
+	rows.Close()
+
+	for _, artifact := range old {
+		if err := os.Remove(artifact.Path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove artifact %s: %v", artifact.Path, err)
+			continue
+		}
+		database.DB.Exec("DELETE FROM deployment_artifacts WHERE id = ?", artifact.ID)
+	}
+}
+
+// runLocalCommand 在RunMe所在机器上执行RunMe生成的命令（检出代码、启动构建容器），返回合并的输出
+func runLocalCommand(dir string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+// buildDeploymentArtifact 在本机检出并构建一次，将制品目录打包保存到数据目录，构建过程记录为controller的部署日志
+func buildDeploymentArtifact(task *models.DeploymentTask, sessionName, release, ref string) (*models.DeploymentArtifact, error) {
+	var artifact *models.DeploymentArtifact
+	var buildErr error
+	deployHost(task, sessionName, models.Host{IP: artifactBuildHost}, func(models.Host) (hostDeployment, error) {
+		var deployment hostDeployment
+		artifact, deployment, buildErr = buildArtifact(task, sessionName, release, ref)
+		return deployment, buildErr
+	})
+	if buildErr == nil {
+		pruneDeploymentArtifacts(task)
+	}
+	return artifact, buildErr
+}
+
+// buildArtifact 检出代码、在build_image容器中执行部署计划的构建命令，然后打包并计算校验和
+// 容器只挂载代码目录，只传入部署计划的环境变量
+func buildArtifact(task *models.DeploymentTask, sessionName, release, ref string) (*models.DeploymentArtifact, hostDeployment, error) {
+	deployment := hostDeployment{Release: release}
+	if task.BuildImage == "" {
+		return nil, deployment, fmt.Errorf("artifact build failed: build_mode local requires a build_image")
+	}
+	workDir, err := os.MkdirTemp("", "runme-build-")
+	if err != nil {
+		return nil, deployment, fmt.Errorf("artifact build failed: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+	srcDir := filepath.Join(workDir, "src")
+
+	auth, err := prepareLocalGitAuth(task)
+	if err != nil {
+		return nil, deployment, fmt.Errorf("artifact build failed: %v", err)
+	}
+	defer auth.cleanupLocal()
+
+	checkout := fmt.Sprintf("#!/bin/bash\nset -e\n\n%s%s\ncd %s\n\n%s", gitEnvScript(auth),
+		gitCheckoutScript(task, ShellQuote(srcDir), release, ref), ShellQuote(srcDir), deploymentProbeScript(""))
+	output, err := runLocalCommand(workDir, "bash", "-c", checkout)
+	deployment.Output = auth.redact(output)
+	if err != nil {
+		return nil, deployment, fmt.Errorf("artifact build failed: %v", err)
+	}
+	probe, output, err := parseDeploymentProbe(deployment.Output)
+	deployment.Output = output
+	if err != nil {
+		return nil, deployment, fmt.Errorf("artifact build failed: %v", err)
+	}
+	deployment.CommitSHA, deployment.CommitMessage = probe.CommitSHA, probe.CommitMessage
+
+	plan := resolveDeploymentPlan(task, release, probe)
+	if plan.Strategy == DeploymentStrategyDocker {
+		return nil, deployment, fmt.Errorf("artifact build failed: docker projects must use build_mode remote")
+	}
+	if plan.Build != "" {
+		deployment.Output += fmt.Sprintf("Building artifact in %s...\n", task.BuildImage)
+		args := []string{"run", "--rm", "-v", srcDir + ":/workspace", "-w", "/workspace"}
+		env := plan.runtimeEnv()
+		for _, key := range sortedKeys(env) {
+			args = append(args, "-e", key+"="+env[key])
+		}
+		args = append(args, task.BuildImage, "sh", "-c", plan.Build)
+		output, err = runLocalCommand(srcDir, "docker", args...)
+		deployment.Output += output
+		if err != nil {
+			return nil, deployment, fmt.Errorf("artifact build failed: %v", err)
+		}
+	}
+
+	artifact := &models.DeploymentArtifact{
+		TaskID:        task.ID,
+		SessionName:   sessionName,
+		Release:       release,
+		CommitSHA:     probe.CommitSHA,
+		CommitMessage: probe.CommitMessage,
+		Path:          filepath.Join(deploymentArtifactDir(task.ID), release+".tar.gz"),
+		CreatedAt:     time.Now(),
+	}
+	if artifact.Size, artifact.Checksum, err = packageArtifact(filepath.Join(srcDir, task.ArtifactPath), artifact.Path); err != nil {
+		return nil, deployment, fmt.Errorf("artifact build failed: %v", err)
+	}
+	deployment.Output += fmt.Sprintf("Packaged artifact %s.tar.gz (%d bytes, sha256 %s)\n", release, artifact.Size, artifact.Checksum)
+
+	result, err := database.DB.Exec(`
+		INSERT INTO deployment_artifacts (task_id, session_name, release, commit_sha, commit_message, path, size, checksum, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, artifact.TaskID, artifact.SessionName, artifact.Release, artifact.CommitSHA, artifact.CommitMessage,
+		artifact.Path, artifact.Size, artifact.Checksum, artifact.CreatedAt)
+	if err != nil {
+		os.Remove(artifact.Path)
+		return nil, deployment, fmt.Errorf("artifact build failed: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	artifact.ID = int(id)
+	return artifact, deployment, nil
+}
+
+// packageArtifact 将目录打包为tar.gz，返回文件大小和SHA-256
+func packageArtifact(root, target string) (int64, string, error) {
+	info, err := os.Stat(root)
+	if err != nil || !info.IsDir() {
+		return 0, "", fmt.Errorf("artifact directory %s not found after build", filepath.Base(root))
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, "", err
+	}
+
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, "", err
+	}
+	hash := sha256.New()
+	if err := writeArtifactArchive(io.MultiWriter(file, hash), root); err != nil {
+		file.Close()
+		os.Remove(target)
+		return 0, "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(target)
+		return 0, "", err
+	}
+	if info, err = os.Stat(target); err != nil {
+		return 0, "", err
+	}
+	return info.Size(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeArtifactArchive 将目录中的文件、目录和符号链接写入tar.gz，跳过仓库的.git目录，属主统一为root
+func writeArtifactArchive(w io.Writer, root string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		if rel == ".git" {
+			return filepath.SkipDir
+		}
+		mode := info.Mode()
+		if !mode.IsRegular() && !mode.IsDir() && mode&os.ModeSymlink == 0 {
+			return nil
+		}
+
+		link := ""
+		if mode&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if mode.IsDir() {
+			header.Name += "/"
+		}
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		header.ModTime = info.ModTime().Truncate(time.Second)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !mode.IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// uploadArtifact 通过SFTP以登录用户上传制品到随机命名的临时目录，返回该目录和制品路径
+func uploadArtifact(host models.Host, artifact *models.DeploymentArtifact) (string, string, error) {
+	// SSH配置
+	config := &ssh.ClientConfig{
+		User: host.Username,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(host.Password),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         30 * time.Second,
+	}
+
+	// 连接SSH
+	addr := net.JoinHostPort(host.IP, fmt.Sprintf("%d", host.Port))
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to start sftp: %v", err)
+	}
+	defer client.Close()
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", "", err
+	}
+	// 目录名不可猜测，执行身份不是登录用户时仍可读取其中的制品
+	dir := "/tmp/runme-artifact." + hex.EncodeToString(suffix)
+	if err := client.Mkdir(dir); err != nil {
+		return "", "", fmt.Errorf("failed to create upload directory: %v", err)
+	}
+	if err := client.Chmod(dir, 0711); err != nil {
+		return dir, "", fmt.Errorf("failed to create upload directory: %v", err)
+	}
+
+	remotePath := path.Join(dir, path.Base(artifact.Path))
+	local, err := os.Open(artifact.Path)
+	if err != nil {
+		return dir, "", fmt.Errorf("failed to open artifact: %v", err)
+	}
+	defer local.Close()
+	remote, err := client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return dir, "", fmt.Errorf("failed to upload artifact: %v", err)
+	}
+	if _, err := io.Copy(remote, local); err != nil {
+		remote.Close()
+		return dir, "", fmt.Errorf("failed to upload artifact: %v", err)
+	}
+	if err := remote.Close(); err != nil {
+		return dir, "", fmt.Errorf("failed to upload artifact: %v", err)
+	}
+	if err := client.Chmod(remotePath, 0644); err != nil {
+		return dir, "", fmt.Errorf("failed to upload artifact: %v", err)
+	}
+	return dir, remotePath, nil
+}
+
+// generateArtifactInstallScript 校验制品并解压到新版本目录，结束时输出项目文件和部署清单供选择策略
+func generateArtifactInstallScript(task *models.DeploymentTask, artifact *models.DeploymentArtifact, remotePath string) string {
+	baseDir := deploymentBaseDir(task)
+	releaseDir := ShellQuote(releasePath(baseDir, artifact.Release))
+
+	return fmt.Sprintf(`#!/bin/bash
+set -e
+
+mkdir -p %[1]s %[2]s
+if [ -e %[3]s ]; then
+    echo "Release %[4]s already exists"
+    exit 1
+fi
+echo "Verifying artifact checksum..."
+echo %[5]s | sha256sum -c --quiet -
+echo %[6]s
+mkdir %[3]s
+if ! tar --no-same-owner -xzf %[7]s -C %[3]s; then
+    rm -rf %[3]s
+    exit 1
+fi
+cd %[3]s
+
+%[8]s`, ShellQuote(path.Join(baseDir, "releases")), ShellQuote(path.Join(baseDir, "shared")), releaseDir, artifact.Release,
+		ShellQuote(artifact.Checksum+"  "+remotePath), ShellQuote("Extracting artifact into release "+artifact.Release+"..."),
+		ShellQuote(remotePath), deploymentProbeScript(""))
+}
+
+// executeArtifactDeployment 上传本机构建的制品并解压到新版本目录，执行安装命令后切换current并启动应用
+func executeArtifactDeployment(host models.Host, task *models.DeploymentTask, artifact *models.DeploymentArtifact) (hostDeployment, error) {
+	deployment := hostDeployment{Release: artifact.Release, CommitSHA: artifact.CommitSHA, CommitMessage: artifact.CommitMessage}
+	dir, remotePath, err := uploadArtifact(host, artifact)
+	if dir != "" {
+		defer ExecuteSSHCommand(host.IP, host.Username, host.Password, host.Port, "rm -rf "+ShellQuote(dir))
+	}
+	if err != nil {
+		return deployment, fmt.Errorf("deployment failed: %v", err)
+	}
+	deployment.Output = fmt.Sprintf("Uploaded artifact %s (%d bytes)\n", path.Base(remotePath), artifact.Size)
+
+	output, err := ExecuteAsUser(host, task.RunAs, generateArtifactInstallScript(task, artifact, remotePath))
+	if err != nil {
+		deployment.Output += output
+		return deployment, fmt.Errorf("deployment failed: %v", err)
+	}
+	probe, output, err := parseDeploymentProbe(output)
+	deployment.Output += output
+	if err != nil {
+		return deployment, fmt.Errorf("deployment failed: %v", err)
+	}
+
+	// 制品已在本机构建，主机上只执行安装命令
+	plan := resolveDeploymentPlan(task, artifact.Release, probe)
+	plan.Build, plan.Artifact = task.InstallCommand, true
+	runOutput, err := activateRelease(host, task, plan)
+	deployment.Output += runOutput
+	return deployment, err
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"runme-backend/models"
+	"strings"
+)
+
+// gitAuthMarker 凭据准备脚本输出临时目录的行前缀
+const gitAuthMarker = "__RUNME_GIT_AUTH__"
+
+// gitTokenDefaultUsername 访问令牌的默认用户名，GitHub、GitLab和Gitea均接受
+const gitTokenDefaultUsername = "oauth2"
+
+var (
+	// gitSCPURLPattern scp风格的SSH地址，如git@gitlab.com:group/app.git
+	gitSCPURLPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^/].*$`)
+	// gitAuthDirPattern 目标主机上存放凭据的临时目录，清理前校验以免误删
+	gitAuthDirPattern = regexp.MustCompile(`^/tmp/runme-git\.[A-Za-z0-9]+$`)
+	// gitRefPattern 部署时可指定的标签、提交或分支名
+	gitRefPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+)
+
+// ValidateDeploymentRef 校验部署的ref，为空表示任务分支
+func ValidateDeploymentRef(ref string) error {
+	if ref != "" && (!gitRefPattern.MatchString(ref) || strings.Contains(ref, "..") || strings.HasSuffix(ref, ".lock") || len(ref) > 255) {
+		return &InvalidRequestError{Message: fmt.Sprintf("invalid ref %q", ref)}
+	}
+	return nil
+}
+
+// gitCheckoutScript 将仓库检出到releaseDir（已转义），失败时删除该目录；ref只以转义后的形式出现在脚本中
+// 指定ref时先尝试只拉取该ref（标签、分支或完整的提交SHA），服务端不支持时拉取全部分支和标签后再解析
+func gitCheckoutScript(task *models.DeploymentTask, releaseDir, release, ref string) string {
+	if ref == "" {
+		return fmt.Sprintf(`echo "Cloning repository into release %[1]s..."
+if ! git -c credential.helper= clone --depth 1 -b %[2]s -- %[3]s %[4]s; then
+    rm -rf %[4]s
+    exit 1
+fi`, release, ShellQuote(task.Branch), ShellQuote(task.GithubURL), releaseDir)
+	}
+
+	return fmt.Sprintf(`echo %[1]s
+checkout_ref() {
+    git init -q %[4]s && cd %[4]s && git remote add origin %[3]s || return 1
+    if git -c credential.helper= fetch -q --depth 1 origin %[2]s; then
+        git checkout -q --detach FETCH_HEAD
+    else
+        echo %[5]s
+        git -c credential.helper= fetch -q --tags origin '+refs/heads/*:refs/remotes/origin/*' || return 1
+        if ! commit=$(git rev-parse -q --verify %[6]s); then
+            echo %[7]s
+            return 1
+        fi
+        git checkout -q --detach "$commit"
+    fi
+}
+if ! (checkout_ref); then
+    rm -rf %[4]s
+    exit 1
+fi`, ShellQuote("Checking out "+ref+" into release "+release+"..."), ShellQuote(ref), ShellQuote(task.GithubURL), releaseDir,
+		ShellQuote("Fetching all branches and tags to resolve "+ref+"..."), ShellQuote(ref+"^{commit}"),
+		ShellQuote("Ref "+ref+" not found in repository"))
+}
+
+// gitRemoteKind 仓库地址的协议类型：https、ssh、git或local，无法识别时为空
+func gitRemoteKind(url string) string {
+	switch {
+	case strings.HasPrefix(url, "https://"), strings.HasPrefix(url, "http://"):
+		return "https"
+	case strings.HasPrefix(url, "ssh://"), gitSCPURLPattern.MatchString(url):
+		return "ssh"
+	case strings.HasPrefix(url, "git://"):
+		return "git"
+	case strings.HasPrefix(url, "file://"), strings.HasPrefix(url, "/"):
+		return "local"
+	}
+	return ""
+}
+
+// validateDeploymentRepository 校验仓库地址和克隆凭据，凭据类型需与地址协议匹配
+func validateDeploymentRepository(task *models.DeploymentTask) error {
+	task.GithubURL = strings.TrimSpace(task.GithubURL)
+	if task.GithubURL == "" {
+		return &InvalidRequestError{Message: "repository URL is required"}
+	}
+	kind := gitRemoteKind(task.GithubURL)
+	if kind == "" || strings.ContainsAny(task.GithubURL, " \t\r\n") {
+		return &InvalidRequestError{Message: "repository URL must be an https://, ssh://, git://, file:// URL, user@host:path or an absolute path"}
+	}
+	if task.GitCredentialID == 0 {
+		return nil
+	}
+
+	credential, err := LoadCredential(task.GitCredentialID)
+	if err != nil {
+		return &InvalidRequestError{Message: fmt.Sprintf("credential %d not found", task.GitCredentialID)}
+	}
+	switch credential.Type {
+	case CredentialTypeGitToken:
+		if kind != "https" {
+			return &InvalidRequestError{Message: "git_token credentials require an https:// repository URL"}
+		}
+	case CredentialTypeSSHKey:
+		if kind != "ssh" {
+			return &InvalidRequestError{Message: "ssh_key credentials require an ssh:// or user@host:path repository URL"}
+		}
+	default:
+		return &InvalidRequestError{Message: fmt.Sprintf("credential %d is not a git_token or ssh_key", task.GitCredentialID)}
+	}
+	return nil
+}
+
+// gitAuth 目标主机上为本次克隆准备的凭据
+type gitAuth struct {
+	Dir    string
+	Type   string
+	Secret string
+}
+
+// generateGitAuthScript 在目标主机上创建仅执行身份可读的临时目录并写入凭据
+// 令牌通过GIT_ASKPASS提供，不出现在仓库地址和.git/config中；部署密钥写入私钥文件
+func generateGitAuthScript(credential *models.Credential) string {
+	secret := credential.Secret
+	if credential.Type == CredentialTypeSSHKey && !strings.HasSuffix(secret, "\n") {
+		secret += "\n"
+	}
+	username := firstNonEmpty(credential.Username, gitTokenDefaultUsername)
+
+	return fmt.Sprintf(`#!/bin/bash
+set -e
+umask 077
+dir=$(mktemp -d /tmp/runme-git.XXXXXXXX)
+echo %[1]s | base64 -d > "$dir/secret"
+cat > "$dir/askpass" <<'RUNME_ASKPASS'
+#!/bin/sh
+case "$1" in
+    Username*) echo %[2]s ;;
+    *) cat "$(dirname "$0")/secret" ;;
+esac
+RUNME_ASKPASS
+chmod 700 "$dir/askpass"
+echo "%[3]s$dir"
+`, base64.StdEncoding.EncodeToString([]byte(secret)), ShellQuote(username), gitAuthMarker)
+}
+
+// prepareGitAuth 在目标主机上准备克隆凭据，任务未配置凭据时返回nil
+// 凭据在单独的短时脚本中写入，避免密钥长时间出现在克隆进程的命令行中
+func prepareGitAuth(host models.Host, task *models.DeploymentTask) (*gitAuth, error) {
+	if task.GitCredentialID == 0 {
+		return nil, nil
+	}
+	credential, err := LoadCredential(task.GitCredentialID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load git credential %d: %v", task.GitCredentialID, err)
+	}
+
+	output, err := ExecuteAsUser(host, task.RunAs, generateGitAuthScript(credential))
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision git credential: %v", err)
+	}
+	return parseGitAuthOutput(output, credential)
+}
+
+// prepareLocalGitAuth 在RunMe所在机器上准备克隆凭据，用于在本机构建制品
+func prepareLocalGitAuth(task *models.DeploymentTask) (*gitAuth, error) {
+	if task.GitCredentialID == 0 {
+		return nil, nil
+	}
+	credential, err := LoadCredential(task.GitCredentialID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load git credential %d: %v", task.GitCredentialID, err)
+	}
+
+	output, err := runLocalCommand("", "bash", "-c", generateGitAuthScript(credential))
+	if err != nil {
+		return nil, fmt.Errorf("failed to provision git credential: %v", err)
+	}
+	return parseGitAuthOutput(output, credential)
+}
+
+// parseGitAuthOutput 从凭据准备脚本的输出中取出临时目录
+func parseGitAuthOutput(output string, credential *models.Credential) (*gitAuth, error) {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if dir := strings.TrimPrefix(line, gitAuthMarker); dir != line && gitAuthDirPattern.MatchString(dir) {
+			return &gitAuth{Dir: dir, Type: credential.Type, Secret: credential.Secret}, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to provision git credential")
+}
+
+// cleanup 克隆脚本退出时已删除凭据目录，这里再次删除以防脚本中途被中断
+func (auth *gitAuth) cleanup(host models.Host, task *models.DeploymentTask) {
+	if auth == nil {
+		return
+	}
+	ExecuteAsUser(host, task.RunAs, "rm -rf "+ShellQuote(auth.Dir))
+}
+
+// cleanupLocal 删除本机的凭据目录
+func (auth *gitAuth) cleanupLocal() {
+	if auth == nil {
+		return
+	}
+	os.RemoveAll(auth.Dir)
+}
+
+// redact 去掉输出中的凭据
+func (auth *gitAuth) redact(output string) string {
+	if auth == nil {
+		return output
+	}
+	return redactSecrets(output, []string{auth.Secret})
+}
+
+// gitEnvScript 克隆前设置的环境变量，脚本退出时删除凭据目录
+func gitEnvScript(auth *gitAuth) string {
+	// 私有仓库缺少凭据时直接失败，不等待终端输入
+	script := "export GIT_TERMINAL_PROMPT=0\n"
+	if auth == nil {
+		return script
+	}
+
+	// 目录名已按gitAuthDirPattern校验，不含需要转义的字符
+	script += fmt.Sprintf("trap 'rm -rf %s' EXIT\n", auth.Dir)
+	switch auth.Type {
+	case CredentialTypeGitToken:
+		script += fmt.Sprintf("export GIT_ASKPASS=%s/askpass\n", auth.Dir)
+	case CredentialTypeSSHKey:
+		script += fmt.Sprintf("export GIT_SSH_COMMAND=\"ssh -i %s/secret -o IdentitiesOnly=yes -o BatchMode=yes -o StrictHostKeyChecking=accept-new\"\n", auth.Dir)
+	}
+	return script
+}
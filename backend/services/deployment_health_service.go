@@ -0,0 +1,172 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"runme-backend/models"
+	"strconv"
+	"strings"
+)
+
+// 部署后的健康检查类型
+const (
+	HealthCheckHTTP    = "http"
+	HealthCheckTCP     = "tcp"
+	HealthCheckCommand = "command"
+)
+
+// 健康检查等待时间
+const (
+	defaultHealthTimeout = 30
+	maxHealthTimeout     = 3600
+	healthCheckInterval  = 2
+)
+
+// healthHostnamePattern TCP健康检查允许的主机名，主机会拼接到bash -c执行的/dev/tcp路径中
+var healthHostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// validateDeploymentRollout 校验滚动部署和健康检查配置
+func validateDeploymentRollout(task *models.DeploymentTask) error {
+	if task.BatchSize < 0 {
+		return &InvalidRequestError{Message: "batch_size must not be negative"}
+	}
+
+	task.HealthCheck = strings.TrimSpace(task.HealthCheck)
+	task.HealthTarget = strings.TrimSpace(task.HealthTarget)
+	if task.HealthTimeout == 0 {
+		task.HealthTimeout = defaultHealthTimeout
+	}
+	if task.HealthTimeout < 1 || task.HealthTimeout > maxHealthTimeout {
+		return &InvalidRequestError{Message: fmt.Sprintf("health_timeout must be between 1 and %d", maxHealthTimeout)}
+	}
+
+	switch task.HealthCheck {
+	case "":
+		task.HealthTarget = ""
+	case HealthCheckHTTP:
+		target := task.HealthTarget
+		if target != "" && !strings.HasPrefix(target, "/") &&
+			!strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+			return &InvalidRequestError{Message: "http health_target must be a path or an http(s) URL"}
+		}
+	case HealthCheckTCP:
+		if task.HealthTarget != "" {
+			if _, _, err := parseHealthTCPTarget(task.HealthTarget); err != nil {
+				return &InvalidRequestError{Message: err.Error()}
+			}
+		}
+	case HealthCheckCommand:
+		if task.HealthTarget == "" {
+			return &InvalidRequestError{Message: "command health check requires a health_target command"}
+		}
+	default:
+		return &InvalidRequestError{Message: fmt.Sprintf("unsupported health_check %q", task.HealthCheck)}
+	}
+	return nil
+}
+
+// parseHealthTCPTarget 解析端口或host:port，未指定主机时检查本机
+func parseHealthTCPTarget(target string) (string, int, error) {
+	host, portStr := "127.0.0.1", target
+	if strings.Contains(target, ":") {
+		var err error
+		if host, portStr, err = net.SplitHostPort(target); err != nil {
+			return "", 0, fmt.Errorf("invalid tcp health_target %q", target)
+		}
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 || host == "" {
+		return "", 0, fmt.Errorf("invalid tcp health_target %q", target)
+	}
+	if net.ParseIP(host) == nil && !healthHostnamePattern.MatchString(host) {
+		return "", 0, fmt.Errorf("invalid tcp health_target host %q: must be an IP address or hostname", host)
+	}
+	return host, port, nil
+}
+
+// healthCheckPort 未指定端口时使用应用的PORT
+func healthCheckPort(plan *DeploymentPlan) (int, error) {
+	if port, err := strconv.Atoi(plan.runtimeEnv()["PORT"]); err == nil && port > 0 {
+		return port, nil
+	}
+	return 0, fmt.Errorf("health check requires a port: configure ports or set the health_target")
+}
+
+// healthCheckCommand 生成单次检查的命令和描述
+func healthCheckCommand(task *models.DeploymentTask, plan *DeploymentPlan) (string, string, error) {
+	switch task.HealthCheck {
+	case HealthCheckHTTP:
+		url := task.HealthTarget
+		if url == "" || strings.HasPrefix(url, "/") {
+			port, err := healthCheckPort(plan)
+			if err != nil {
+				return "", "", err
+			}
+			url = fmt.Sprintf("http://127.0.0.1:%d%s", port, firstNonEmpty(url, "/"))
+		}
+		return "curl -fsS -o /dev/null --max-time 5 " + ShellQuote(url), "http " + url, nil
+
+	case HealthCheckTCP:
+		host, port := "127.0.0.1", 0
+		var err error
+		if task.HealthTarget != "" {
+			host, port, err = parseHealthTCPTarget(task.HealthTarget)
+		} else {
+			port, err = healthCheckPort(plan)
+		}
+		if err != nil {
+			return "", "", err
+		}
+		probe := fmt.Sprintf("exec 3<>/dev/tcp/%s/%d", host, port)
+		return "timeout 5 bash -c " + ShellQuote(probe) + " 2>/dev/null", fmt.Sprintf("tcp %s:%d", host, port), nil
+
+	case HealthCheckCommand:
+		return "bash -c " + ShellQuote(task.HealthTarget), "command", nil
+	}
+	return "", "", fmt.Errorf("unsupported health check %q", task.HealthCheck)
+}
+
+// generateHealthCheckScript 在current目录中重复执行检查，直到通过或超时
+func generateHealthCheckScript(task *models.DeploymentTask, plan *DeploymentPlan) (string, error) {
+	check, description, err := healthCheckCommand(task, plan)
+	if err != nil {
+		return "", err
+	}
+
+	var script strings.Builder
+	script.WriteString("#!/bin/bash\n\n")
+	fmt.Fprintf(&script, "cd %s\n", ShellQuote(plan.CurrentDir()))
+	env := plan.runtimeEnv()
+	for _, key := range sortedKeys(env) {
+		fmt.Fprintf(&script, "export %s=%s\n", key, ShellQuote(env[key]))
+	}
+	fmt.Fprintf(&script, `echo %[1]s
+deadline=$((SECONDS + %[2]d))
+until %[3]s; do
+    if [ $SECONDS -ge $deadline ]; then
+        echo "Health check failed after %[2]d seconds"
+        exit 1
+    fi
+    sleep %[4]d
+done
+echo "Health check passed"
+`, ShellQuote("Running health check ("+description+")..."), task.HealthTimeout, check, healthCheckInterval)
+	return script.String(), nil
+}
+
+// runHealthCheck 部署或回滚后在主机上执行健康检查，未配置时直接通过
+func runHealthCheck(host models.Host, task *models.DeploymentTask, plan *DeploymentPlan) (string, error) {
+	if task.HealthCheck == "" {
+		return "", nil
+	}
+	script, err := generateHealthCheckScript(task, plan)
+	if err != nil {
+		return "", fmt.Errorf("health check failed: %v", err)
+	}
+	output, err := ExecuteAsUser(host, task.RunAs, script)
+	if err != nil {
+		return output, fmt.Errorf("health check failed: %v", err)
+	}
+	return output, nil
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"regexp"
+	"runme-backend/database"
+	"runme-backend/models"
+	"time"
+)
+
+// 保留的版本数量
+const (
+	DefaultKeepReleases = 5
+	maxKeepReleases     = 100
+)
+
+// releaseNamePattern 版本目录名，由部署时间生成
+var releaseNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// newReleaseName 生成新版本目录名，同一次部署的所有主机使用相同的版本名
+// 精确到微秒，避免同一秒内的两次部署使用相同的目录；定宽格式保证按名称排序即按时间排序
+func newReleaseName() string {
+	return time.Now().Format("20060102150405.000000")
+}
+
+// releasePath 版本目录的绝对路径
+func releasePath(baseDir, release string) string {
+	return path.Join(baseDir, "releases", release)
+}
+
+// ReleaseDir 本次部署或回滚的版本目录
+func (plan *DeploymentPlan) ReleaseDir() string {
+	return releasePath(plan.BaseDir, plan.Release)
+}
+
+// CurrentDir 指向当前版本的符号链接，应用从该目录启动
+func (plan *DeploymentPlan) CurrentDir() string {
+	return path.Join(plan.BaseDir, "current")
+}
+
+// SharedDir 各版本共享的目录，保存PID文件和应用日志
+func (plan *DeploymentPlan) SharedDir() string {
+	return path.Join(plan.BaseDir, "shared")
+}
+
+// ValidateKeepReleases 规范化保留版本数，0表示使用默认值
+func ValidateKeepReleases(task *models.DeploymentTask) error {
+	if task.KeepReleases == 0 {
+		task.KeepReleases = DefaultKeepReleases
+	}
+	if task.KeepReleases < 1 || task.KeepReleases > maxKeepReleases {
+		return &InvalidRequestError{Message: fmt.Sprintf("keep_releases must be between 1 and %d", maxKeepReleases)}
+	}
+	return nil
+}
+
+// ValidateReleaseName 校验回滚目标版本名，为空表示上一个版本
+func ValidateReleaseName(release string) error {
+	if release != "" && (!releaseNamePattern.MatchString(release) || release == "." || release == "..") {
+		return &InvalidRequestError{Message: fmt.Sprintf("invalid release %q", release)}
+	}
+	return nil
+}
+
+// releaseSwitchScript 原子地将current切换到本次的版本目录（先创建临时链接再重命名）
+func releaseSwitchScript(plan *DeploymentPlan) string {
+	current := ShellQuote(plan.CurrentDir())
+	tmpLink := ShellQuote(plan.CurrentDir() + ".tmp")
+	return fmt.Sprintf(`
+ln -sfn %[1]s %[2]s
+mv -Tf %[2]s %[3]s
+echo %[4]s
+`, ShellQuote(path.Join("releases", plan.Release)), tmpLink, current, ShellQuote("Switched current to release "+plan.Release))
+}
+
+// releaseCleanupScript 删除超出保留数量的旧版本，不会删除current指向的版本
+func releaseCleanupScript(plan *DeploymentPlan) string {
+	keep := plan.KeepReleases
+	if keep <= 0 {
+		keep = DefaultKeepReleases
+	}
+	return fmt.Sprintf(`
+current_release=$(basename "$(readlink %[1]s)")
+for old in $(ls -1 %[2]s | sort | head -n -%[3]d); do
+    if [ "$old" != "$current_release" ]; then
+        rm -rf %[2]s/"$old"
+        echo "Removed old release $old"
+    fi
+done
+`, ShellQuote(plan.CurrentDir()), ShellQuote(path.Join(plan.BaseDir, "releases")), keep)
+}
+
+// generateRollbackProbeScript 确定回滚目标版本并输出其项目文件和部署清单，release为空时选择current之前的版本
+func generateRollbackProbeScript(task *models.DeploymentTask, release string) string {
+	return fmt.Sprintf(`#!/bin/bash
+set -e
+
+cd %[1]s
+if [ ! -L current ]; then
+    echo "No current release to roll back from"
+    exit 1
+fi
+current=$(basename "$(readlink current)")
+target=%[2]s
+if [ -z "$target" ]; then
+    target=$(ls -1 releases | sort | grep -x -B1 -- "$current" | head -n 1)
+    if [ -z "$target" ] || [ "$target" = "$current" ]; then
+        echo "No release before $current"
+        exit 1
+    fi
+fi
+if [ ! -d "releases/$target" ]; then
+    echo "Release $target not found"
+    exit 1
+fi
+echo "Rolling back from $current to $target"
+cd "releases/$target"
+
+%[3]s`, ShellQuote(deploymentBaseDir(task)), ShellQuote(release), deploymentProbeScript(`"release:$target"`))
+}
+
+// executeRollbackScript 在主机上将current切换到目标版本并重启应用，返回输出和回滚到的版本及其提交
+func executeRollbackScript(host models.Host, task *models.DeploymentTask, release string) (hostDeployment, error) {
+	deployment := hostDeployment{Release: release}
+	output, err := ExecuteAsUser(host, task.RunAs, generateRollbackProbeScript(task, release))
+	deployment.Output = output
+	if err != nil {
+		return deployment, fmt.Errorf("rollback failed: %v", err)
+	}
+	probe, output, err := parseDeploymentProbe(output)
+	deployment.Output = output
+	if err != nil {
+		return deployment, fmt.Errorf("rollback failed: %v", err)
+	}
+	deployment.Release, deployment.CommitSHA, deployment.CommitMessage = probe.Release, probe.CommitSHA, probe.CommitMessage
+	// 制品中不包含.git，从制品记录中取得提交
+	if deployment.CommitSHA == "" {
+		if artifact, err := LoadDeploymentArtifact(task.ID, probe.Release); err == nil && artifact != nil {
+			deployment.CommitSHA, deployment.CommitMessage = artifact.CommitSHA, artifact.CommitMessage
+		}
+	}
+
+	plan := resolveDeploymentPlan(task, probe.Release, probe)
+	plan.Rollback = true
+	runOutput, err := ExecuteAsUser(host, task.RunAs, generateRunScript(plan))
+	deployment.Output += runOutput
+	if err != nil {
+		return deployment, fmt.Errorf("rollback failed: %v", err)
+	}
+
+	healthOutput, err := runHealthCheck(host, task, plan)
+	deployment.Output += healthOutput
+	return deployment, err
+}
+
+// DeployedVersions 每台主机当前部署的版本：最近一次切换了current的部署或回滚，从未部署的主机版本为空
+func DeployedVersions(task *models.DeploymentTask) ([]models.DeployedVersion, error) {
+	hosts, err := loadDeploymentHosts(task)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]models.DeployedVersion, 0, len(hosts))
+	for _, host := range hosts {
+		version := models.DeployedVersion{Host: host.IP}
+		var deployedAt time.Time
+		// 升级前的日志没有switched记录，以成功的部署代替
+		err := database.DB.QueryRow(`
+			SELECT release, commit_sha, commit_message, session_name, deployed_at FROM deployment_logs
+			WHERE task_id = ? AND host = ? AND (switched = 1 OR (status = 'success' AND release != ''))
+			ORDER BY id DESC LIMIT 1
+		`, task.ID, host.IP).Scan(&version.Release, &version.CommitSHA, &version.CommitMessage,
+			&version.SessionName, &deployedAt)
+		if err == nil {
+			version.DeployedAt = &deployedAt
+		} else if err != sql.ErrNoRows {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// VersionsInSync 所有主机是否部署了同一提交，未记录提交时比较版本目录名
+func VersionsInSync(versions []models.DeployedVersion) bool {
+	for _, version := range versions {
+		first := versions[0]
+		if version.CommitSHA != first.CommitSHA || (version.CommitSHA == "" && version.Release != first.Release) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"runme-backend/database"
+	"runme-backend/models"
+	"sync"
+	"time"
+)
+
+// deploymentBatches 按批次大小划分主机，batchSize为0时所有主机一批；canary为true时第一台主机单独一批
+func deploymentBatches(hosts []models.Host, batchSize int, canary bool) [][]models.Host {
+	var batches [][]models.Host
+	if canary && len(hosts) > 0 {
+		batches = append(batches, hosts[:1])
+		hosts = hosts[1:]
+	}
+	if batchSize <= 0 {
+		batchSize = len(hosts)
+	}
+	for len(hosts) > 0 {
+		size := batchSize
+		if size > len(hosts) {
+			size = len(hosts)
+		}
+		batches = append(batches, hosts[:size])
+		hosts = hosts[size:]
+	}
+	return batches
+}
+
+// rolloutDeployment 按批次使用deploy部署，同一批的主机并行部署并执行健康检查
+// 批次失败时停止部署其余主机，开启自动回滚时将本次已切换版本的主机（switched）回滚到上一个版本
+// canary为true时第一台主机部署成功后会话进入待批准状态，由ApproveDeployment继续部署其余主机
+func rolloutDeployment(task *models.DeploymentTask, sessionName string, hosts, switched []models.Host, canary bool,
+	deploy func(host models.Host) (hostDeployment, error)) error {
+	batches := deploymentBatches(hosts, task.BatchSize, canary)
+	for i, batch := range batches {
+		results := make([]hostDeployResult, len(batch))
+		var wg sync.WaitGroup
+		for j, host := range batch {
+			wg.Add(1)
+			go func(j int, host models.Host) {
+				defer wg.Done()
+				results[j] = deployHost(task, sessionName, host, deploy)
+			}(j, host)
+		}
+		wg.Wait()
+
+		failed := false
+		for _, result := range results {
+			if result.Switched {
+				switched = append(switched, result.Host)
+			}
+			if !result.Success {
+				failed = true
+			}
+		}
+
+		if failed {
+			log.Printf("Deployment %s halted: batch %d of %d failed", sessionName, i+1, len(batches))
+			for _, rest := range batches[i+1:] {
+				skipDeploymentHosts(task, sessionName, rest, fmt.Sprintf("Deployment halted: batch %d of %d failed", i+1, len(batches)))
+			}
+			if task.AutoRollback {
+				rollbackDeployedHosts(task, sessionName, switched)
+			}
+			return finishDeploymentSession(task, sessionName, "failed")
+		}
+
+		if canary && i == 0 && len(batches) > 1 {
+			log.Printf("Deployment %s: canary deployed to %s, awaiting approval", sessionName, batch[0].IP)
+			// 记录金丝雀检出的提交，批准后其余主机部署同一提交，而不是批准时分支的最新提交
+			if sha := results[0].CommitSHA; sha != "" {
+				if _, err := database.DB.Exec("UPDATE deployment_sessions SET commit_sha = ? WHERE task_id = ? AND session_name = ?",
+					sha, task.ID, sessionName); err != nil {
+					log.Printf("Failed to record canary commit for %s: %v", sessionName, err)
+				}
+			}
+			return finishDeploymentSession(task, sessionName, "awaiting_approval")
+		}
+	}
+	return finishDeploymentSession(task, sessionName, "success")
+}
+
+// skipDeploymentHosts 记录因批次失败而未部署的主机
+func skipDeploymentHosts(task *models.DeploymentTask, sessionName string, hosts []models.Host, reason string) {
+	for _, host := range hosts {
+		_, err := database.DB.Exec(`
+			INSERT INTO deployment_logs (task_id, session_name, host, status, output, error, deployed_at)
+			VALUES (?, ?, ?, 'skipped', '', ?, ?)
+		`, task.ID, sessionName, host.IP, reason, time.Now())
+		if err != nil {
+			log.Printf("Failed to insert deployment log: %v", err)
+		}
+	}
+}
+
+// rollbackDeployedHosts 将已切换到本次版本的主机回滚到上一个版本，日志记录在同一会话中
+func rollbackDeployedHosts(task *models.DeploymentTask, sessionName string, hosts []models.Host) {
+	for _, host := range hosts {
+		deployHost(task, sessionName, host, func(host models.Host) (hostDeployment, error) {
+			return executeRollbackScript(host, task, "")
+		})
+	}
+}
+
+// canarySession 待批准的金丝雀会话
+type canarySession struct {
+	Name        string
+	Release     string
+	Ref         string
+	CommitSHA   string        // 金丝雀主机检出的提交
+	CanaryHosts []models.Host // 已部署的金丝雀主机
+	Remaining   []models.Host // 等待批准后部署的主机
+}
+
+// resumeDeploymentSession 将任务待批准的金丝雀会话恢复为运行中，并按是否已部署划分主机
+func resumeDeploymentSession(task *models.DeploymentTask) (*canarySession, error) {
+	session := &canarySession{}
+	err := database.DB.QueryRow(`
+		SELECT session_name, release, ref, commit_sha FROM deployment_sessions
+		WHERE task_id = ? AND status = 'awaiting_approval'
+		ORDER BY created_at DESC LIMIT 1
+	`, task.ID).Scan(&session.Name, &session.Release, &session.Ref, &session.CommitSHA)
+	if err != nil {
+		return nil, &InvalidRequestError{Message: "Deployment is not awaiting approval"}
+	}
+
+	rows, err := database.DB.Query("SELECT host FROM deployment_logs WHERE task_id = ? AND session_name = ?",
+		task.ID, session.Name)
+	if err != nil {
+		return nil, err
+	}
+	deployed := make(map[string]bool)
+	for rows.Next() {
+		var host string
+		if err := rows.Scan(&host); err == nil {
+			deployed[host] = true
+		}
+	}
+	rows.Close()
+
+	hosts, err := loadDeploymentHosts(task)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range hosts {
+		if deployed[host.IP] {
+			session.CanaryHosts = append(session.CanaryHosts, host)
+		} else {
+			session.Remaining = append(session.Remaining, host)
+		}
+	}
+
+	_, err = database.DB.Exec("UPDATE deployment_sessions SET status = 'running' WHERE task_id = ? AND session_name = ?",
+		task.ID, session.Name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = database.DB.Exec("UPDATE deployment_tasks SET status = 'running', updated_at = ? WHERE id = ?",
+		time.Now(), task.ID)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// ApproveDeployment 批准金丝雀部署，按批次将同一版本部署到其余主机，其余主机检出金丝雀验证过的提交
+func ApproveDeployment(task *models.DeploymentTask) error {
+	session, err := resumeDeploymentSession(task)
+	if err != nil {
+		return err
+	}
+	artifact, err := LoadDeploymentArtifact(task.ID, session.Release)
+	if err != nil {
+		finishDeploymentSession(task, session.Name, "failed")
+		return err
+	}
+	return rolloutDeployment(task, session.Name, session.Remaining, session.CanaryHosts, false,
+		releaseDeployer(task, session.Release, firstNonEmpty(session.CommitSHA, session.Ref), artifact))
+}
+
+// RejectDeployment 拒绝金丝雀部署，将金丝雀主机回滚到上一个版本并中止会话
+func RejectDeployment(task *models.DeploymentTask) error {
+	session, err := resumeDeploymentSession(task)
+	if err != nil {
+		return err
+	}
+	skipDeploymentHosts(task, session.Name, session.Remaining, "Deployment rejected after canary")
+	rollbackDeployedHosts(task, session.Name, session.CanaryHosts)
+	return finishDeploymentSession(task, session.Name, "aborted")
+}
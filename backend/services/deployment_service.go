@@ -9,42 +9,181 @@ import (
 	"time"
 )
 
-// DeployProject 部署项目到主机组
-func DeployProject(task *models.DeploymentTask) error {
-	// 生成会话名称，与shell管理保持一致的格式
+// LoadDeploymentTask 根据ID获取部署任务
+func LoadDeploymentTask(id int) (*models.DeploymentTask, error) {
+	var task models.DeploymentTask
+	var env, ports string
+	err := database.DB.QueryRow(`
+		SELECT id, name, github_url, git_credential_id, branch, host_group_id, status, description, run_as,
+		       strategy, deploy_path, build_command, start_command, stop_command, env, ports,
+		       process_manager, service_user, restart_policy, keep_releases, batch_size, health_check, health_target,
+		       health_timeout, auto_rollback, canary, webhook_enabled, webhook_secret, build_mode, build_image,
+		       artifact_path, install_command
+		FROM deployment_tasks WHERE id = ?
+	`, id).Scan(&task.ID, &task.Name, &task.GithubURL, &task.GitCredentialID, &task.Branch, &task.HostGroupID, &task.Status, &task.Description, &task.RunAs,
+		&task.Strategy, &task.DeployPath, &task.BuildCommand, &task.StartCommand, &task.StopCommand, &env, &ports,
+		&task.ProcessManager, &task.ServiceUser, &task.RestartPolicy, &task.KeepReleases, &task.BatchSize, &task.HealthCheck, &task.HealthTarget,
+		&task.HealthTimeout, &task.AutoRollback, &task.Canary, &task.WebhookEnabled, &task.WebhookSecret, &task.BuildMode, &task.BuildImage,
+		&task.ArtifactPath, &task.InstallCommand)
+	if err != nil {
+		return nil, err
+	}
+	if err := ParseDeploymentSettings(&task, env, ports); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ClaimDeploymentTask 以条件更新将空闲的任务置为运行中，保证同一任务同时只有一次部署或回滚
+// 任务正在部署或等待金丝雀批准时返回InvalidRequestError
+func ClaimDeploymentTask(id int) error {
+	result, err := database.DB.Exec(`
+		UPDATE deployment_tasks SET status = 'running', updated_at = ?
+		WHERE id = ? AND status NOT IN ('running', 'awaiting_approval')
+	`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if claimed, err := result.RowsAffected(); err != nil || claimed > 0 {
+		return err
+	}
+
+	var status string
+	if err := database.DB.QueryRow("SELECT status FROM deployment_tasks WHERE id = ?", id).Scan(&status); err != nil {
+		return fmt.Errorf("deployment task %d not found: %v", id, err)
+	}
+	if status == "awaiting_approval" {
+		return &InvalidRequestError{Message: "Deployment task is awaiting canary approval"}
+	}
+	return &InvalidRequestError{Message: "Deployment task is already running"}
+}
+
+// ExecuteDeploymentTaskByID 加载部署任务并同步执行，返回执行后的任务状态，供定时任务和工作流调用
+func ExecuteDeploymentTaskByID(id int) (*models.DeploymentTask, error) {
+	task, err := LoadDeploymentTask(id)
+	if err != nil {
+		return nil, fmt.Errorf("deployment task %d not found: %v", id, err)
+	}
+	if err := ClaimDeploymentTask(id); err != nil {
+		return nil, err
+	}
+
+	if err := DeployProject(task, DeploymentRequest{}); err != nil {
+		database.DB.Exec("UPDATE deployment_tasks SET status = 'failed', updated_at = ? WHERE id = ?",
+			time.Now(), task.ID)
+		return nil, err
+	}
+	return LoadDeploymentTask(id)
+}
+
+// DeploymentRequest 触发部署的附加信息
+type DeploymentRequest struct {
+	Ref       string // 部署的标签、提交或分支，为空时部署任务分支的最新提交
+	CommitSHA string // Webhook推送的提交，记录在部署会话上
+}
+
+// DeployProject 部署项目到主机组，每个主机克隆到同名的新版本目录，构建成功后切换current
+// local构建方式下先在本机构建一次制品，再上传到每个主机的版本目录
+// 按批次滚动部署，开启金丝雀时先部署第一台主机并等待批准
+func DeployProject(task *models.DeploymentTask, req DeploymentRequest) error {
+	if err := ValidateDeploymentRef(req.Ref); err != nil {
+		return err
+	}
+	release := newReleaseName()
 	sessionName := fmt.Sprintf("%s_%s", task.Name, time.Now().Format("2006-01-02_15:04:05"))
+	if err := startDeploymentSession(task, sessionName, release, req); err != nil {
+		return err
+	}
+
+	hosts, err := loadDeploymentHosts(task)
+	if err != nil {
+		finishDeploymentSession(task, sessionName, "failed")
+		return err
+	}
+
+	var artifact *models.DeploymentArtifact
+	if task.BuildMode == DeploymentBuildLocal {
+		if artifact, err = buildDeploymentArtifact(task, sessionName, release, req.Ref); err != nil {
+			skipDeploymentHosts(task, sessionName, hosts, "Artifact build failed")
+			return finishDeploymentSession(task, sessionName, "failed")
+		}
+	}
+	return rolloutDeployment(task, sessionName, hosts, nil, task.Canary, releaseDeployer(task, release, req.Ref, artifact))
+}
+
+// releaseDeployer 返回将版本部署到单个主机的函数：有制品时上传制品，否则在主机上检出并构建
+func releaseDeployer(task *models.DeploymentTask, release, ref string, artifact *models.DeploymentArtifact) func(host models.Host) (hostDeployment, error) {
+	return func(host models.Host) (hostDeployment, error) {
+		if artifact != nil {
+			return executeArtifactDeployment(host, task, artifact)
+		}
+		return executeDeploymentScript(host, task, release, ref)
+	}
+}
+
+// RollbackDeployment 将主机组的current切换回之前的版本并重启应用，release为空时回滚到各主机当前版本的上一个版本
+func RollbackDeployment(task *models.DeploymentTask, release string) error {
+	sessionName := fmt.Sprintf("%s_rollback_%s", task.Name, time.Now().Format("2006-01-02_15:04:05"))
+	if err := startDeploymentSession(task, sessionName, "", DeploymentRequest{}); err != nil {
+		return err
+	}
+
+	hosts, err := loadDeploymentHosts(task)
+	if err != nil {
+		finishDeploymentSession(task, sessionName, "failed")
+		return err
+	}
 
-	// 创建部署会话记录
+	status := "success"
+	for _, host := range hosts {
+		result := deployHost(task, sessionName, host, func(host models.Host) (hostDeployment, error) {
+			return executeRollbackScript(host, task, release)
+		})
+		if !result.Success {
+			status = "failed"
+		}
+	}
+	return finishDeploymentSession(task, sessionName, status)
+}
+
+// startDeploymentSession 创建部署会话记录并将任务置为运行中，回滚会话的release为空
+func startDeploymentSession(task *models.DeploymentTask, sessionName, release string, req DeploymentRequest) error {
 	_, err := database.DB.Exec(`
-		INSERT INTO deployment_sessions (task_id, session_name, created_at) 
-		VALUES (?, ?, ?)
-	`, task.ID, sessionName, time.Now())
+		INSERT INTO deployment_sessions (task_id, session_name, status, release, ref, commit_sha, created_at) 
+		VALUES (?, ?, 'running', ?, ?, ?, ?)
+	`, task.ID, sessionName, release, req.Ref, req.CommitSHA, time.Now())
 	if err != nil {
 		log.Printf("Failed to create deployment session: %v", err)
 		return err
 	}
 
-	// 更新任务状态为运行中
 	_, err = database.DB.Exec("UPDATE deployment_tasks SET status = 'running', updated_at = ? WHERE id = ?",
 		time.Now(), task.ID)
+	return err
+}
+
+// finishDeploymentSession 更新会话状态，并同步任务状态（会话被中止时任务为failed）
+func finishDeploymentSession(task *models.DeploymentTask, sessionName, status string) error {
+	_, err := database.DB.Exec("UPDATE deployment_sessions SET status = ? WHERE task_id = ? AND session_name = ?",
+		status, task.ID, sessionName)
 	if err != nil {
-		return err
+		log.Printf("Failed to update deployment session %s: %v", sessionName, err)
 	}
 
-	// 获取主机组下的所有主机（包含认证信息）
-	rows, err := database.DB.Query("SELECT ip, port, username, password FROM hosts WHERE host_group_id = ?", task.HostGroupID)
-	if err != nil {
-		return err
+	taskStatus := status
+	if status == "aborted" {
+		taskStatus = "failed"
 	}
-	defer rows.Close()
+	_, err = database.DB.Exec("UPDATE deployment_tasks SET status = ?, updated_at = ? WHERE id = ?",
+		taskStatus, time.Now(), task.ID)
+	return err
+}
 
-	var hosts []models.Host
-	for rows.Next() {
-		var host models.Host
-		if err := rows.Scan(&host.IP, &host.Port, &host.Username, &host.Password); err != nil {
-			continue
-		}
-		hosts = append(hosts, host)
+// loadDeploymentHosts 获取主机组下的所有主机（包含认证信息）
+func loadDeploymentHosts(task *models.DeploymentTask) ([]models.Host, error) {
+	hosts, err := LoadHostsByGroupID(task.HostGroupID)
+	if err != nil {
+		return nil, err
 	}
 
 	// 如果没有主机，检查hosts字段（兼容旧数据）
@@ -67,171 +206,123 @@ func DeployProject(task *models.DeploymentTask) error {
 			}
 		}
 	}
+	return hosts, nil
+}
 
-	allSuccess := true
-	// 对每个主机执行部署
-	for _, host := range hosts {
-		log.Printf("Deploying to host: %s", host.IP)
-
-		// 记录部署开始
-		_, err := database.DB.Exec(`
-			INSERT INTO deployment_logs (task_id, session_name, host, status, output, deployed_at) 
-			VALUES (?, ?, ?, 'running', 'Starting deployment...', ?)
-		`, task.ID, sessionName, host.IP, time.Now())
-		if err != nil {
-			log.Printf("Failed to insert deployment log: %v", err)
-		}
+// hostDeployment 单个主机部署或回滚的输出，以及current指向的版本和提交
+type hostDeployment struct {
+	Output        string
+	Release       string
+	CommitSHA     string
+	CommitMessage string
+}
 
-		// 执行部署脚本
-		output, deployErr := executeDeploymentScript(host, task)
+// hostDeployResult 单个主机的部署结果，Switched表示current已切换到本次的版本
+type hostDeployResult struct {
+	Host      models.Host
+	Success   bool
+	Switched  bool
+	CommitSHA string // 版本目录检出的提交
+}
 
-		status := "success"
-		errorMsg := ""
-		if deployErr != nil {
-			status = "failed"
-			errorMsg = deployErr.Error()
-			allSuccess = false
-		}
+// deployHost 在主机上执行deploy并记录部署日志，包括部署的版本和提交
+func deployHost(task *models.DeploymentTask, sessionName string, host models.Host, deploy func(host models.Host) (hostDeployment, error)) hostDeployResult {
+	log.Printf("Deploying to host: %s", host.IP)
 
-		// 更新部署日志
-		_, err = database.DB.Exec(`
-			UPDATE deployment_logs 
-			SET status = ?, output = ?, error = ?, deployed_at = ?
-			WHERE task_id = ? AND session_name = ? AND host = ? AND status = 'running'
-		`, status, output, errorMsg, time.Now(), task.ID, sessionName, host.IP)
-		if err != nil {
-			log.Printf("Failed to update deployment log: %v", err)
-		}
+	// 记录部署开始
+	res, err := database.DB.Exec(`
+		INSERT INTO deployment_logs (task_id, session_name, host, status, output, deployed_at) 
+		VALUES (?, ?, ?, 'running', 'Starting deployment...', ?)
+	`, task.ID, sessionName, host.IP, time.Now())
+	var logID int64
+	if err != nil {
+		log.Printf("Failed to insert deployment log: %v", err)
+	} else {
+		logID, _ = res.LastInsertId()
 	}
 
-	// 更新任务最终状态
-	finalStatus := "success"
-	if !allSuccess {
-		finalStatus = "failed"
+	// 执行部署脚本
+	deployment, deployErr := deploy(host)
+	output := deployment.Output
+	result := hostDeployResult{
+		Host:      host,
+		Success:   deployErr == nil,
+		Switched:  deployment.Release != "" && strings.Contains(output, "Switched current to release "+deployment.Release),
+		CommitSHA: deployment.CommitSHA,
 	}
 
-	_, err = database.DB.Exec("UPDATE deployment_tasks SET status = ?, updated_at = ? WHERE id = ?",
-		finalStatus, time.Now(), task.ID)
-	if err != nil {
-		return err
+	status := "success"
+	errorMsg := ""
+	if deployErr != nil {
+		status = "failed"
+		errorMsg = deployErr.Error()
 	}
 
-	return nil
+	// 更新部署日志，超过阈值的输出写入日志文件
+	if logID == 0 {
+		return result
+	}
+	inline, _ := TruncateOutput(output)
+	_, err = database.DB.Exec(`
+		UPDATE deployment_logs 
+		SET status = ?, output = ?, error = ?, service_status = ?, release = ?, commit_sha = ?, commit_message = ?,
+		    switched = ?, deployed_at = ?
+		WHERE id = ?
+	`, status, inline, errorMsg, parseServiceStatus(output), deployment.Release, deployment.CommitSHA, deployment.CommitMessage,
+		result.Switched, time.Now(), logID)
+	if err != nil {
+		log.Printf("Failed to update deployment log: %v", err)
+	}
+	if err := SpillLogOutput(LogTypeDeployment, logID, "output", output); err != nil {
+		log.Printf("Failed to store deployment output for host %s: %v", host.IP, err)
+	}
+	return result
 }
 
-// executeDeploymentScript 执行部署脚本
-func executeDeploymentScript(host models.Host, task *models.DeploymentTask) (string, error) {
-	// 生成部署脚本
-	script := generateDeploymentScript(task)
+// executeDeploymentScript 将分支或指定的ref检出到新版本目录，按任务配置、仓库中的部署清单或检测到的项目类型构建，成功后切换current并启动应用
+func executeDeploymentScript(host models.Host, task *models.DeploymentTask, release, ref string) (hostDeployment, error) {
+	deployment := hostDeployment{Release: release}
+	auth, err := prepareGitAuth(host, task)
+	if err != nil {
+		return deployment, fmt.Errorf("deployment failed: %v", err)
+	}
+	defer auth.cleanup(host, task)
 
-	// 使用SSH服务执行脚本
-	output, err := ExecuteSSHCommand(host.IP, host.Username, host.Password, host.Port, script)
+	// 以任务配置的身份执行脚本
+	output, err := ExecuteAsUser(host, task.RunAs, generateCheckoutScript(task, release, ref, auth))
+	deployment.Output = auth.redact(output)
 	if err != nil {
-		return output, fmt.Errorf("deployment failed: %v", err)
+		return deployment, fmt.Errorf("deployment failed: %v", err)
+	}
+	probe, output, err := parseDeploymentProbe(deployment.Output)
+	deployment.Output = output
+	if err != nil {
+		return deployment, fmt.Errorf("deployment failed: %v", err)
 	}
+	deployment.CommitSHA, deployment.CommitMessage = probe.CommitSHA, probe.CommitMessage
 
-	return output, nil
-}
-
-// generateDeploymentScript 生成部署脚本
-func generateDeploymentScript(task *models.DeploymentTask) string {
-	projectName := getProjectNameFromURL(task.GithubURL)
-	deployPath := fmt.Sprintf("/opt/deployments/%s", projectName)
-
-	script := fmt.Sprintf(`#!/bin/bash
-set -e
-
-# 创建部署目录
-sudo mkdir -p %s
-cd %s
-
-# 检查是否已存在项目目录
-if [ -d "%s" ]; then
-    echo "Project directory exists, pulling latest changes..."
-    cd %s
-    git pull origin %s
-else
-    echo "Cloning repository..."
-    git clone -b %s %s %s
-    cd %s
-fi
-
-# 检查是否存在package.json（Node.js项目）
-if [ -f "package.json" ]; then
-    echo "Detected Node.js project, installing dependencies..."
-    npm install
-    
-    # 检查是否有build脚本
-    if npm run | grep -q "build"; then
-        echo "Building project..."
-        npm run build
-    fi
-    
-    # 检查是否有start脚本
-    if npm run | grep -q "start"; then
-        echo "Starting application..."
-        # 停止可能运行的进程
-        pkill -f "node.*%s" || true
-        # 后台启动应用
-        nohup npm start > /var/log/%s.log 2>&1 &
-        echo "Application started"
-    fi
-elif [ -f "requirements.txt" ]; then
-    echo "Detected Python project, installing dependencies..."
-    pip3 install -r requirements.txt
-    
-    # 检查是否存在main.py或app.py
-    if [ -f "main.py" ]; then
-        echo "Starting Python application..."
-        pkill -f "python.*main.py" || true
-        nohup python3 main.py > /var/log/%s.log 2>&1 &
-    elif [ -f "app.py" ]; then
-        echo "Starting Python application..."
-        pkill -f "python.*app.py" || true
-        nohup python3 app.py > /var/log/%s.log 2>&1 &
-    fi
-elif [ -f "go.mod" ]; then
-    echo "Detected Go project, building..."
-    go build -o %s
-    
-    # 停止可能运行的进程
-    pkill -f "%s" || true
-    # 启动应用
-    nohup ./%s > /var/log/%s.log 2>&1 &
-    echo "Go application started"
-elif [ -f "Dockerfile" ]; then
-    echo "Detected Docker project, building and running..."
-    # 停止并删除旧容器
-    docker stop %s || true
-    docker rm %s || true
-    
-    # 构建新镜像
-    docker build -t %s .
-    
-    # 运行容器
-    docker run -d --name %s -p 8080:8080 %s
-    echo "Docker container started"
-else
-    echo "Project type not recognized, deployment completed"
-fi
-
-echo "Deployment finished successfully"
-`,
-		deployPath, deployPath, projectName, projectName, task.Branch,
-		task.Branch, task.GithubURL, projectName, projectName,
-		projectName, projectName, projectName, projectName,
-		projectName, projectName, projectName, projectName,
-		projectName, projectName, projectName, projectName, projectName)
-
-	return script
+	plan := resolveDeploymentPlan(task, release, probe)
+	runOutput, err := activateRelease(host, task, plan)
+	deployment.Output += runOutput
+	return deployment, err
+}
+
+// activateRelease 在版本目录中执行部署计划（构建、切换current并启动应用），然后执行健康检查
+func activateRelease(host models.Host, task *models.DeploymentTask, plan *DeploymentPlan) (string, error) {
+	output, err := ExecuteAsUser(host, task.RunAs, generateRunScript(plan))
+	if err != nil {
+		return output, fmt.Errorf("deployment failed: %v", err)
+	}
+	healthOutput, err := runHealthCheck(host, task, plan)
+	return output + healthOutput, err
 }
 
 // getProjectNameFromURL 从GitHub URL提取项目名
 func getProjectNameFromURL(url string) string {
-	// 移除.git后缀
-	url = strings.TrimSuffix(url, ".git")
-	// 获取最后一个/后的内容
-	parts := strings.Split(url, "/")
+	// 移除末尾的/和.git后缀
+	url = strings.TrimSuffix(strings.TrimRight(url, "/"), ".git")
+	// 获取最后一个/或:后的内容（兼容git@host:repo形式的SSH地址）
+	parts := strings.FieldsFunc(url, func(r rune) bool { return r == '/' || r == ':' })
 	if len(parts) > 0 {
 		return parts[len(parts)-1]
 	}
@@ -0,0 +1,624 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"runme-backend/models"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 部署策略
+const (
+	DeploymentStrategyAuto   = "auto"
+	DeploymentStrategyNode   = "node"
+	DeploymentStrategyPython = "python"
+	DeploymentStrategyGo     = "go"
+	DeploymentStrategyDocker = "docker"
+	DeploymentStrategyCustom = "custom"
+)
+
+// 应用进程的管理方式
+const (
+	DeploymentProcessNohup   = "nohup"
+	DeploymentProcessSystemd = "systemd"
+)
+
+// DeploymentManifestFile 仓库根目录中的部署清单，任务未配置的项使用清单中的值
+const DeploymentManifestFile = ".runme.yml"
+
+// deploymentProbeFiles auto策略用于检测项目类型的文件
+var deploymentProbeFiles = []string{"package.json", "requirements.txt", "main.py", "app.py", "go.mod", "Dockerfile"}
+
+const (
+	deploymentProbeBegin = "__RUNME_PROBE_BEGIN__"
+	deploymentProbeEnd   = "__RUNME_PROBE_END__"
+)
+
+var (
+	deploymentEnvKeyPattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	deploymentAppNameInvalid = regexp.MustCompile(`[^a-z0-9_.-]+`)
+)
+
+// DeploymentCommands 部署在主机上执行的命令
+type DeploymentCommands struct {
+	Build string // 在代码目录中执行，失败时不停止正在运行的应用
+	Start string // 启动应用，为空表示只更新代码
+	Stop  string // 停止应用，在构建成功后、启动前执行
+	// Detached 为true时Start自行在后台运行（如docker run -d），否则由RunMe以后台进程方式启动并记录PID
+	Detached bool
+}
+
+// DeploymentStrategy 部署策略，提供项目类型检测和默认命令
+type DeploymentStrategy interface {
+	// Detect 根据仓库根目录中存在的文件判断是否适用于auto策略
+	Detect(files map[string]bool) bool
+	// Commands 返回该策略的默认命令
+	Commands(plan *DeploymentPlan) DeploymentCommands
+}
+
+// deploymentStrategies 内置策略，custom策略没有默认命令
+var deploymentStrategies = map[string]DeploymentStrategy{
+	DeploymentStrategyNode:   nodeDeploymentStrategy{},
+	DeploymentStrategyPython: pythonDeploymentStrategy{},
+	DeploymentStrategyGo:     goDeploymentStrategy{},
+	DeploymentStrategyDocker: dockerDeploymentStrategy{},
+	DeploymentStrategyCustom: customDeploymentStrategy{},
+}
+
+// deploymentDetectOrder auto策略的检测顺序
+var deploymentDetectOrder = []string{
+	DeploymentStrategyNode,
+	DeploymentStrategyPython,
+	DeploymentStrategyGo,
+	DeploymentStrategyDocker,
+}
+
+// DeploymentManifest 仓库中.runme.yml的内容
+type DeploymentManifest struct {
+	Strategy string            `yaml:"strategy"`
+	Build    string            `yaml:"build"`
+	Start    string            `yaml:"start"`
+	Stop     string            `yaml:"stop"`
+	Env      map[string]string `yaml:"env"`
+	Ports    []int             `yaml:"ports"`
+}
+
+// DeploymentPlan 一次部署在单个主机上的执行计划，由任务配置、仓库清单和策略默认命令合并而成
+type DeploymentPlan struct {
+	TaskName       string
+	AppName        string
+	BaseDir        string // 部署根目录，包含releases、current和shared
+	Release        string // 本次部署或回滚的版本目录名
+	Rollback       bool   // 回滚到已构建的版本，不再构建
+	Artifact       bool   // 版本目录由本机构建的制品解压得到，Build为安装命令
+	KeepReleases   int
+	Strategy       string
+	Detected       bool // 策略由auto检测得到
+	Files          map[string]bool
+	Env            map[string]string
+	Ports          []int
+	ProcessManager string
+	ServiceUser    string
+	RestartPolicy  string
+	DeploymentCommands
+}
+
+// ValidateDeploymentTask 规范化并校验部署任务的策略、目录、环境变量和端口
+func ValidateDeploymentTask(task *models.DeploymentTask) error {
+	task.Strategy = strings.TrimSpace(task.Strategy)
+	if task.Strategy == "" {
+		task.Strategy = DeploymentStrategyAuto
+	}
+	if task.Strategy != DeploymentStrategyAuto && deploymentStrategies[task.Strategy] == nil {
+		return &InvalidRequestError{Message: fmt.Sprintf("unsupported deployment strategy %q", task.Strategy)}
+	}
+	if task.Strategy == DeploymentStrategyCustom && strings.TrimSpace(task.StartCommand) == "" && strings.TrimSpace(task.BuildCommand) == "" {
+		return &InvalidRequestError{Message: "custom strategy requires a build or start command"}
+	}
+
+	task.DeployPath = strings.TrimSpace(task.DeployPath)
+	if task.DeployPath != "" {
+		if !path.IsAbs(task.DeployPath) {
+			return &InvalidRequestError{Message: "deploy_path must be an absolute path"}
+		}
+		task.DeployPath = path.Clean(task.DeployPath)
+		if task.DeployPath == "/" {
+			return &InvalidRequestError{Message: "deploy_path must not be /"}
+		}
+	}
+
+	if err := validateDeploymentProcessManager(task); err != nil {
+		return err
+	}
+	if err := validateDeploymentRepository(task); err != nil {
+		return err
+	}
+	if err := validateDeploymentBuild(task); err != nil {
+		return err
+	}
+	if err := ValidateKeepReleases(task); err != nil {
+		return err
+	}
+	if err := validateDeploymentRollout(task); err != nil {
+		return err
+	}
+	if err := validateDeploymentEnv(task.Env); err != nil {
+		return &InvalidRequestError{Message: err.Error()}
+	}
+	if err := validateDeploymentPorts(task.Ports); err != nil {
+		return &InvalidRequestError{Message: err.Error()}
+	}
+	if task.Env == nil {
+		task.Env = map[string]string{}
+	}
+	if task.Ports == nil {
+		task.Ports = []int{}
+	}
+	return nil
+}
+
+// validateDeploymentProcessManager 校验进程管理方式，安装systemd服务需要以root身份执行部署
+func validateDeploymentProcessManager(task *models.DeploymentTask) error {
+	task.ProcessManager = strings.TrimSpace(task.ProcessManager)
+	if task.ProcessManager == "" {
+		task.ProcessManager = DeploymentProcessNohup
+	}
+	if task.RestartPolicy == "" {
+		task.RestartPolicy = "on-failure"
+	}
+	switch task.ProcessManager {
+	case DeploymentProcessNohup:
+		return nil
+	case DeploymentProcessSystemd:
+	default:
+		return &InvalidRequestError{Message: fmt.Sprintf("unsupported process manager %q", task.ProcessManager)}
+	}
+
+	if task.RunAs != "root" {
+		return &InvalidRequestError{Message: "systemd process manager installs a unit file and requires run_as root"}
+	}
+	if task.ServiceUser == "" {
+		task.ServiceUser = "root"
+	}
+	if err := ValidateRunAs(task.ServiceUser); err != nil {
+		return &InvalidRequestError{Message: fmt.Sprintf("invalid service_user %q", task.ServiceUser)}
+	}
+	if !systemdRestartPolicies[task.RestartPolicy] {
+		return &InvalidRequestError{Message: fmt.Sprintf("unsupported restart_policy %q", task.RestartPolicy)}
+	}
+	return nil
+}
+
+func validateDeploymentEnv(env map[string]string) error {
+	for key := range env {
+		if !deploymentEnvKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid environment variable name %q", key)
+		}
+	}
+	return nil
+}
+
+func validateDeploymentPorts(ports []int) error {
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+	return nil
+}
+
+// EncodeDeploymentSettings 将环境变量和端口编码为JSON保存
+func EncodeDeploymentSettings(task models.DeploymentTask) (env string, ports string) {
+	if task.Env == nil {
+		task.Env = map[string]string{}
+	}
+	if task.Ports == nil {
+		task.Ports = []int{}
+	}
+	envData, _ := json.Marshal(task.Env)
+	portsData, _ := json.Marshal(task.Ports)
+	return string(envData), string(portsData)
+}
+
+// ParseDeploymentSettings 解析数据库中以JSON保存的环境变量和端口
+func ParseDeploymentSettings(task *models.DeploymentTask, env, ports string) error {
+	task.Env = map[string]string{}
+	task.Ports = []int{}
+	if strings.TrimSpace(env) != "" {
+		if err := json.Unmarshal([]byte(env), &task.Env); err != nil {
+			return fmt.Errorf("invalid deployment env: %v", err)
+		}
+	}
+	if strings.TrimSpace(ports) != "" {
+		if err := json.Unmarshal([]byte(ports), &task.Ports); err != nil {
+			return fmt.Errorf("invalid deployment ports: %v", err)
+		}
+	}
+	return nil
+}
+
+// deploymentAppName 应用名称，用于容器名、镜像名、二进制文件名和日志文件名
+func deploymentAppName(task *models.DeploymentTask) string {
+	name := deploymentAppNameInvalid.ReplaceAllString(strings.ToLower(getProjectNameFromURL(task.GithubURL)), "-")
+	name = strings.Trim(name, "-.")
+	if name == "" {
+		name = fmt.Sprintf("deployment-%d", task.ID)
+	}
+	return name
+}
+
+// deploymentBaseDir 部署根目录，未配置时使用/opt/deployments/<项目>
+func deploymentBaseDir(task *models.DeploymentTask) string {
+	if task.DeployPath != "" {
+		return task.DeployPath
+	}
+	return "/opt/deployments/" + getProjectNameFromURL(task.GithubURL)
+}
+
+// generateCheckoutScript 生成将代码检出到新版本目录的脚本，ref为空时检出任务分支的最新提交
+// 结束时输出项目文件、部署清单和检出的提交供选择策略和记录版本
+func generateCheckoutScript(task *models.DeploymentTask, release, ref string, auth *gitAuth) string {
+	baseDir := deploymentBaseDir(task)
+	releaseDir := ShellQuote(releasePath(baseDir, release))
+
+	return fmt.Sprintf(`#!/bin/bash
+set -e
+
+mkdir -p %[1]s %[2]s
+if [ -e %[3]s ]; then
+    echo "Release %[4]s already exists"
+    exit 1
+fi
+%[5]s%[6]s
+cd %[3]s
+
+%[7]s`, ShellQuote(path.Join(baseDir, "releases")), ShellQuote(path.Join(baseDir, "shared")), releaseDir, release,
+		gitEnvScript(auth), gitCheckoutScript(task, releaseDir, release, ref), deploymentProbeScript(""))
+}
+
+// deploymentProbeScript 在当前目录输出项目文件和部署清单，releaseExpr不为空时同时输出该shell表达式（如"release:$target"）
+func deploymentProbeScript(releaseExpr string) string {
+	var probe strings.Builder
+	fmt.Fprintf(&probe, "echo %s\n", deploymentProbeBegin)
+	if releaseExpr != "" {
+		fmt.Fprintf(&probe, "echo %s\n", releaseExpr)
+	}
+	for _, file := range deploymentProbeFiles {
+		fmt.Fprintf(&probe, "[ -f %s ] && echo %s\n", ShellQuote(file), ShellQuote("file:"+file))
+	}
+	fmt.Fprintf(&probe, "if [ -f %[1]s ]; then\n    echo \"manifest:$(base64 < %[1]s | tr -d '\\n')\"\nfi\n", ShellQuote(DeploymentManifestFile))
+	// 提交说明可能包含任意字符，base64编码后输出
+	probe.WriteString("if git rev-parse -q --verify HEAD > /dev/null 2>&1; then\n")
+	probe.WriteString("    echo \"commit:$(git rev-parse HEAD)\"\n")
+	probe.WriteString("    echo \"message:$(git log -1 --format=%s | base64 | tr -d '\\n')\"\n")
+	probe.WriteString("fi\n")
+	fmt.Fprintf(&probe, "echo %s\n", deploymentProbeEnd)
+	return probe.String()
+}
+
+// deploymentProbe 版本目录中的项目文件、部署清单和检出的提交
+type deploymentProbe struct {
+	Release       string
+	Files         map[string]bool
+	Manifest      *DeploymentManifest
+	CommitSHA     string
+	CommitMessage string
+}
+
+// parseDeploymentProbe 从脚本输出中提取探测结果，返回去掉探测内容后的输出
+func parseDeploymentProbe(output string) (*deploymentProbe, string, error) {
+	begin := strings.Index(output, deploymentProbeBegin)
+	end := strings.Index(output, deploymentProbeEnd)
+	if begin < 0 || end < begin {
+		return nil, output, fmt.Errorf("failed to inspect repository")
+	}
+	lines := output[begin+len(deploymentProbeBegin) : end]
+	cleaned := output[:begin] + strings.TrimLeft(output[end+len(deploymentProbeEnd):], "\r\n")
+
+	probe := &deploymentProbe{Files: make(map[string]bool)}
+	for _, line := range strings.Split(lines, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "release:"):
+			probe.Release = strings.TrimPrefix(line, "release:")
+		case strings.HasPrefix(line, "commit:"):
+			probe.CommitSHA = strings.TrimPrefix(line, "commit:")
+		case strings.HasPrefix(line, "message:"):
+			if message, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "message:")); err == nil {
+				probe.CommitMessage = strings.TrimSpace(string(message))
+			}
+		case strings.HasPrefix(line, "file:"):
+			probe.Files[strings.TrimPrefix(line, "file:")] = true
+		case strings.HasPrefix(line, "manifest:"):
+			data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "manifest:"))
+			if err != nil {
+				return nil, cleaned, fmt.Errorf("failed to read %s: %v", DeploymentManifestFile, err)
+			}
+			if probe.Manifest, err = parseDeploymentManifest(data); err != nil {
+				return nil, cleaned, err
+			}
+		}
+	}
+	return probe, cleaned, nil
+}
+
+// parseDeploymentManifest 解析并校验部署清单
+func parseDeploymentManifest(data []byte) (*DeploymentManifest, error) {
+	var manifest DeploymentManifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", DeploymentManifestFile, err)
+	}
+	manifest.Strategy = strings.TrimSpace(manifest.Strategy)
+	if manifest.Strategy != "" && manifest.Strategy != DeploymentStrategyAuto && deploymentStrategies[manifest.Strategy] == nil {
+		return nil, fmt.Errorf("invalid %s: unsupported strategy %q", DeploymentManifestFile, manifest.Strategy)
+	}
+	if err := validateDeploymentEnv(manifest.Env); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", DeploymentManifestFile, err)
+	}
+	if err := validateDeploymentPorts(manifest.Ports); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", DeploymentManifestFile, err)
+	}
+	return &manifest, nil
+}
+
+// resolveDeploymentPlan 合并任务配置、部署清单和策略默认命令，优先级依次降低
+// 任务和清单都未指定策略时按项目文件自动检测，检测不到时只更新代码
+func resolveDeploymentPlan(task *models.DeploymentTask, release string, probe *deploymentProbe) *DeploymentPlan {
+	manifest, files := probe.Manifest, probe.Files
+	if manifest == nil {
+		manifest = &DeploymentManifest{}
+	}
+	plan := &DeploymentPlan{
+		TaskName:       task.Name,
+		AppName:        deploymentAppName(task),
+		BaseDir:        deploymentBaseDir(task),
+		Release:        release,
+		KeepReleases:   task.KeepReleases,
+		Files:          files,
+		Env:            make(map[string]string),
+		ProcessManager: task.ProcessManager,
+		ServiceUser:    task.ServiceUser,
+		RestartPolicy:  task.RestartPolicy,
+	}
+
+	plan.Strategy = task.Strategy
+	if plan.Strategy == "" || plan.Strategy == DeploymentStrategyAuto {
+		plan.Strategy = manifest.Strategy
+	}
+	if plan.Strategy == "" || plan.Strategy == DeploymentStrategyAuto {
+		plan.Strategy = ""
+		for _, name := range deploymentDetectOrder {
+			if deploymentStrategies[name].Detect(files) {
+				plan.Strategy = name
+				plan.Detected = true
+				break
+			}
+		}
+	}
+
+	for key, value := range manifest.Env {
+		plan.Env[key] = value
+	}
+	for key, value := range task.Env {
+		plan.Env[key] = value
+	}
+	plan.Ports = task.Ports
+	if len(plan.Ports) == 0 {
+		plan.Ports = manifest.Ports
+	}
+
+	if strategy := deploymentStrategies[plan.Strategy]; strategy != nil {
+		plan.DeploymentCommands = strategy.Commands(plan)
+	}
+	plan.Build = firstNonEmpty(task.BuildCommand, manifest.Build, plan.Build)
+	// 自定义的启动命令不一定会自行后台运行，由RunMe以后台进程方式启动
+	if start := firstNonEmpty(task.StartCommand, manifest.Start); start != "" {
+		plan.Start = start
+		plan.Detached = false
+	}
+	plan.Stop = firstNonEmpty(task.StopCommand, manifest.Stop, plan.Stop)
+	return plan
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// runtimeEnv 应用的环境变量，配置了端口且未设置PORT时使用第一个端口
+func (plan *DeploymentPlan) runtimeEnv() map[string]string {
+	env := make(map[string]string, len(plan.Env)+1)
+	for key, value := range plan.Env {
+		env[key] = value
+	}
+	if _, ok := env["PORT"]; !ok && len(plan.Ports) > 0 {
+		env["PORT"] = strconv.Itoa(plan.Ports[0])
+	}
+	return env
+}
+
+// sortedKeys 按名称排序的环境变量名，保证生成的脚本稳定
+func sortedKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// generateRunScript 生成在版本目录中构建并启动应用的脚本：构建成功后停止旧进程，切换current再启动，最后清理旧版本
+// 构建失败时删除该版本目录，current仍指向之前的版本
+func generateRunScript(plan *DeploymentPlan) string {
+	releaseDir := ShellQuote(plan.ReleaseDir())
+
+	var script strings.Builder
+	script.WriteString("#!/bin/bash\nset -e\n\n")
+	fmt.Fprintf(&script, "cd %s\n", releaseDir)
+
+	env := plan.runtimeEnv()
+	for _, key := range sortedKeys(env) {
+		fmt.Fprintf(&script, "export %s=%s\n", key, ShellQuote(env[key]))
+	}
+
+	switch {
+	case plan.Strategy == "":
+		script.WriteString("echo \"Project type not recognized\"\n")
+	case plan.Detected:
+		fmt.Fprintf(&script, "echo %s\n", ShellQuote("Detected "+plan.Strategy+" project"))
+	default:
+		fmt.Fprintf(&script, "echo %s\n", ShellQuote("Using "+plan.Strategy+" strategy"))
+	}
+
+	if plan.Build != "" && !plan.Rollback {
+		if plan.Artifact {
+			script.WriteString("\necho \"Installing artifact...\"\n")
+		} else {
+			script.WriteString("\necho \"Building project...\"\n")
+		}
+		fmt.Fprintf(&script, "trap 'echo \"Build failed, removing release %s\"; cd /; rm -rf %s' EXIT\n",
+			plan.Release, strings.ReplaceAll(releaseDir, "'", `'"'"'`))
+		script.WriteString(strings.TrimRight(plan.Build, "\n") + "\n")
+		script.WriteString("trap - EXIT\n")
+	}
+
+	hasProcess := plan.Start != "" || plan.Stop != ""
+	pidFile := ShellQuote(path.Join(plan.SharedDir(), plan.AppName+".pid"))
+	logFile := ShellQuote(path.Join(plan.SharedDir(), plan.AppName+".log"))
+	if hasProcess {
+		// 停止RunMe上次以后台进程启动的应用（整个进程组）；改为nohup方式时停用之前安装的systemd服务
+		script.WriteString("\necho \"Stopping application...\"\n")
+		fmt.Fprintf(&script, `if [ -f %[1]s ]; then
+    pid=$(cat %[1]s)
+    kill -- -"$pid" 2>/dev/null || kill "$pid" 2>/dev/null || true
+    rm -f %[1]s
+fi
+`, pidFile)
+		if plan.ProcessManager == DeploymentProcessSystemd {
+			fmt.Fprintf(&script, "systemctl stop %s 2>/dev/null || true\n", ShellQuote(systemdUnitName(plan)))
+		} else {
+			script.WriteString(systemdRemoveScript(plan))
+		}
+		if plan.Stop != "" {
+			script.WriteString(strings.TrimRight(plan.Stop, "\n") + "\n")
+		}
+	}
+
+	script.WriteString(releaseSwitchScript(plan))
+
+	if plan.Start != "" {
+		script.WriteString("\necho \"Starting application...\"\n")
+		fmt.Fprintf(&script, "cd %s\n", ShellQuote(plan.CurrentDir()))
+		if plan.Detached {
+			script.WriteString(strings.TrimRight(plan.Start, "\n") + "\n")
+		} else if plan.ProcessManager == DeploymentProcessSystemd {
+			script.WriteString(systemdInstallScript(plan))
+		} else {
+			fmt.Fprintf(&script, "setsid nohup bash -c %s > %s 2>&1 < /dev/null &\n", ShellQuote(plan.Start), logFile)
+			fmt.Fprintf(&script, "echo $! > %s\n", pidFile)
+			fmt.Fprintf(&script, "echo \"Application started, logs in %s\"\n", strings.Trim(logFile, "'"))
+		}
+	}
+
+	if !plan.Rollback {
+		script.WriteString(releaseCleanupScript(plan))
+	}
+	script.WriteString("\necho \"Deployment finished successfully\"\n")
+	return script.String()
+}
+
+// nodeDeploymentStrategy Node.js项目：安装依赖，存在build脚本时构建，使用npm start启动
+type nodeDeploymentStrategy struct{}
+
+func (nodeDeploymentStrategy) Detect(files map[string]bool) bool { return files["package.json"] }
+
+func (nodeDeploymentStrategy) Commands(plan *DeploymentPlan) DeploymentCommands {
+	return DeploymentCommands{
+		Build: "npm install\nif npm run | grep -q \"build\"; then npm run build; fi",
+		Start: "npm start",
+	}
+}
+
+// pythonDeploymentStrategy Python项目：安装requirements.txt，启动main.py或app.py
+type pythonDeploymentStrategy struct{}
+
+func (pythonDeploymentStrategy) Detect(files map[string]bool) bool { return files["requirements.txt"] }
+
+func (pythonDeploymentStrategy) Commands(plan *DeploymentPlan) DeploymentCommands {
+	commands := DeploymentCommands{Build: "pip3 install -r requirements.txt"}
+	for _, entry := range []string{"main.py", "app.py"} {
+		if plan.Files[entry] {
+			commands.Start = "python3 " + entry
+			break
+		}
+	}
+	return commands
+}
+
+// goDeploymentStrategy Go项目：编译为以应用名称命名的二进制文件并运行
+type goDeploymentStrategy struct{}
+
+func (goDeploymentStrategy) Detect(files map[string]bool) bool { return files["go.mod"] }
+
+func (goDeploymentStrategy) Commands(plan *DeploymentPlan) DeploymentCommands {
+	return DeploymentCommands{
+		Build: "go build -o " + ShellQuote(plan.AppName),
+		Start: "./" + ShellQuote(plan.AppName),
+	}
+}
+
+// dockerDeploymentStrategy Docker项目：构建镜像并以应用名称运行容器，环境变量传入容器，未配置端口时映射8080
+type dockerDeploymentStrategy struct{}
+
+func (dockerDeploymentStrategy) Detect(files map[string]bool) bool { return files["Dockerfile"] }
+
+func (dockerDeploymentStrategy) Commands(plan *DeploymentPlan) DeploymentCommands {
+	name := ShellQuote(plan.AppName)
+	// 镜像按版本打标签，回滚时直接运行之前版本的镜像
+	image := ShellQuote(plan.AppName + ":" + plan.Release)
+	ports := plan.Ports
+	if len(ports) == 0 {
+		ports = []int{8080}
+	}
+
+	// systemd方式下容器在前台运行，由systemd负责重启
+	detached := plan.ProcessManager != DeploymentProcessSystemd
+	run := []string{"docker run --rm --name " + name}
+	if detached {
+		run = []string{"docker run -d --name " + name}
+	}
+	for _, key := range sortedKeys(plan.runtimeEnv()) {
+		run = append(run, "-e "+key)
+	}
+	for _, port := range ports {
+		run = append(run, "-p "+strconv.Itoa(port)+":"+strconv.Itoa(port))
+	}
+	run = append(run, image)
+
+	return DeploymentCommands{
+		Build:    "docker build -t " + image + " .",
+		Stop:     "docker stop " + name + " || true\ndocker rm " + name + " || true",
+		Start:    strings.Join(run, " "),
+		Detached: detached,
+	}
+}
+
+// customDeploymentStrategy 完全使用任务或清单中配置的命令
+type customDeploymentStrategy struct{}
+
+func (customDeploymentStrategy) Detect(files map[string]bool) bool { return false }
+
+func (customDeploymentStrategy) Commands(plan *DeploymentPlan) DeploymentCommands {
+	return DeploymentCommands{}
+}
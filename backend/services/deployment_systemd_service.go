@@ -0,0 +1,132 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// deploymentServiceStatusMarker 部署脚本输出systemd服务状态的行前缀
+const deploymentServiceStatusMarker = "Service status: "
+
+// systemdStartTimeout 重启服务后等待的秒数，之后检查服务是否仍在运行
+const systemdStartTimeout = 3
+
+// systemdRestartPolicies systemd支持的Restart配置
+var systemdRestartPolicies = map[string]bool{
+	"no":          true,
+	"always":      true,
+	"on-success":  true,
+	"on-failure":  true,
+	"on-abnormal": true,
+	"on-abort":    true,
+	"on-watchdog": true,
+}
+
+// systemdUnitName 任务对应的systemd服务名
+func systemdUnitName(plan *DeploymentPlan) string {
+	return "runme-" + plan.AppName + ".service"
+}
+
+// systemdFiles 服务相关文件：unit文件、环境变量文件和启动脚本
+func systemdFiles(plan *DeploymentPlan) (unitPath, envPath, startPath string) {
+	unit := systemdUnitName(plan)
+	base := strings.TrimSuffix(unit, ".service")
+	return "/etc/systemd/system/" + unit, "/etc/runme/" + base + ".env", "/etc/runme/" + base + ".sh"
+}
+
+// generateSystemdUnit 生成systemd unit文件，启动命令写入单独的脚本以避免systemd的转义规则
+func generateSystemdUnit(plan *DeploymentPlan) string {
+	_, envPath, startPath := systemdFiles(plan)
+	return fmt.Sprintf(`[Unit]
+Description=RunMe deployment %s
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+User=%s
+WorkingDirectory=%s
+EnvironmentFile=%s
+ExecStart=/bin/bash %s
+Restart=%s
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`, systemdEscapeLine(plan.TaskName), plan.ServiceUser, plan.CurrentDir(), envPath, startPath, plan.RestartPolicy)
+}
+
+// generateSystemdEnvFile 生成EnvironmentFile，值使用双引号并转义
+func generateSystemdEnvFile(plan *DeploymentPlan) string {
+	env := plan.runtimeEnv()
+	var content strings.Builder
+	for _, key := range sortedKeys(env) {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(env[key])
+		fmt.Fprintf(&content, "%s=\"%s\"\n", key, value)
+	}
+	return content.String()
+}
+
+// systemdEscapeLine unit文件中的单行值，%需要转义
+func systemdEscapeLine(value string) string {
+	value = strings.NewReplacer("\n", " ", "\r", " ").Replace(value)
+	return strings.ReplaceAll(value, "%", "%%")
+}
+
+// writeFileScript 生成写入文件的命令，内容经base64传输以避免引号转义
+func writeFileScript(path, content, mode string) string {
+	return fmt.Sprintf("echo %s | base64 -d > %s\nchmod %s %s\n",
+		base64.StdEncoding.EncodeToString([]byte(content)), ShellQuote(path), mode, ShellQuote(path))
+}
+
+// systemdInstallScript 安装或更新服务并重启，输出服务状态，服务未能保持运行时脚本失败
+func systemdInstallScript(plan *DeploymentPlan) string {
+	unit := ShellQuote(systemdUnitName(plan))
+	unitPath, envPath, startPath := systemdFiles(plan)
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "echo %s\n", ShellQuote("Installing systemd service "+systemdUnitName(plan)+"..."))
+	script.WriteString("mkdir -p /etc/runme\n")
+	// 环境变量可能包含密钥，仅root可读
+	script.WriteString(writeFileScript(envPath, generateSystemdEnvFile(plan), "600"))
+	script.WriteString(writeFileScript(startPath, "#!/bin/bash\n"+strings.TrimRight(plan.Start, "\n")+"\n", "755"))
+	script.WriteString(writeFileScript(unitPath, generateSystemdUnit(plan), "644"))
+	fmt.Fprintf(&script, `systemctl daemon-reload
+systemctl enable %[1]s
+systemctl restart %[1]s
+sleep %[2]d
+status=$(systemctl is-active %[1]s || true)
+echo "%[3]s$status"
+systemctl status --no-pager --lines=20 %[1]s || true
+if [ "$status" != "active" ]; then
+    echo "Service %[4]s is $status"
+    exit 1
+fi
+`, unit, systemdStartTimeout, deploymentServiceStatusMarker, strings.Trim(unit, "'"))
+	return script.String()
+}
+
+// systemdRemoveScript 停用并删除之前以systemd方式安装的服务，用于改回nohup方式
+func systemdRemoveScript(plan *DeploymentPlan) string {
+	unit := ShellQuote(systemdUnitName(plan))
+	unitPath, envPath, startPath := systemdFiles(plan)
+	return fmt.Sprintf(`if [ -f %[2]s ]; then
+    echo "Removing systemd service %[5]s..."
+    systemctl disable --now %[1]s || true
+    rm -f %[2]s %[3]s %[4]s
+    systemctl daemon-reload || true
+fi
+`, unit, ShellQuote(unitPath), ShellQuote(envPath), ShellQuote(startPath), strings.Trim(unit, "'"))
+}
+
+// parseServiceStatus 从部署输出中提取systemd服务状态
+func parseServiceStatus(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, deploymentServiceStatusMarker) {
+			return strings.TrimPrefix(line, deploymentServiceStatusMarker)
+		}
+	}
+	return ""
+}
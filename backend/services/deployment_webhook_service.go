@@ -0,0 +1,182 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"runme-backend/database"
+	"runme-backend/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 支持的Webhook来源
+const (
+	WebhookProviderGitHub = "github"
+	WebhookProviderGitLab = "gitlab"
+	WebhookProviderGitea  = "gitea"
+)
+
+const (
+	// webhookQueuePollInterval 任务正在部署时，排队的推送等待的检查间隔
+	webhookQueuePollInterval = 5 * time.Second
+	// webhookQueueTimeout 排队的推送最长等待时间，超时后丢弃，如金丝雀长时间未批准
+	webhookQueueTimeout = time.Hour
+)
+
+// webhookCommitPattern 推送事件中的提交SHA，会作为ref传入部署脚本
+var webhookCommitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// GenerateWebhookSecret 生成随机的Webhook密钥
+func GenerateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// WebhookEvent 解析后的推送事件
+type WebhookEvent struct {
+	Provider  string
+	Event     string // push，或ping等不触发部署的事件
+	Branch    string // 推送的分支，推送标签时为空
+	CommitSHA string // 推送后的提交，删除分支时为空
+}
+
+// webhookPushPayload GitHub、GitLab和Gitea推送事件的公共字段
+type webhookPushPayload struct {
+	Ref   string `json:"ref"`
+	After string `json:"after"`
+}
+
+// VerifyWebhook 识别Webhook来源并校验签名：GitHub和Gitea使用HMAC-SHA256签名，GitLab使用X-Gitlab-Token
+func VerifyWebhook(header http.Header, body []byte, secret string) (string, error) {
+	switch {
+	case header.Get("X-GitHub-Event") != "":
+		signature := strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		return WebhookProviderGitHub, verifyWebhookHMAC(signature, body, secret)
+	case header.Get("X-Gitea-Event") != "", header.Get("X-Gogs-Event") != "":
+		signature := firstNonEmpty(header.Get("X-Gitea-Signature"), header.Get("X-Gogs-Signature"))
+		return WebhookProviderGitea, verifyWebhookHMAC(signature, body, secret)
+	case header.Get("X-Gitlab-Event") != "":
+		token := header.Get("X-Gitlab-Token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return WebhookProviderGitLab, fmt.Errorf("invalid webhook token")
+		}
+		return WebhookProviderGitLab, nil
+	}
+	return "", fmt.Errorf("unsupported webhook provider")
+}
+
+// verifyWebhookHMAC 比较十六进制的HMAC-SHA256签名
+func verifyWebhookHMAC(signature string, body []byte, secret string) error {
+	expected, err := hex.DecodeString(signature)
+	if signature == "" || err != nil {
+		return fmt.Errorf("missing or malformed webhook signature")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(expected, mac.Sum(nil)) {
+		return fmt.Errorf("invalid webhook signature")
+	}
+	return nil
+}
+
+// ParseWebhookEvent 解析推送事件，非推送事件只返回事件类型
+func ParseWebhookEvent(provider string, header http.Header, body []byte) (*WebhookEvent, error) {
+	event := &WebhookEvent{Provider: provider}
+	switch provider {
+	case WebhookProviderGitHub:
+		event.Event = header.Get("X-GitHub-Event")
+	case WebhookProviderGitea:
+		event.Event = firstNonEmpty(header.Get("X-Gitea-Event"), header.Get("X-Gogs-Event"))
+	case WebhookProviderGitLab:
+		event.Event = header.Get("X-Gitlab-Event")
+		if event.Event == "Push Hook" {
+			event.Event = "push"
+		}
+	}
+	if event.Event != "push" {
+		return event, nil
+	}
+
+	var payload webhookPushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, &InvalidRequestError{Message: fmt.Sprintf("invalid push payload: %v", err)}
+	}
+	if strings.HasPrefix(payload.Ref, "refs/heads/") {
+		event.Branch = strings.TrimPrefix(payload.Ref, "refs/heads/")
+	}
+	// 删除分支时after为全0
+	if strings.Trim(payload.After, "0") != "" {
+		if !webhookCommitPattern.MatchString(payload.After) {
+			return nil, &InvalidRequestError{Message: fmt.Sprintf("invalid commit SHA %q in push payload", payload.After)}
+		}
+		event.CommitSHA = payload.After
+	}
+	return event, nil
+}
+
+// deploymentQueue 每个任务排队等待的Webhook部署，新的推送替换尚未开始的旧推送
+var deploymentQueue = struct {
+	sync.Mutex
+	pending map[int]DeploymentRequest
+}{pending: make(map[int]DeploymentRequest)}
+
+// EnqueueDeployment 将部署加入任务的队列，任务正在部署或等待金丝雀批准时等待其结束后再部署
+func EnqueueDeployment(taskID int, req DeploymentRequest) {
+	deploymentQueue.Lock()
+	_, waiting := deploymentQueue.pending[taskID]
+	deploymentQueue.pending[taskID] = req
+	deploymentQueue.Unlock()
+	if !waiting {
+		go runQueuedDeployment(taskID)
+	}
+}
+
+// runQueuedDeployment 等待任务空闲后取出最新的排队请求执行部署，等待超过webhookQueueTimeout时丢弃
+func runQueuedDeployment(taskID int) {
+	deadline := time.Now().Add(webhookQueueTimeout)
+	for {
+		err := ClaimDeploymentTask(taskID)
+		if _, busy := err.(*InvalidRequestError); busy && time.Now().Before(deadline) {
+			time.Sleep(webhookQueuePollInterval)
+			continue
+		}
+		var task *models.DeploymentTask
+		if err == nil {
+			if task, err = LoadDeploymentTask(taskID); err != nil {
+				database.DB.Exec("UPDATE deployment_tasks SET status = 'failed', updated_at = ? WHERE id = ?",
+					time.Now(), taskID)
+			}
+		}
+		if err != nil {
+			log.Printf("Queued deployment for task %d dropped: %v", taskID, err)
+			deploymentQueue.Lock()
+			delete(deploymentQueue.pending, taskID)
+			deploymentQueue.Unlock()
+			return
+		}
+
+		deploymentQueue.Lock()
+		req := deploymentQueue.pending[taskID]
+		delete(deploymentQueue.pending, taskID)
+		deploymentQueue.Unlock()
+
+		log.Printf("Starting queued deployment for task %s at commit %s", task.Name, req.CommitSHA)
+		if err := DeployProject(task, req); err != nil {
+			database.DB.Exec("UPDATE deployment_tasks SET status = 'failed', updated_at = ? WHERE id = ?",
+				time.Now(), task.ID)
+		}
+		return
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"runme-backend/database"
+	"runme-backend/models"
+)
+
+// DockerTemplateRunResult Docker模板执行结果
+type DockerTemplateRunResult struct {
+	TemplateID      int    `json:"template_id"`
+	HostID          int    `json:"host_id"`
+	Revision        int    `json:"revision"`
+	CommandModified bool   `json:"command_modified"` // 是否使用了修改过的命令
+	Result          string `json:"result"`
+	DockerCheck     string `json:"docker_check"`
+	Error           string `json:"error,omitempty"`
+}
+
+// LoadDockerTemplate 根据ID获取Docker模板
+func LoadDockerTemplate(id int) (*models.DockerTemplate, error) {
+	var template models.DockerTemplate
+	err := database.DB.QueryRow(`
+		SELECT id, name, docker_command, run_as FROM docker_templates WHERE id = ?
+	`, id).Scan(&template.ID, &template.Name, &template.DockerCommand, &template.RunAs)
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// RunDockerTemplate 以模板的执行身份在指定主机上执行Docker命令，command为空时使用模板中保存的命令
+func RunDockerTemplate(template models.DockerTemplate, host models.Host, command string) (*DockerTemplateRunResult, error) {
+	revision, err := EnsureDockerTemplateRevision(template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve template revision: %v", err)
+	}
+	if command == "" {
+		command = template.DockerCommand
+	}
+
+	run := &DockerTemplateRunResult{
+		TemplateID:      template.ID,
+		HostID:          host.ID,
+		Revision:        revision,
+		CommandModified: command != template.DockerCommand,
+	}
+
+	// 先检查Docker是否可用
+	dockerCheckCmd := "docker --version && docker info"
+	checkResult, checkErr := ExecuteAsUser(host, template.RunAs, dockerCheckCmd)
+	run.DockerCheck = checkResult
+
+	var errorDetails string
+	if checkErr != nil {
+		errorDetails = fmt.Sprintf("Docker环境检查失败: %v\n检查结果: %s\n", checkErr, checkResult)
+	}
+
+	// 执行Docker命令，需要root权限时通过模板的run_as配置提权
+	result, err := ExecuteAsUser(host, template.RunAs, command)
+	run.Result = result
+	if err != nil {
+		run.Error = errorDetails + fmt.Sprintf("Failed to execute command: %v", err)
+	}
+
+	return run, nil
+}
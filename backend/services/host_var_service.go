@@ -0,0 +1,161 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"runme-backend/database"
+	"runme-backend/models"
+	"strconv"
+	"strings"
+)
+
+// LoadHostsByGroupID 获取主机组下的所有主机（包含认证信息和元数据）
+func LoadHostsByGroupID(groupID int) ([]models.Host, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, ip, port, username, password, become_password, host_group_id, hostname, tags
+		FROM hosts WHERE host_group_id = ?
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hosts []models.Host
+	for rows.Next() {
+		var host models.Host
+		if err := rows.Scan(&host.ID, &host.IP, &host.Port, &host.Username, &host.Password, &host.BecomePassword,
+			&host.HostGroupID, &host.Hostname, &host.Tags); err != nil {
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, rows.Err()
+}
+
+// GetHostVars 获取主机变量
+func GetHostVars(hostID int) (map[string]string, error) {
+	return queryVars("SELECT key, value FROM host_vars WHERE host_id = ?", hostID)
+}
+
+// GetHostGroupVars 获取主机组变量
+func GetHostGroupVars(groupID int) (map[string]string, error) {
+	return queryVars("SELECT key, value FROM host_group_vars WHERE host_group_id = ?", groupID)
+}
+
+// ReplaceHostVars 使用新的变量集合替换主机变量
+func ReplaceHostVars(hostID int, vars map[string]string) error {
+	return replaceVars("host_vars", "host_id", hostID, vars)
+}
+
+// ReplaceHostGroupVars 使用新的变量集合替换主机组变量
+func ReplaceHostGroupVars(groupID int, vars map[string]string) error {
+	return replaceVars("host_group_vars", "host_group_id", groupID, vars)
+}
+
+// ValidateVarKeys 校验变量名，变量名需要可以作为环境变量名使用
+func ValidateVarKeys(vars map[string]string) error {
+	for key := range vars {
+		if !paramNamePattern.MatchString(key) {
+			return fmt.Errorf("invalid variable name %q: must match %s", key, paramNamePattern.String())
+		}
+		if strings.HasPrefix(key, "RUNME_") {
+			return fmt.Errorf("variable name %q uses the reserved RUNME_ prefix", key)
+		}
+	}
+	return nil
+}
+
+// BuildHostVariables 汇总主机的内置变量、主机组变量和主机变量
+// 优先级：内置变量 < 主机组变量 < 主机变量
+func BuildHostVariables(host models.Host) (map[string]string, error) {
+	var groupName string
+	err := database.DB.QueryRow("SELECT name FROM host_groups WHERE id = ?", host.HostGroupID).Scan(&groupName)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	hostname := host.Hostname
+	if hostname == "" {
+		hostname = host.IP
+	}
+
+	vars := map[string]string{
+		"RUNME_HOST_ID":    strconv.Itoa(host.ID),
+		"RUNME_HOSTNAME":   hostname,
+		"RUNME_HOST_IP":    host.IP,
+		"RUNME_HOST_PORT":  strconv.Itoa(host.Port),
+		"RUNME_HOST_TAGS":  host.Tags,
+		"RUNME_GROUP_ID":   strconv.Itoa(host.HostGroupID),
+		"RUNME_GROUP_NAME": groupName,
+	}
+
+	groupVars, err := GetHostGroupVars(host.HostGroupID)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range groupVars {
+		vars[key] = value
+	}
+
+	hostVars, err := GetHostVars(host.ID)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range hostVars {
+		vars[key] = value
+	}
+
+	return vars, nil
+}
+
+// MergeVariables 合并多组变量，后面的变量覆盖前面的同名变量
+func MergeVariables(sets ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, set := range sets {
+		for key, value := range set {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// queryVars 查询键值对形式的变量
+func queryVars(query string, id int) (map[string]string, error) {
+	rows, err := database.DB.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vars := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		vars[key] = value
+	}
+	return vars, rows.Err()
+}
+
+// replaceVars 在事务中删除旧变量并写入新变量
+func replaceVars(table, ownerColumn string, ownerID int, vars map[string]string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = ?", table, ownerColumn), ownerID); err != nil {
+		return err
+	}
+	for key, value := range vars {
+		if _, err := tx.Exec(
+			fmt.Sprintf("INSERT INTO %s (%s, key, value) VALUES (?, ?, ?)", table, ownerColumn),
+			ownerID, key, value,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,348 @@
+package services
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runme-backend/database"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 支持分段读取和下载的日志类型
+const (
+	LogTypeScript     = "script"
+	LogTypeAnsible    = "ansible"
+	LogTypeDeployment = "deployment"
+	// LogTypeAnsibleSession Playbook会话的完整输出
+	LogTypeAnsibleSession = "ansible_session"
+)
+
+const (
+	// OutputInlineLimit 数据库中保存的输出上限（字节），超出部分写入压缩文件
+	OutputInlineLimit = 64 * 1024
+	// LogChunkDefault 分段读取的默认长度
+	LogChunkDefault = 64 * 1024
+	// LogChunkMax 分段读取的最大长度
+	LogChunkMax = 1024 * 1024
+	// LogFileRetentionDays 日志文件保留天数，过期后只保留数据库中的截断内容
+	LogFileRetentionDays = 30
+)
+
+// logSources 日志类型对应的表和输出字段
+var logSources = map[string]struct {
+	table   string
+	streams []string
+}{
+	LogTypeScript:         {"execution_logs", []string{"output", "stdout", "stderr"}},
+	LogTypeAnsible:        {"ansible_execution_logs", []string{"output"}},
+	LogTypeDeployment:     {"deployment_logs", []string{"output"}},
+	LogTypeAnsibleSession: {"ansible_execution_sessions", []string{"output"}},
+}
+
+// LogChunk 分段读取的日志内容，offset为未压缩内容的字节偏移
+type LogChunk struct {
+	LogType    string `json:"log_type"`
+	LogID      int    `json:"log_id"`
+	Stream     string `json:"stream"`
+	Offset     int64  `json:"offset"`
+	NextOffset int64  `json:"next_offset"`
+	TotalSize  int64  `json:"total_size"`
+	EOF        bool   `json:"eof"`
+	Stored     bool   `json:"stored"` // 完整输出是否保存在日志文件中
+	Content    string `json:"content"`
+}
+
+// logDir 日志文件根目录
+func logDir() string {
+	return filepath.Join(database.DataDir, "logs")
+}
+
+// TruncateOutput 截断超过阈值的输出，截断位置对齐到UTF-8字符边界
+func TruncateOutput(output string) (string, bool) {
+	if len(output) <= OutputInlineLimit {
+		return output, false
+	}
+	cut := OutputInlineLimit
+	for cut > 0 && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	return output[:cut] + fmt.Sprintf("\n... [output truncated, %d bytes total]\n", len(output)), true
+}
+
+// SpillLogOutput 输出超过阈值时将完整内容写入压缩文件，数据库中保留截断内容
+func SpillLogOutput(logType string, logID int64, stream, output string) error {
+	if len(output) <= OutputInlineLimit {
+		return nil
+	}
+	if err := validateLogStream(logType, stream); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(logDir(), logType, time.Now().Format("2006-01"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %v", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d-%s.log.gz", logID, stream))
+	if err := writeGzipFile(path, output); err != nil {
+		return err
+	}
+
+	_, err := database.DB.Exec(`
+		INSERT OR REPLACE INTO log_files (log_type, log_id, stream, path, size, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, logType, logID, stream, path, len(output), time.Now())
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to save log file record: %v", err)
+	}
+	return nil
+}
+
+// writeGzipFile 将内容压缩写入文件
+func writeGzipFile(path, content string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create log file: %v", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	if _, err := io.WriteString(gz, content); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write log file: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write log file: %v", err)
+	}
+	return nil
+}
+
+// validateLogStream 校验日志类型和输出流
+func validateLogStream(logType, stream string) error {
+	source, ok := logSources[logType]
+	if !ok {
+		return &InvalidRequestError{Message: fmt.Sprintf("unsupported log type %q", logType)}
+	}
+	for _, s := range source.streams {
+		if s == stream {
+			return nil
+		}
+	}
+	return &InvalidRequestError{Message: fmt.Sprintf("unsupported stream %q for %s logs", stream, logType)}
+}
+
+// OpenLog 打开完整日志，优先读取日志文件，没有文件或文件已过期时读取数据库中的内容
+func OpenLog(logType string, logID int, stream string) (io.ReadCloser, int64, bool, error) {
+	if err := validateLogStream(logType, stream); err != nil {
+		return nil, 0, false, err
+	}
+
+	var path string
+	var size int64
+	err := database.DB.QueryRow(
+		"SELECT path, size FROM log_files WHERE log_type = ? AND log_id = ? AND stream = ?",
+		logType, logID, stream,
+	).Scan(&path, &size)
+	if err == nil {
+		file, err := os.Open(path)
+		if err == nil {
+			gz, err := gzip.NewReader(file)
+			if err != nil {
+				file.Close()
+				return nil, 0, false, fmt.Errorf("failed to read log file: %v", err)
+			}
+			return &gzipFileReader{Reader: gz, file: file}, size, true, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, 0, false, fmt.Errorf("failed to open log file: %v", err)
+		}
+	} else if err != sql.ErrNoRows {
+		return nil, 0, false, err
+	}
+
+	// 字段名来自logSources白名单
+	var content string
+	err = database.DB.QueryRow(
+		fmt.Sprintf("SELECT COALESCE(%s, '') FROM %s WHERE id = ?", stream, logSources[logType].table), logID,
+	).Scan(&content)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return io.NopCloser(strings.NewReader(content)), int64(len(content)), false, nil
+}
+
+// gzipFileReader 关闭时同时关闭底层文件
+type gzipFileReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *gzipFileReader) Close() error {
+	r.Reader.Close()
+	return r.file.Close()
+}
+
+// ReadLogChunk 从offset开始读取最多limit字节日志，结尾对齐到UTF-8字符边界
+func ReadLogChunk(logType string, logID int, stream string, offset, limit int64) (*LogChunk, error) {
+	if offset < 0 {
+		return nil, &InvalidRequestError{Message: "offset must not be negative"}
+	}
+	if limit <= 0 {
+		limit = LogChunkDefault
+	}
+	if limit > LogChunkMax {
+		limit = LogChunkMax
+	}
+
+	reader, size, stored, err := OpenLog(logType, logID, stream)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	chunk := &LogChunk{
+		LogType:   logType,
+		LogID:     logID,
+		Stream:    stream,
+		Offset:    offset,
+		TotalSize: size,
+		Stored:    stored,
+	}
+	if offset >= size {
+		chunk.NextOffset = size
+		chunk.EOF = true
+		return chunk, nil
+	}
+
+	if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+		return nil, fmt.Errorf("failed to seek log: %v", err)
+	}
+	buf := make([]byte, limit)
+	n, err := io.ReadFull(reader, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("failed to read log: %v", err)
+	}
+	buf = buf[:n]
+
+	// 未读到结尾时去掉被截断的多字节字符，下次从该字符开始读取
+	if offset+int64(n) < size {
+		for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(buf[i]) {
+				if !utf8.FullRune(buf[i:]) {
+					buf = buf[:i]
+				}
+				break
+			}
+		}
+	}
+
+	chunk.Content = string(buf)
+	chunk.NextOffset = offset + int64(len(buf))
+	chunk.EOF = chunk.NextOffset >= size
+	return chunk, nil
+}
+
+// CleanupLogFiles 删除超过保留天数的日志文件，数据库中的截断内容仍然保留
+func CleanupLogFiles(retentionDays int) (int, error) {
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	rows, err := database.DB.Query("SELECT id, path FROM log_files WHERE created_at < ?", cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	type logFile struct {
+		id   int
+		path string
+	}
+	var expired []logFile
+	for rows.Next() {
+		var f logFile
+		if err := rows.Scan(&f.id, &f.path); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, f)
+	}
+	rows.Close()
+
+	removed := 0
+	for _, f := range expired {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove log file %s: %v", f.path, err)
+			continue
+		}
+		if _, err := database.DB.Exec("DELETE FROM log_files WHERE id = ?", f.id); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// DeleteLogFiles 删除指定日志记录对应的日志文件，在删除日志记录前调用，返回删除的文件数和占用空间
+func DeleteLogFiles(logType, where string, args ...interface{}) (int, int64, error) {
+	source, ok := logSources[logType]
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported log type %q", logType)
+	}
+
+	query := fmt.Sprintf(
+		"SELECT id, path FROM log_files WHERE log_type = ? AND log_id IN (SELECT id FROM %s WHERE %s)",
+		source.table, where,
+	)
+	rows, err := database.DB.Query(query, append([]interface{}{logType}, args...)...)
+	if err != nil {
+		return 0, 0, err
+	}
+	var ids []int
+	var paths []string
+	for rows.Next() {
+		var id int
+		var path string
+		if err := rows.Scan(&id, &path); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		ids = append(ids, id)
+		paths = append(paths, path)
+	}
+	rows.Close()
+
+	var freed int64
+	for i, id := range ids {
+		if info, err := os.Stat(paths[i]); err == nil {
+			freed += info.Size()
+		}
+		if err := os.Remove(paths[i]); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove log file %s: %v", paths[i], err)
+		}
+		if _, err := database.DB.Exec("DELETE FROM log_files WHERE id = ?", id); err != nil {
+			return i, freed, err
+		}
+	}
+	return len(ids), freed, nil
+}
+
+// StartLogCleanup 启动后台任务，每小时清理一次过期日志文件
+func StartLogCleanup() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			removed, err := CleanupLogFiles(LogFileRetentionDays)
+			if err != nil {
+				log.Printf("Failed to clean up log files: %v", err)
+			} else if removed > 0 {
+				log.Printf("Removed %d expired log files", removed)
+			}
+			<-ticker.C
+		}
+	}()
+}
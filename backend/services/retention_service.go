@@ -0,0 +1,387 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"runme-backend/database"
+	"runme-backend/models"
+	"sync"
+	"time"
+)
+
+// LogTypeCertificate 证书日志，没有会话，每条日志按一个会话计算
+const LogTypeCertificate = "certificate"
+
+// RetentionJanitorInterval 后台清理任务的执行间隔
+const RetentionJanitorInterval = 6 * time.Hour
+
+// retentionLogTypes 支持保留策略的日志类型
+var retentionLogTypes = []string{LogTypeScript, LogTypeAnsible, LogTypeDeployment, LogTypeCertificate}
+
+// purgeMu 保证同一时间只有一个清理任务
+var purgeMu sync.Mutex
+
+// PurgeStats 单个日志类型的清理结果
+type PurgeStats struct {
+	LogType         string `json:"log_type"`
+	SessionsDeleted int    `json:"sessions_deleted"`
+	LogsDeleted     int64  `json:"logs_deleted"`
+}
+
+// PurgeReport 清理报告，空间单位为字节
+type PurgeReport struct {
+	Stats              []PurgeStats `json:"stats"`
+	LogFilesDeleted    int          `json:"log_files_deleted"`
+	LogFileBytes       int64        `json:"log_file_bytes"`
+	DatabaseSizeBefore int64        `json:"database_size_before"`
+	DatabaseSizeAfter  int64        `json:"database_size_after"`
+	BytesReclaimed     int64        `json:"bytes_reclaimed"`
+	Vacuumed           bool         `json:"vacuumed"`
+	StartedAt          time.Time    `json:"started_at"`
+	DurationMs         int64        `json:"duration_ms"`
+}
+
+// retentionEntry 待判断是否过期的会话（或证书日志）
+type retentionEntry struct {
+	id        int
+	owner     int
+	createdAt time.Time
+}
+
+// ListRetentionPolicies 获取所有日志类型的保留策略，未配置的类型返回不限制
+func ListRetentionPolicies() ([]models.RetentionPolicy, error) {
+	rows, err := database.DB.Query("SELECT log_type, max_age_days, max_sessions, updated_at FROM retention_policies")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	saved := make(map[string]models.RetentionPolicy)
+	for rows.Next() {
+		var p models.RetentionPolicy
+		if err := rows.Scan(&p.LogType, &p.MaxAgeDays, &p.MaxSessions, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		saved[p.LogType] = p
+	}
+
+	policies := make([]models.RetentionPolicy, 0, len(retentionLogTypes))
+	for _, logType := range retentionLogTypes {
+		p, ok := saved[logType]
+		if !ok {
+			p = models.RetentionPolicy{LogType: logType}
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// SaveRetentionPolicies 校验并保存保留策略
+func SaveRetentionPolicies(policies []models.RetentionPolicy) error {
+	for _, p := range policies {
+		if !isRetentionLogType(p.LogType) {
+			return &InvalidRequestError{Message: fmt.Sprintf("unsupported log type %q", p.LogType)}
+		}
+		if p.MaxAgeDays < 0 || p.MaxSessions < 0 {
+			return &InvalidRequestError{Message: fmt.Sprintf("%s: max_age_days and max_sessions must not be negative", p.LogType)}
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range policies {
+		_, err := tx.Exec(`
+			INSERT OR REPLACE INTO retention_policies (log_type, max_age_days, max_sessions, updated_at)
+			VALUES (?, ?, ?, ?)
+		`, p.LogType, p.MaxAgeDays, p.MaxSessions, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func isRetentionLogType(logType string) bool {
+	for _, t := range retentionLogTypes {
+		if t == logType {
+			return true
+		}
+	}
+	return false
+}
+
+// PurgeExecutionHistory 按保留策略清理过期会话及日志，vacuum为true时执行VACUUM回收数据库空间
+func PurgeExecutionHistory(vacuum bool) (*PurgeReport, error) {
+	if !purgeMu.TryLock() {
+		return nil, &InvalidRequestError{Message: "A purge is already running"}
+	}
+	defer purgeMu.Unlock()
+
+	report := &PurgeReport{StartedAt: time.Now()}
+	sizeBefore, err := databaseSize()
+	if err != nil {
+		return nil, err
+	}
+	report.DatabaseSizeBefore = sizeBefore
+
+	policies, err := ListRetentionPolicies()
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range policies {
+		if policy.MaxAgeDays == 0 && policy.MaxSessions == 0 {
+			continue
+		}
+		stats, err := purgeLogType(policy, report)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge %s history: %v", policy.LogType, err)
+		}
+		report.Stats = append(report.Stats, stats)
+	}
+
+	if vacuum {
+		if _, err := database.DB.Exec("VACUUM"); err != nil {
+			return nil, fmt.Errorf("failed to vacuum database: %v", err)
+		}
+		report.Vacuumed = true
+	}
+
+	report.DatabaseSizeAfter, err = databaseSize()
+	if err != nil {
+		return nil, err
+	}
+	report.BytesReclaimed = report.DatabaseSizeBefore - report.DatabaseSizeAfter + report.LogFileBytes
+	report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+	return report, nil
+}
+
+// databaseSize 数据库占用的空间（页数×页大小），未VACUUM时删除数据不会减少
+func databaseSize() (int64, error) {
+	var pageCount, pageSize int64
+	if err := database.DB.QueryRow("PRAGMA page_count").Scan(&pageCount); err != nil {
+		return 0, err
+	}
+	if err := database.DB.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pageCount * pageSize, nil
+}
+
+// purgeLogType 清理单个日志类型的历史记录
+func purgeLogType(policy models.RetentionPolicy, report *PurgeReport) (PurgeStats, error) {
+	stats := PurgeStats{LogType: policy.LogType}
+	switch policy.LogType {
+	case LogTypeScript:
+		return stats, purgeScriptHistory(policy, &stats, report)
+	case LogTypeAnsible:
+		return stats, purgeAnsibleHistory(policy, &stats, report)
+	case LogTypeDeployment:
+		return stats, purgeDeploymentHistory(policy, &stats, report)
+	case LogTypeCertificate:
+		return stats, purgeCertificateHistory(policy, &stats)
+	}
+	return stats, fmt.Errorf("unsupported log type %q", policy.LogType)
+}
+
+// selectExpired 按所属对象分组，从新到旧计算超出数量或超过天数的记录，并返回每个对象保留的最早时间
+func selectExpired(table, ownerColumn string, policy models.RetentionPolicy) ([]retentionEntry, map[int]time.Time, error) {
+	rows, err := database.DB.Query(fmt.Sprintf(
+		"SELECT id, %s, created_at FROM %s ORDER BY %s, created_at DESC, id DESC",
+		ownerColumn, table, ownerColumn,
+	))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	cutoff := time.Now().AddDate(0, 0, -policy.MaxAgeDays)
+	var expired []retentionEntry
+	oldestKept := make(map[int]time.Time)
+	counts := make(map[int]int)
+	for rows.Next() {
+		var e retentionEntry
+		if err := rows.Scan(&e.id, &e.owner, &e.createdAt); err != nil {
+			return nil, nil, err
+		}
+		counts[e.owner]++
+		tooMany := policy.MaxSessions > 0 && counts[e.owner] > policy.MaxSessions
+		tooOld := policy.MaxAgeDays > 0 && e.createdAt.Before(cutoff)
+		if tooMany || tooOld {
+			expired = append(expired, e)
+		} else {
+			oldestKept[e.owner] = e.createdAt
+		}
+	}
+	return expired, oldestKept, rows.Err()
+}
+
+// deleteLogs 删除日志记录及其日志文件
+func deleteLogs(logType, table, where string, report *PurgeReport, args ...interface{}) (int64, error) {
+	files, bytes, err := DeleteLogFiles(logType, where, args...)
+	report.LogFilesDeleted += files
+	report.LogFileBytes += bytes
+	if err != nil {
+		return 0, err
+	}
+
+	if logType == LogTypeAnsible {
+		_, err := database.DB.Exec(fmt.Sprintf(
+			"DELETE FROM ansible_task_results WHERE log_id IN (SELECT id FROM %s WHERE %s)", table, where,
+		), args...)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := database.DB.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", table, where), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// purgeScriptHistory 清理脚本执行会话，旧日志（session_id为0）按时间归属到会话
+func purgeScriptHistory(policy models.RetentionPolicy, stats *PurgeStats, report *PurgeReport) error {
+	expired, oldestKept, err := selectExpired("execution_sessions", "script_id", policy)
+	if err != nil {
+		return err
+	}
+
+	owners := make(map[int]bool)
+	for _, s := range expired {
+		n, err := deleteLogs(LogTypeScript, "execution_logs", "session_id = ?", report, s.id)
+		if err != nil {
+			return err
+		}
+		stats.LogsDeleted += n
+		if _, err := database.DB.Exec("DELETE FROM execution_sessions WHERE id = ?", s.id); err != nil {
+			return err
+		}
+		stats.SessionsDeleted++
+		owners[s.owner] = true
+	}
+
+	for scriptID := range owners {
+		where, args := "script_id = ? AND session_id = 0", []interface{}{scriptID}
+		if kept, ok := oldestKept[scriptID]; ok {
+			where += " AND executed_at < ?"
+			args = append(args, kept)
+		}
+		n, err := deleteLogs(LogTypeScript, "execution_logs", where, report, args...)
+		if err != nil {
+			return err
+		}
+		stats.LogsDeleted += n
+	}
+	return nil
+}
+
+// purgeAnsibleHistory 清理Playbook执行会话，旧日志（session_id为0）按时间归属到会话
+func purgeAnsibleHistory(policy models.RetentionPolicy, stats *PurgeStats, report *PurgeReport) error {
+	expired, oldestKept, err := selectExpired("ansible_execution_sessions", "playbook_id", policy)
+	if err != nil {
+		return err
+	}
+
+	owners := make(map[int]bool)
+	for _, s := range expired {
+		n, err := deleteLogs(LogTypeAnsible, "ansible_execution_logs", "session_id = ?", report, s.id)
+		if err != nil {
+			return err
+		}
+		stats.LogsDeleted += n
+		files, bytes, err := DeleteLogFiles(LogTypeAnsibleSession, "id = ?", s.id)
+		report.LogFilesDeleted += files
+		report.LogFileBytes += bytes
+		if err != nil {
+			return err
+		}
+		if _, err := database.DB.Exec("DELETE FROM ansible_execution_sessions WHERE id = ?", s.id); err != nil {
+			return err
+		}
+		stats.SessionsDeleted++
+		owners[s.owner] = true
+	}
+
+	for playbookID := range owners {
+		where, args := "playbook_id = ? AND session_id = 0", []interface{}{playbookID}
+		if kept, ok := oldestKept[playbookID]; ok {
+			where += " AND executed_at < ?"
+			args = append(args, kept)
+		}
+		n, err := deleteLogs(LogTypeAnsible, "ansible_execution_logs", where, report, args...)
+		if err != nil {
+			return err
+		}
+		stats.LogsDeleted += n
+	}
+	return nil
+}
+
+// purgeDeploymentHistory 清理部署会话及对应日志
+func purgeDeploymentHistory(policy models.RetentionPolicy, stats *PurgeStats, report *PurgeReport) error {
+	expired, _, err := selectExpired("deployment_sessions", "task_id", policy)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range expired {
+		var sessionName string
+		err := database.DB.QueryRow("SELECT session_name FROM deployment_sessions WHERE id = ?", s.id).Scan(&sessionName)
+		if err != nil {
+			return err
+		}
+		n, err := deleteLogs(LogTypeDeployment, "deployment_logs", "task_id = ? AND session_name = ?", report, s.owner, sessionName)
+		if err != nil {
+			return err
+		}
+		stats.LogsDeleted += n
+		if _, err := database.DB.Exec("DELETE FROM deployment_sessions WHERE id = ?", s.id); err != nil {
+			return err
+		}
+		stats.SessionsDeleted++
+	}
+	return nil
+}
+
+// purgeCertificateHistory 清理证书日志，每个证书按条数和天数保留
+func purgeCertificateHistory(policy models.RetentionPolicy, stats *PurgeStats) error {
+	expired, _, err := selectExpired("certificate_logs", "certificate_id", policy)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range expired {
+		if _, err := database.DB.Exec("DELETE FROM certificate_logs WHERE id = ?", e.id); err != nil {
+			return err
+		}
+		stats.LogsDeleted++
+	}
+	return nil
+}
+
+// StartRetentionJanitor 启动后台任务，定期按保留策略清理执行历史（不执行VACUUM）
+func StartRetentionJanitor() {
+	go func() {
+		ticker := time.NewTicker(RetentionJanitorInterval)
+		defer ticker.Stop()
+		for {
+			report, err := PurgeExecutionHistory(false)
+			if err != nil {
+				log.Printf("Retention janitor failed: %v", err)
+			} else {
+				for _, s := range report.Stats {
+					if s.SessionsDeleted > 0 || s.LogsDeleted > 0 {
+						log.Printf("Retention janitor purged %d %s sessions and %d logs",
+							s.SessionsDeleted, s.LogType, s.LogsDeleted)
+					}
+				}
+			}
+			<-ticker.C
+		}
+	}()
+}
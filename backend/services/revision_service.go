@@ -0,0 +1,392 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"runme-backend/database"
+	"runme-backend/models"
+	"strings"
+	"time"
+)
+
+// 支持版本管理的资源类型
+const (
+	RevisionTypeScript         = "script"
+	RevisionTypeAnsible        = "ansible_playbook"
+	RevisionTypeDockerTemplate = "docker_template"
+)
+
+// diff输出中保留的上下文行数
+const diffContextLines = 3
+
+// CreateScriptRevision 为脚本创建新版本
+// secret参数的默认值不写入版本
+func CreateScriptRevision(script models.Script, author, message string) (*models.Revision, error) {
+	script.Parameters = MaskParameterDefaults(script.Parameters)
+	return createRevision(RevisionTypeScript, script.ID, scriptRevisionContent(script), script, author, message)
+}
+
+// CreatePlaybookRevision 为Ansible Playbook创建新版本
+func CreatePlaybookRevision(playbook models.AnsiblePlaybook, author, message string) (*models.Revision, error) {
+	return createRevision(RevisionTypeAnsible, playbook.ID, playbookRevisionContent(playbook), playbook, author, message)
+}
+
+// CreateDockerTemplateRevision 为Docker模板创建新版本
+func CreateDockerTemplateRevision(template models.DockerTemplate, author, message string) (*models.Revision, error) {
+	return createRevision(RevisionTypeDockerTemplate, template.ID, template.DockerCommand, template, author, message)
+}
+
+// EnsureScriptRevision 返回脚本当前版本号，旧数据没有版本时自动创建初始版本
+func EnsureScriptRevision(script models.Script) (int, error) {
+	return ensureRevision(RevisionTypeScript, script.ID, func() (*models.Revision, error) {
+		return CreateScriptRevision(script, "", "Initial revision")
+	})
+}
+
+// EnsurePlaybookRevision 返回Playbook当前版本号，旧数据没有版本时自动创建初始版本
+func EnsurePlaybookRevision(playbook models.AnsiblePlaybook) (int, error) {
+	return ensureRevision(RevisionTypeAnsible, playbook.ID, func() (*models.Revision, error) {
+		return CreatePlaybookRevision(playbook, "", "Initial revision")
+	})
+}
+
+// EnsureDockerTemplateRevision 返回Docker模板当前版本号，旧数据没有版本时自动创建初始版本
+func EnsureDockerTemplateRevision(template models.DockerTemplate) (int, error) {
+	return ensureRevision(RevisionTypeDockerTemplate, template.ID, func() (*models.Revision, error) {
+		return CreateDockerTemplateRevision(template, "", "Initial revision")
+	})
+}
+
+// ListRevisions 获取资源的所有版本（按版本号倒序）
+func ListRevisions(resourceType string, resourceID int) ([]models.Revision, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, resource_type, resource_id, revision, content, snapshot, author, message, created_at
+		FROM revisions WHERE resource_type = ? AND resource_id = ?
+		ORDER BY revision DESC
+	`, resourceType, resourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []models.Revision{}
+	for rows.Next() {
+		var r models.Revision
+		if err := rows.Scan(&r.ID, &r.ResourceType, &r.ResourceID, &r.Revision, &r.Content,
+			&r.Snapshot, &r.Author, &r.Message, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// GetRevision 获取资源的指定版本，不存在时返回sql.ErrNoRows
+func GetRevision(resourceType string, resourceID, revision int) (*models.Revision, error) {
+	var r models.Revision
+	err := database.DB.QueryRow(`
+		SELECT id, resource_type, resource_id, revision, content, snapshot, author, message, created_at
+		FROM revisions WHERE resource_type = ? AND resource_id = ? AND revision = ?
+	`, resourceType, resourceID, revision).Scan(&r.ID, &r.ResourceType, &r.ResourceID, &r.Revision,
+		&r.Content, &r.Snapshot, &r.Author, &r.Message, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// RestoreRevision 将资源恢复到指定版本，并记录一个新的版本
+func RestoreRevision(resourceType string, resourceID, revision int, author string) (*models.Revision, error) {
+	old, err := GetRevision(resourceType, resourceID, revision)
+	if err != nil {
+		return nil, err
+	}
+	message := fmt.Sprintf("Restore revision %d", revision)
+	now := time.Now()
+
+	switch resourceType {
+	case RevisionTypeScript:
+		var script models.Script
+		if err := json.Unmarshal([]byte(old.Snapshot), &script); err != nil {
+			return nil, fmt.Errorf("invalid revision snapshot: %v", err)
+		}
+		// 版本中secret参数的默认值已脱敏，沿用当前保存的默认值
+		saved, err := LoadScript(resourceID)
+		if err != nil {
+			return nil, err
+		}
+		KeepSecretDefaults(script.Parameters, saved.Parameters)
+		parameters, err := EncodeScriptParameters(script.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := database.DB.Exec(
+			"UPDATE scripts SET name=?, content=?, host_group_id=?, parameters=?, updated_at=? WHERE id=?",
+			script.Name, script.Content, script.HostGroupID, parameters, now, resourceID,
+		); err != nil {
+			return nil, err
+		}
+		// 执行身份不属于版本内容，以当前设置记录新版本
+		current, err := LoadScript(resourceID)
+		if err != nil {
+			return nil, err
+		}
+		return CreateScriptRevision(*current, author, message)
+	case RevisionTypeAnsible:
+		var playbook models.AnsiblePlaybook
+		if err := json.Unmarshal([]byte(old.Snapshot), &playbook); err != nil {
+			return nil, fmt.Errorf("invalid revision snapshot: %v", err)
+		}
+		// 与更新Playbook相同的校验：引用的凭据、主机组或项目文件可能已在该版本之后变更
+		playbook.ID = resourceID
+		if playbook.EntryPoint, err = CleanPlaybookEntryPoint(playbook.EntryPoint); err != nil {
+			return nil, err
+		}
+		if err := ValidateCredentialReference(playbook.VaultCredentialID, CredentialTypeVaultPassword); err != nil {
+			return nil, err
+		}
+		if err := ValidateHostGroupIDs(playbook.HostGroupIDs); err != nil {
+			return nil, err
+		}
+		if err := ValidateAnsiblePlaybook(playbook); err != nil {
+			return nil, err
+		}
+		if _, err := database.DB.Exec(
+			"UPDATE ansible_playbooks SET name = ?, content = ?, variables = ?, host_group_id = ?, host_group_ids = ?, entry_point = ?, vault_credential_id = ?, updated_at = ? WHERE id = ?",
+			playbook.Name, playbook.Content, playbook.Variables, playbook.HostGroupID, EncodeHostGroupIDs(playbook.HostGroupIDs),
+			playbook.EntryPoint, playbook.VaultCredentialID, now, resourceID,
+		); err != nil {
+			return nil, err
+		}
+		return CreatePlaybookRevision(playbook, author, message)
+	case RevisionTypeDockerTemplate:
+		var template models.DockerTemplate
+		if err := json.Unmarshal([]byte(old.Snapshot), &template); err != nil {
+			return nil, fmt.Errorf("invalid revision snapshot: %v", err)
+		}
+		if _, err := database.DB.Exec(
+			"UPDATE docker_templates SET name = ?, docker_command = ?, updated_at = ? WHERE id = ?",
+			template.Name, template.DockerCommand, now, resourceID,
+		); err != nil {
+			return nil, err
+		}
+		current, err := LoadDockerTemplate(resourceID)
+		if err != nil {
+			return nil, err
+		}
+		return CreateDockerTemplateRevision(*current, author, message)
+	default:
+		return nil, fmt.Errorf("unsupported resource type %q", resourceType)
+	}
+}
+
+// DeleteRevisions 删除资源的所有版本
+func DeleteRevisions(resourceType string, resourceID int) error {
+	_, err := database.DB.Exec("DELETE FROM revisions WHERE resource_type = ? AND resource_id = ?", resourceType, resourceID)
+	return err
+}
+
+// DiffText 生成两段文本的unified diff
+func DiffText(from, to, fromLabel, toLabel string) string {
+	a := splitLines(from)
+	b := splitLines(to)
+
+	// 基于最长公共子序列计算逐行编辑操作
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte // ' ', '-', '+'
+		line string
+		ai   int // 在a中的行号（从0开始）
+		bi   int // 在b中的行号（从0开始）
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("--- %s\n+++ %s\n", fromLabel, toLabel))
+
+	// 将变更按上下文行数合并为hunk
+	for start := 0; start < len(edits); {
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start >= len(edits) {
+			break
+		}
+		hunkStart := start - diffContextLines
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		end := start
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].op == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 2*diffContextLines {
+				break
+			}
+			end = next
+		}
+		hunkEnd := end + diffContextLines
+		if hunkEnd > len(edits) {
+			hunkEnd = len(edits)
+		}
+
+		var fromCount, toCount int
+		for _, e := range edits[hunkStart:hunkEnd] {
+			if e.op != '+' {
+				fromCount++
+			}
+			if e.op != '-' {
+				toCount++
+			}
+		}
+		out.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n",
+			edits[hunkStart].ai+1, fromCount, edits[hunkStart].bi+1, toCount))
+		for _, e := range edits[hunkStart:hunkEnd] {
+			out.WriteByte(e.op)
+			out.WriteString(e.line)
+			out.WriteByte('\n')
+		}
+		start = hunkEnd
+	}
+
+	return out.String()
+}
+
+// createRevision 写入一个新版本，版本号在资源内递增
+func createRevision(resourceType string, resourceID int, content string, snapshot interface{}, author, message string) (*models.Revision, error) {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var latest int
+	err = tx.QueryRow(
+		"SELECT COALESCE(MAX(revision), 0) FROM revisions WHERE resource_type = ? AND resource_id = ?",
+		resourceType, resourceID,
+	).Scan(&latest)
+	if err != nil {
+		return nil, err
+	}
+
+	r := models.Revision{
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Revision:     latest + 1,
+		Content:      content,
+		Snapshot:     string(data),
+		Author:       author,
+		Message:      message,
+		CreatedAt:    time.Now(),
+	}
+	result, err := tx.Exec(`
+		INSERT INTO revisions (resource_type, resource_id, revision, content, snapshot, author, message, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.ResourceType, r.ResourceID, r.Revision, r.Content, r.Snapshot, r.Author, r.Message, r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	r.ID = int(id)
+	return &r, nil
+}
+
+// ensureRevision 获取最新版本号，不存在时调用create创建
+func ensureRevision(resourceType string, resourceID int, create func() (*models.Revision, error)) (int, error) {
+	latest, err := LatestRevision(resourceType, resourceID)
+	if err != nil || latest > 0 {
+		return latest, err
+	}
+
+	r, err := create()
+	if err != nil {
+		return 0, err
+	}
+	return r.Revision, nil
+}
+
+// LatestRevision 返回资源的最新版本号，只读查询，没有版本时返回0
+func LatestRevision(resourceType string, resourceID int) (int, error) {
+	var latest int
+	err := database.DB.QueryRow(
+		"SELECT revision FROM revisions WHERE resource_type = ? AND resource_id = ? ORDER BY revision DESC LIMIT 1",
+		resourceType, resourceID,
+	).Scan(&latest)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return latest, err
+}
+
+// scriptRevisionContent 生成脚本用于对比的文本
+func scriptRevisionContent(script models.Script) string {
+	content := script.Content
+	if len(script.Parameters) > 0 {
+		params, _ := json.MarshalIndent(script.Parameters, "", "  ")
+		content += "\n\n# --- parameters ---\n" + string(params)
+	}
+	return content
+}
+
+// playbookRevisionContent 生成Playbook用于对比的文本
+func playbookRevisionContent(playbook models.AnsiblePlaybook) string {
+	content := playbook.Content
+	if playbook.Variables != "" {
+		content += "\n\n# --- variables ---\n" + playbook.Variables
+	}
+	return content
+}
+
+// splitLines 按行拆分文本，忽略末尾换行
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
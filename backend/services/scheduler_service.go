@@ -0,0 +1,196 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"runme-backend/database"
+	"runme-backend/models"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// 标准5段cron表达式，同时支持 @daily、@every 1h 等描述符
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// scheduler 调度器状态，entries记录已注册的cron条目，running用于避免同一计划重叠执行
+var scheduler = struct {
+	mu      sync.Mutex
+	cron    *cron.Cron
+	entries map[int]cron.EntryID
+	running map[int]bool
+}{
+	entries: make(map[int]cron.EntryID),
+	running: make(map[int]bool),
+}
+
+// StartScheduler 从数据库加载所有启用的计划并启动调度器，服务重启后据此重新计算执行时间
+func StartScheduler() error {
+	scheduler.mu.Lock()
+	scheduler.cron = cron.New(cron.WithParser(cronParser))
+	scheduler.mu.Unlock()
+
+	rows, err := database.DB.Query("SELECT id FROM schedules WHERE enabled = 1")
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := ReloadSchedule(id); err != nil {
+			log.Printf("Failed to load schedule %d: %v", id, err)
+		}
+	}
+
+	scheduler.cron.Start()
+	log.Printf("Scheduler started with %d schedules", len(ids))
+	return nil
+}
+
+// ValidateCronExpr 校验cron表达式
+func ValidateCronExpr(expr string) error {
+	if _, err := cronParser.Parse(expr); err != nil {
+		return fmt.Errorf("invalid cron expression %q: %v", expr, err)
+	}
+	return nil
+}
+
+// LoadSchedule 根据ID获取计划
+func LoadSchedule(id int) (*models.Schedule, error) {
+	var s models.Schedule
+	err := database.DB.QueryRow(`
+		SELECT id, name, target_type, target_id, cron_expr, payload, enabled, last_run_at, next_run_at,
+		       last_status, last_message, created_at, updated_at
+		FROM schedules WHERE id = ?
+	`, id).Scan(&s.ID, &s.Name, &s.TargetType, &s.TargetID, &s.CronExpr, &s.Payload, &s.Enabled,
+		&s.LastRunAt, &s.NextRunAt, &s.LastStatus, &s.LastMessage, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ReloadSchedule 重新注册计划（创建、更新、启用或停用后调用）
+func ReloadSchedule(id int) error {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	if entryID, ok := scheduler.entries[id]; ok {
+		scheduler.cron.Remove(entryID)
+		delete(scheduler.entries, id)
+	}
+
+	s, err := LoadSchedule(id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !s.Enabled {
+		_, err = database.DB.Exec("UPDATE schedules SET next_run_at = NULL WHERE id = ?", id)
+		return err
+	}
+
+	sched, err := cronParser.Parse(s.CronExpr)
+	if err != nil {
+		return err
+	}
+	scheduler.entries[id] = scheduler.cron.Schedule(sched, cron.FuncJob(func() {
+		runSchedule(id)
+	}))
+
+	_, err = database.DB.Exec("UPDATE schedules SET next_run_at = ? WHERE id = ?", sched.Next(time.Now()), id)
+	return err
+}
+
+// RemoveSchedule 从调度器中移除计划
+func RemoveSchedule(id int) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	if entryID, ok := scheduler.entries[id]; ok {
+		scheduler.cron.Remove(entryID)
+		delete(scheduler.entries, id)
+	}
+}
+
+// TriggerSchedule 立即在后台执行一次计划
+func TriggerSchedule(id int) error {
+	scheduler.mu.Lock()
+	running := scheduler.running[id]
+	scheduler.mu.Unlock()
+	if running {
+		return &InvalidRequestError{Message: "Schedule is already running"}
+	}
+
+	go runSchedule(id)
+	return nil
+}
+
+// runSchedule 执行一次计划，上一次执行尚未结束时跳过
+// 跳过只记录日志，last_status仍反映正在进行的执行，不能被覆盖
+func runSchedule(id int) {
+	scheduler.mu.Lock()
+	if scheduler.running[id] {
+		scheduler.mu.Unlock()
+		log.Printf("Schedule %d skipped: previous run still in progress", id)
+		return
+	}
+	scheduler.running[id] = true
+	scheduler.mu.Unlock()
+
+	defer func() {
+		scheduler.mu.Lock()
+		delete(scheduler.running, id)
+		scheduler.mu.Unlock()
+	}()
+
+	s, err := LoadSchedule(id)
+	if err != nil {
+		log.Printf("Failed to load schedule %d: %v", id, err)
+		return
+	}
+
+	// 记录本次执行时间并计算下一次执行时间
+	now := time.Now()
+	var next *time.Time
+	if sched, err := cronParser.Parse(s.CronExpr); err == nil && s.Enabled {
+		t := sched.Next(now)
+		next = &t
+	}
+	_, err = database.DB.Exec(
+		"UPDATE schedules SET last_run_at = ?, next_run_at = ?, last_status = 'running', last_message = '' WHERE id = ?",
+		now, next, id,
+	)
+	if err != nil {
+		log.Printf("Failed to update schedule %d: %v", id, err)
+	}
+
+	log.Printf("Running schedule %d (%s %d)", s.ID, s.TargetType, s.TargetID)
+	status, message := RunTarget(s.TargetType, s.TargetID, s.Payload)
+	updateScheduleResult(id, status, message)
+}
+
+// updateScheduleResult 更新计划最近一次执行结果
+func updateScheduleResult(id int, status, message string) {
+	_, err := database.DB.Exec(
+		"UPDATE schedules SET last_status = ?, last_message = ? WHERE id = ?",
+		status, message, id,
+	)
+	if err != nil {
+		log.Printf("Failed to update schedule %d result: %v", id, err)
+	}
+}
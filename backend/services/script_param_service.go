@@ -0,0 +1,239 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"runme-backend/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 参数名需要同时作为环境变量名使用
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 模板占位符，例如 {{ version }}
+var templatePlaceholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// SecretMask secret类型参数在日志和会话中的显示值
+const SecretMask = "******"
+
+// ParseScriptParameters 解析数据库中以JSON存储的参数定义
+func ParseScriptParameters(raw string) ([]models.ScriptParameter, error) {
+	params := []models.ScriptParameter{}
+	if strings.TrimSpace(raw) == "" {
+		return params, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &params); err != nil {
+		return nil, fmt.Errorf("invalid parameter definitions: %v", err)
+	}
+	return params, nil
+}
+
+// EncodeScriptParameters 将参数定义编码为JSON以便存储
+func EncodeScriptParameters(params []models.ScriptParameter) (string, error) {
+	if params == nil {
+		params = []models.ScriptParameter{}
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ValidateParameterDefinitions 校验脚本参数定义是否合法
+func ValidateParameterDefinitions(params []models.ScriptParameter) error {
+	seen := make(map[string]bool)
+	for _, p := range params {
+		if !paramNamePattern.MatchString(p.Name) {
+			return fmt.Errorf("invalid parameter name %q: must match %s", p.Name, paramNamePattern.String())
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate parameter name %q", p.Name)
+		}
+		seen[p.Name] = true
+
+		switch p.Type {
+		case "string", "secret", "int", "bool":
+		case "enum":
+			if len(p.Options) == 0 {
+				return fmt.Errorf("parameter %q: enum type requires options", p.Name)
+			}
+		default:
+			return fmt.Errorf("parameter %q: unsupported type %q", p.Name, p.Type)
+		}
+
+		if p.Default != "" {
+			if _, err := normalizeParameterValue(p, p.Default); err != nil {
+				return fmt.Errorf("parameter %q: invalid default: %v", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveParameterValues 根据参数定义校验请求中的参数值，并补充默认值
+func ResolveParameterValues(params []models.ScriptParameter, values map[string]interface{}) (map[string]string, error) {
+	known := make(map[string]bool)
+	for _, p := range params {
+		known[p.Name] = true
+	}
+	for name := range values {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+
+	resolved := make(map[string]string)
+	for _, p := range params {
+		raw, ok := values[p.Name]
+		if !ok || raw == nil {
+			if p.Default == "" && p.Required {
+				return nil, fmt.Errorf("parameter %q is required", p.Name)
+			}
+			if p.Default == "" && p.Type != "bool" {
+				resolved[p.Name] = ""
+				continue
+			}
+			raw = p.Default
+		}
+
+		value, err := normalizeParameterValue(p, stringifyParameterValue(raw))
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %v", p.Name, err)
+		}
+		if value == "" && p.Required {
+			return nil, fmt.Errorf("parameter %q is required", p.Name)
+		}
+		resolved[p.Name] = value
+	}
+	return resolved, nil
+}
+
+// MaskParameterDefaults 返回secret参数默认值已脱敏的参数定义副本，用于接口响应和版本记录
+func MaskParameterDefaults(params []models.ScriptParameter) []models.ScriptParameter {
+	masked := make([]models.ScriptParameter, len(params))
+	copy(masked, params)
+	for i := range masked {
+		if masked[i].Type == "secret" && masked[i].Default != "" {
+			masked[i].Default = SecretMask
+		}
+	}
+	return masked
+}
+
+// KeepSecretDefaults 提交的secret参数默认值为脱敏值时沿用saved中同名secret参数的默认值，没有时清空
+func KeepSecretDefaults(params, saved []models.ScriptParameter) {
+	defaults := make(map[string]string)
+	for _, p := range saved {
+		if p.Type == "secret" {
+			defaults[p.Name] = p.Default
+		}
+	}
+	for i := range params {
+		if params[i].Type == "secret" && params[i].Default == SecretMask {
+			params[i].Default = defaults[params[i].Name]
+		}
+	}
+}
+
+// MaskParameterValues 返回secret参数已脱敏的参数值副本
+func MaskParameterValues(params []models.ScriptParameter, values map[string]string) map[string]string {
+	masked := make(map[string]string, len(values))
+	for name, value := range values {
+		masked[name] = value
+	}
+	for _, p := range params {
+		if p.Type == "secret" && masked[p.Name] != "" {
+			masked[p.Name] = SecretMask
+		}
+	}
+	return masked
+}
+
+// RenderScript 将参数以环境变量的形式导出，并将模板占位符替换为对应的变量引用"${name}"
+// 参数值只出现在单引号转义的export中，占位符位于双引号字符串内时也能得到原值
+func RenderScript(content string, values map[string]string) string {
+	if len(values) == 0 {
+		return content
+	}
+
+	rendered := templatePlaceholderPattern.ReplaceAllStringFunc(content, func(match string) string {
+		name := templatePlaceholderPattern.FindStringSubmatch(match)[1]
+		if _, ok := values[name]; ok {
+			return `"${` + name + `}"`
+		}
+		return match
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var exports strings.Builder
+	for _, name := range names {
+		exports.WriteString(fmt.Sprintf("export %s=%s\n", name, ShellQuote(values[name])))
+	}
+
+	// 保留shebang在第一行
+	if strings.HasPrefix(rendered, "#!") {
+		if idx := strings.Index(rendered, "\n"); idx >= 0 {
+			return rendered[:idx+1] + exports.String() + rendered[idx+1:]
+		}
+		return rendered + "\n" + exports.String()
+	}
+	return exports.String() + rendered
+}
+
+// ShellQuote 使用单引号转义，确保值在shell中按字面量处理
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'"'"'`) + "'"
+}
+
+// normalizeParameterValue 按参数类型校验并规范化参数值
+func normalizeParameterValue(p models.ScriptParameter, value string) (string, error) {
+	switch p.Type {
+	case "int":
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid integer", value)
+		}
+		return strconv.Itoa(n), nil
+	case "bool":
+		if strings.TrimSpace(value) == "" {
+			return "false", nil
+		}
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("%q is not a valid boolean", value)
+		}
+		return strconv.FormatBool(b), nil
+	case "enum":
+		for _, option := range p.Options {
+			if option == value {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("%q is not one of %s", value, strings.Join(p.Options, ", "))
+	default:
+		return value, nil
+	}
+}
+
+// stringifyParameterValue 将JSON解码得到的值转换为字符串
+func stringifyParameterValue(raw interface{}) string {
+	switch v := raw.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
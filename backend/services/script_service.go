@@ -0,0 +1,241 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"runme-backend/database"
+	"runme-backend/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InvalidRequestError 表示执行请求本身不合法（参数、目标选择等）
+type InvalidRequestError struct {
+	Message string
+}
+
+func (e *InvalidRequestError) Error() string {
+	return e.Message
+}
+
+// ScriptRunRequest 脚本执行请求，包含参数值和目标主机选择条件
+type ScriptRunRequest struct {
+	TargetSelector
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// ScriptRun 已完成校验、等待执行的脚本任务
+type ScriptRun struct {
+	Script   models.Script
+	Values   map[string]string // 参数值（未脱敏，仅用于渲染脚本）
+	Revision int
+	Hosts    []models.Host
+}
+
+// ScriptRunResult 脚本执行结果
+type ScriptRunResult struct {
+	SessionID   int64             `json:"session_id"`
+	SessionName string            `json:"session_name"`
+	Revision    int               `json:"revision"`
+	Results     []ExecutionResult `json:"results"`
+}
+
+// LoadScript 根据ID获取脚本
+func LoadScript(id int) (*models.Script, error) {
+	var script models.Script
+	var parameters string
+	err := database.DB.QueryRow(`SELECT id, name, content, host_group_id, parameters, run_as, success_exit_codes, warning_exit_codes
+		FROM scripts WHERE id = ?`, id).Scan(
+		&script.ID, &script.Name, &script.Content, &script.HostGroupID, &parameters, &script.RunAs,
+		&script.SuccessExitCodes, &script.WarningExitCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	script.Parameters, err = ParseScriptParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return &script, nil
+}
+
+// PrepareScriptRun 校验参数、解析目标主机并查询当前的脚本版本，不写入数据库，可用于dry run
+// 请求不合法时返回 *InvalidRequestError
+func PrepareScriptRun(script models.Script, req ScriptRunRequest) (*ScriptRun, error) {
+	values, err := ResolveParameterValues(script.Parameters, req.Parameters)
+	if err != nil {
+		return nil, &InvalidRequestError{Message: err.Error()}
+	}
+
+	revision, err := LatestRevision(RevisionTypeScript, script.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve script revision: %v", err)
+	}
+
+	// 解析目标主机，未指定时使用脚本所属主机组
+	if err := ValidateSelector(req.Selector); err != nil {
+		return nil, &InvalidRequestError{Message: err.Error()}
+	}
+	hosts, err := ResolveTargets(req.TargetSelector, script.HostGroupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve target hosts: %v", err)
+	}
+
+	return &ScriptRun{
+		Script:   script,
+		Values:   values,
+		Revision: revision,
+		Hosts:    hosts,
+	}, nil
+}
+
+// Execute 创建执行会话，在所有目标主机上执行脚本并保存日志，旧数据没有版本时先创建初始版本
+func (r *ScriptRun) Execute() (*ScriptRunResult, error) {
+	if len(r.Hosts) == 0 {
+		return nil, &InvalidRequestError{Message: "No valid hosts found"}
+	}
+
+	revision, err := EnsureScriptRevision(r.Script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve script revision: %v", err)
+	}
+	r.Revision = revision
+
+	maskedValues, _ := json.Marshal(MaskParameterValues(r.Script.Parameters, r.Values))
+
+	// 创建执行会话
+	sessionName := fmt.Sprintf("%s_%s", r.Script.Name, time.Now().Format("2006-01-02_15:04:05"))
+	sessionResult, err := database.DB.Exec(
+		"INSERT INTO execution_sessions (script_id, session_name, parameters, revision, created_at) VALUES (?, ?, ?, ?, ?)",
+		r.Script.ID, sessionName, string(maskedValues), r.Revision, time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create execution session: %v", err)
+	}
+	sessionID, _ := sessionResult.LastInsertId()
+
+	var results []ExecutionResult
+	for _, host := range r.Hosts {
+		// 主机变量在前，显式传入的脚本参数优先
+		hostVars, err := BuildHostVariables(host)
+		if err != nil {
+			results = append(results, ExecutionResult{
+				Host:     host.IP,
+				Status:   "failed",
+				ExitCode: -1,
+				Error:    fmt.Sprintf("Failed to load host variables: %v", err),
+			})
+			continue
+		}
+		renderedScript := RenderScript(r.Script.Content, MergeVariables(hostVars, r.Values))
+		result := ExecuteScriptOnHostAs(host, r.Script.RunAs, renderedScript)
+		ApplySuccessCriteria(r.Script, &result)
+		results = append(results, result)
+	}
+
+	// 保存执行日志，超过阈值的输出写入日志文件，数据库和返回结果中只保留截断内容
+	for i := range results {
+		result := &results[i]
+		full := map[string]string{"output": result.Output, "stdout": result.Stdout, "stderr": result.Stderr}
+		var outputCut, stdoutCut, stderrCut bool
+		result.Output, outputCut = TruncateOutput(result.Output)
+		result.Stdout, stdoutCut = TruncateOutput(result.Stdout)
+		result.Stderr, stderrCut = TruncateOutput(result.Stderr)
+		result.Truncated = outputCut || stdoutCut || stderrCut
+
+		var startedAt, finishedAt *time.Time
+		if !result.StartedAt.IsZero() {
+			startedAt, finishedAt = &result.StartedAt, &result.FinishedAt
+		}
+		res, err := database.DB.Exec(`
+			INSERT INTO execution_logs (script_id, session_id, host, status, output, stdout, stderr, exit_code, error,
+			                            started_at, finished_at, duration_ms, executed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, r.Script.ID, sessionID, result.Host, result.Status, result.Output, result.Stdout, result.Stderr,
+			result.ExitCode, result.Error, startedAt, finishedAt, result.DurationMs, time.Now(),
+		)
+		if err != nil {
+			log.Printf("Failed to save execution log for host %s: %v", result.Host, err)
+			continue
+		}
+		result.LogID, _ = res.LastInsertId()
+		for stream, output := range full {
+			if err := SpillLogOutput(LogTypeScript, result.LogID, stream, output); err != nil {
+				log.Printf("Failed to store %s for host %s: %v", stream, result.Host, err)
+			}
+		}
+	}
+
+	return &ScriptRunResult{
+		SessionID:   sessionID,
+		SessionName: sessionName,
+		Revision:    r.Revision,
+		Results:     results,
+	}, nil
+}
+
+// ExecuteScriptByID 加载脚本并同步执行，供定时任务和工作流调用
+func ExecuteScriptByID(id int, req ScriptRunRequest) (*ScriptRunResult, error) {
+	script, err := LoadScript(id)
+	if err != nil {
+		return nil, fmt.Errorf("script %d not found: %v", id, err)
+	}
+
+	run, err := PrepareScriptRun(*script, req)
+	if err != nil {
+		return nil, err
+	}
+	return run.Execute()
+}
+
+// ValidateExitCodes 校验逗号分隔的退出码列表
+func ValidateExitCodes(codes string) error {
+	_, err := parseExitCodes(codes)
+	return err
+}
+
+// parseExitCodes 解析逗号分隔的退出码列表
+func parseExitCodes(codes string) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(codes, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		code, err := strconv.Atoi(part)
+		if err != nil || code < 0 || code > 255 {
+			return nil, fmt.Errorf("invalid exit code %q", part)
+		}
+		set[code] = true
+	}
+	return set, nil
+}
+
+// ApplySuccessCriteria 按脚本配置的退出码判定执行状态，脚本未能执行时保持失败
+func ApplySuccessCriteria(script models.Script, result *ExecutionResult) {
+	if result.ExitCode < 0 {
+		return
+	}
+
+	success, _ := parseExitCodes(script.SuccessExitCodes)
+	if len(success) == 0 {
+		success = map[int]bool{0: true}
+	}
+	warning, _ := parseExitCodes(script.WarningExitCodes)
+
+	switch {
+	case success[result.ExitCode]:
+		result.Status = "success"
+		result.Error = ""
+	case warning[result.ExitCode]:
+		result.Status = "warning"
+		result.Error = fmt.Sprintf("Script exited with warning code %d", result.ExitCode)
+	default:
+		result.Status = "failed"
+		if result.Error == "" {
+			result.Error = fmt.Sprintf("Script exited with code %d", result.ExitCode)
+		}
+	}
+}
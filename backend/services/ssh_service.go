@@ -1,9 +1,12 @@
 package services
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -16,10 +19,18 @@ type SSHClient struct {
 }
 
 type ExecutionResult struct {
-	Host   string
-	Status string
-	Output string
-	Error  string
+	Host       string
+	Status     string // success, warning, failed
+	Output     string // stdout和stderr按输出顺序合并
+	Stdout     string
+	Stderr     string
+	ExitCode   int // 远程命令退出码，未能执行时为-1
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	DurationMs int64
+	LogID      int64 // 执行日志ID，用于分段读取完整输出
+	Truncated  bool  // 输出超过阈值时只返回截断内容
 }
 
 func NewSSHClient(host, username, password string) *SSHClient {
@@ -133,11 +144,14 @@ func ExecuteSSHCommand(host, username, password string, port int, script string)
 }
 
 // ExecuteScriptOnHost 在单个主机上执行脚本
-func ExecuteScriptOnHost(host, username, password string, port int, script string) ExecutionResult {
-	result := ExecutionResult{
-		Host:   host,
-		Status: "failed",
+func ExecuteScriptOnHost(host, username, password string, port int, script string) (result ExecutionResult) {
+	result = ExecutionResult{
+		Host:      host,
+		Status:    "failed",
+		ExitCode:  -1,
+		StartedAt: time.Now(),
 	}
+	defer result.finish()
 
 	// SSH配置
 	config := &ssh.ClientConfig{
@@ -166,15 +180,76 @@ func ExecuteScriptOnHost(host, username, password string, port int, script strin
 	}
 	defer session.Close()
 
-	// 执行脚本
-	output, err := session.CombinedOutput(script)
+	// 执行脚本，分别收集stdout和stderr
+	output := newOutputCollector()
+	session.Stdout = output.Stdout()
+	session.Stderr = output.Stderr()
+	err = session.Run(script)
+	result.Output, result.Stdout, result.Stderr = output.Strings()
+	result.ExitCode = ExitCodeOf(err)
 	if err != nil {
 		result.Error = fmt.Sprintf("Script execution failed: %v", err)
-		result.Output = string(output)
 		return result
 	}
 
 	result.Status = "success"
-	result.Output = string(output)
 	return result
 }
+
+// finish 记录结束时间和耗时
+func (r *ExecutionResult) finish() {
+	r.FinishedAt = time.Now()
+	r.DurationMs = r.FinishedAt.Sub(r.StartedAt).Milliseconds()
+}
+
+// ExitCodeOf 从执行错误中取出远程命令的退出码，连接或会话错误时返回-1
+func ExitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return exitErr.ExitStatus()
+	}
+	return -1
+}
+
+// outputCollector 分别收集stdout和stderr，同时保留按时间顺序合并的输出
+type outputCollector struct {
+	mu       sync.Mutex
+	combined bytes.Buffer
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+}
+
+func newOutputCollector() *outputCollector {
+	return &outputCollector{}
+}
+
+// Stdout 返回写入stdout的Writer
+func (o *outputCollector) Stdout() io.Writer {
+	return collectorWriter{o, &o.stdout}
+}
+
+// Stderr 返回写入stderr的Writer
+func (o *outputCollector) Stderr() io.Writer {
+	return collectorWriter{o, &o.stderr}
+}
+
+// Strings 返回合并输出、stdout和stderr
+func (o *outputCollector) Strings() (string, string, string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.combined.String(), o.stdout.String(), o.stderr.String()
+}
+
+type collectorWriter struct {
+	o   *outputCollector
+	buf *bytes.Buffer
+}
+
+func (w collectorWriter) Write(p []byte) (int, error) {
+	w.o.mu.Lock()
+	defer w.o.mu.Unlock()
+	w.o.combined.Write(p)
+	return w.buf.Write(p)
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"runme-backend/database"
+	"runme-backend/models"
+	"strings"
+)
+
+// TargetSelector 执行目标选择条件
+type TargetSelector struct {
+	HostIDs      []int  `json:"host_ids"`       // 指定主机ID
+	HostGroupIDs []int  `json:"host_group_ids"` // 指定多个主机组
+	Selector     string `json:"selector"`       // 标签选择器，例如 env=prod,role!=db,web
+}
+
+// TargetHost 目标主机摘要（不包含认证信息），用于dry-run展示
+type TargetHost struct {
+	ID          int    `json:"id"`
+	IP          string `json:"ip"`
+	Port        int    `json:"port"`
+	Hostname    string `json:"hostname"`
+	HostGroupID int    `json:"host_group_id"`
+	Tags        string `json:"tags"`
+}
+
+// selectorTerm 标签选择器中的单个条件
+type selectorTerm struct {
+	key   string
+	value string
+	op    string // =, !=, exists, !exists
+}
+
+// ResolveTargets 根据选择条件解析目标主机
+// 未指定主机和主机组时使用defaultGroupID，标签选择器在此基础上过滤
+func ResolveTargets(sel TargetSelector, defaultGroupID int) ([]models.Host, error) {
+	terms, err := parseSelector(sel.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []models.Host
+	seen := make(map[int]bool)
+	add := func(hosts []models.Host) {
+		for _, host := range hosts {
+			if !seen[host.ID] {
+				seen[host.ID] = true
+				candidates = append(candidates, host)
+			}
+		}
+	}
+
+	groupIDs := sel.HostGroupIDs
+	if len(sel.HostIDs) == 0 && len(groupIDs) == 0 {
+		groupIDs = []int{defaultGroupID}
+	}
+
+	for _, id := range sel.HostIDs {
+		host, err := LoadHostByID(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("host %d not found", id)
+			}
+			return nil, err
+		}
+		add([]models.Host{*host})
+	}
+	for _, groupID := range groupIDs {
+		hosts, err := LoadHostsByGroupID(groupID)
+		if err != nil {
+			return nil, err
+		}
+		add(hosts)
+	}
+
+	if len(terms) == 0 {
+		return candidates, nil
+	}
+
+	var targets []models.Host
+	for _, host := range candidates {
+		labels, err := hostLabels(host)
+		if err != nil {
+			return nil, err
+		}
+		if matchSelector(terms, labels) {
+			targets = append(targets, host)
+		}
+	}
+	return targets, nil
+}
+
+// LoadHostByID 根据ID获取主机（包含认证信息和元数据）
+func LoadHostByID(id int) (*models.Host, error) {
+	var host models.Host
+	err := database.DB.QueryRow(`
+		SELECT id, ip, port, username, password, become_password, host_group_id, hostname, tags
+		FROM hosts WHERE id = ?
+	`, id).Scan(&host.ID, &host.IP, &host.Port, &host.Username, &host.Password, &host.BecomePassword,
+		&host.HostGroupID, &host.Hostname, &host.Tags)
+	if err != nil {
+		return nil, err
+	}
+	return &host, nil
+}
+
+// SummarizeTargets 生成不含认证信息的目标主机列表
+func SummarizeTargets(hosts []models.Host) []TargetHost {
+	targets := make([]TargetHost, 0, len(hosts))
+	for _, host := range hosts {
+		targets = append(targets, TargetHost{
+			ID:          host.ID,
+			IP:          host.IP,
+			Port:        host.Port,
+			Hostname:    host.Hostname,
+			HostGroupID: host.HostGroupID,
+			Tags:        host.Tags,
+		})
+	}
+	return targets
+}
+
+// ValidateSelector 校验标签选择器语法
+func ValidateSelector(selector string) error {
+	_, err := parseSelector(selector)
+	return err
+}
+
+// parseSelector 解析标签选择器，多个条件以逗号分隔且需同时满足
+// 支持 key=value、key!=value、key（存在）、!key（不存在）
+func parseSelector(selector string) ([]selectorTerm, error) {
+	var terms []selectorTerm
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var term selectorTerm
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			term = selectorTerm{key: strings.TrimSpace(kv[0]), value: strings.TrimSpace(kv[1]), op: "!="}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			term = selectorTerm{key: strings.TrimSpace(kv[0]), value: strings.TrimSpace(kv[1]), op: "="}
+		case strings.HasPrefix(part, "!"):
+			term = selectorTerm{key: strings.TrimSpace(part[1:]), op: "!exists"}
+		default:
+			term = selectorTerm{key: part, op: "exists"}
+		}
+
+		if term.key == "" {
+			return nil, fmt.Errorf("invalid selector term %q", part)
+		}
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+// hostLabels 主机的标签集合：内置变量、主机组变量、主机变量以及主机标签
+// key=value形式的标签拆分为标签名和值，其余标签的值为空
+func hostLabels(host models.Host) (map[string]string, error) {
+	labels, err := BuildHostVariables(host)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range strings.Split(host.Tags, ",") {
+		key, value, _ := strings.Cut(tag, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "" {
+			continue
+		}
+		if _, ok := labels[key]; !ok {
+			labels[key] = value
+		}
+	}
+	return labels, nil
+}
+
+// matchSelector 判断标签集合是否满足所有条件
+func matchSelector(terms []selectorTerm, labels map[string]string) bool {
+	for _, term := range terms {
+		value, ok := labels[term.key]
+		switch term.op {
+		case "=":
+			if !ok || value != term.value {
+				return false
+			}
+		case "!=":
+			if ok && value == term.value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
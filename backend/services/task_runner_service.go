@@ -0,0 +1,167 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"runme-backend/database"
+	"strings"
+)
+
+// 定时任务和工作流步骤支持的目标类型
+const (
+	TargetTypeScript         = "script"
+	TargetTypeAnsible        = "ansible"
+	TargetTypeDockerTemplate = "docker_template"
+	TargetTypeDeployment     = "deployment"
+)
+
+// DockerTemplateRunRequest Docker模板执行请求
+type DockerTemplateRunRequest struct {
+	HostID        int    `json:"host_id"`
+	DockerCommand string `json:"docker_command"` // 为空时使用模板中保存的命令
+}
+
+// ValidateTarget 校验目标类型、目标是否存在以及执行请求（payload为JSON，可为空）
+func ValidateTarget(targetType string, targetID int, payload string) error {
+	var table string
+	switch targetType {
+	case TargetTypeScript:
+		table = "scripts"
+		var req ScriptRunRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return err
+		}
+		if err := ValidateSelector(req.Selector); err != nil {
+			return err
+		}
+	case TargetTypeAnsible:
+		table = "ansible_playbooks"
+		var opts AnsibleRunOptions
+		if err := decodePayload(payload, &opts); err != nil {
+			return err
+		}
+		if err := ValidateAnsibleRunOptions(opts); err != nil {
+			return err
+		}
+	case TargetTypeDockerTemplate:
+		table = "docker_templates"
+		var req DockerTemplateRunRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return err
+		}
+		if _, err := LoadHostByID(req.HostID); err != nil {
+			return fmt.Errorf("payload host_id %d not found", req.HostID)
+		}
+	case TargetTypeDeployment:
+		table = "deployment_tasks"
+	default:
+		return fmt.Errorf("unsupported target type %q", targetType)
+	}
+
+	var exists bool
+	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM "+table+" WHERE id = ?)", targetID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%s %d not found", targetType, targetID)
+	}
+	return nil
+}
+
+// RunTarget 同步执行目标并返回状态（success, warning, failed, skipped）和结果说明
+func RunTarget(targetType string, targetID int, payload string) (string, string) {
+	switch targetType {
+	case TargetTypeScript:
+		var req ScriptRunRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return "failed", err.Error()
+		}
+		result, err := ExecuteScriptByID(targetID, req)
+		if err != nil {
+			return "failed", err.Error()
+		}
+		succeeded, warnings := 0, 0
+		for _, r := range result.Results {
+			switch r.Status {
+			case "success":
+				succeeded++
+			case "warning":
+				warnings++
+			}
+		}
+		message := fmt.Sprintf("Session %s: %d/%d hosts succeeded", result.SessionName, succeeded, len(result.Results))
+		if warnings > 0 {
+			message += fmt.Sprintf(", %d with warnings", warnings)
+		}
+		if succeeded+warnings != len(result.Results) {
+			return "failed", message
+		}
+		if warnings > 0 {
+			return "warning", message
+		}
+		return "success", message
+
+	case TargetTypeAnsible:
+		var opts AnsibleRunOptions
+		if err := decodePayload(payload, &opts); err != nil {
+			return "failed", err.Error()
+		}
+		run, err := ExecuteAnsiblePlaybookByID(targetID, opts)
+		if run == nil {
+			return "failed", err.Error()
+		}
+		if err != nil {
+			return "failed", fmt.Sprintf("Session %s: %v", run.SessionName, err)
+		}
+		return "success", fmt.Sprintf("Session %s completed", run.SessionName)
+
+	case TargetTypeDockerTemplate:
+		var req DockerTemplateRunRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return "failed", err.Error()
+		}
+		template, err := LoadDockerTemplate(targetID)
+		if err != nil {
+			return "failed", fmt.Sprintf("Docker template %d not found: %v", targetID, err)
+		}
+		host, err := LoadHostByID(req.HostID)
+		if err != nil {
+			return "failed", fmt.Sprintf("Host %d not found: %v", req.HostID, err)
+		}
+		run, err := RunDockerTemplate(*template, *host, req.DockerCommand)
+		if err != nil {
+			return "failed", err.Error()
+		}
+		if run.Error != "" {
+			return "failed", run.Error
+		}
+		return "success", fmt.Sprintf("Command executed on %s", host.IP)
+
+	case TargetTypeDeployment:
+		task, err := ExecuteDeploymentTaskByID(targetID)
+		if err != nil {
+			if _, ok := err.(*InvalidRequestError); ok {
+				return "skipped", err.Error()
+			}
+			return "failed", err.Error()
+		}
+		if task.Status == "awaiting_approval" {
+			return "warning", "Canary deployed, awaiting approval"
+		}
+		return task.Status, fmt.Sprintf("Deployment finished with status %s", task.Status)
+	}
+
+	return "failed", fmt.Sprintf("Unsupported target type %q", targetType)
+}
+
+// decodePayload 解析执行请求，payload为空时保持零值
+func decodePayload(payload string, v interface{}) error {
+	if strings.TrimSpace(payload) == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(payload), v); err != nil {
+		return fmt.Errorf("invalid payload: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"runme-backend/database"
+	"runme-backend/models"
+	"strings"
+	"time"
+)
+
+// 步骤执行条件，根据依赖步骤的结果决定是否执行
+const (
+	StepConditionOnSuccess = "on_success" // 依赖步骤成功（含警告）或跳过，且至少一个成功
+	StepConditionOnFailure = "on_failure" // 任一依赖步骤失败
+	StepConditionAlways    = "always"     // 依赖步骤结束即执行
+)
+
+// ParseWorkflowSteps 解析数据库中保存的步骤定义
+func ParseWorkflowSteps(raw string) ([]models.WorkflowStep, error) {
+	var steps []models.WorkflowStep
+	if strings.TrimSpace(raw) == "" {
+		return steps, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &steps); err != nil {
+		return nil, fmt.Errorf("invalid workflow steps: %v", err)
+	}
+	return steps, nil
+}
+
+// NormalizeWorkflowSteps 补全步骤默认值并校验步骤定义
+// 未指定key时按顺序生成，未指定depends_on时依赖上一个步骤（即顺序执行），depends_on为[]表示没有依赖
+func NormalizeWorkflowSteps(steps []models.WorkflowStep) ([]models.WorkflowStep, error) {
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("workflow must have at least one step")
+	}
+
+	keys := make(map[string]bool)
+	for i := range steps {
+		step := &steps[i]
+		step.Key = strings.TrimSpace(step.Key)
+		if step.Key == "" {
+			step.Key = fmt.Sprintf("step%d", i+1)
+		}
+		if keys[step.Key] {
+			return nil, fmt.Errorf("duplicate step key %q", step.Key)
+		}
+		keys[step.Key] = true
+
+		if step.Name == "" {
+			step.Name = step.Key
+		}
+		if step.DependsOn == nil {
+			step.DependsOn = []string{}
+			if i > 0 {
+				step.DependsOn = []string{steps[i-1].Key}
+			}
+		}
+		switch step.Condition {
+		case "":
+			step.Condition = StepConditionOnSuccess
+		case StepConditionOnSuccess, StepConditionOnFailure, StepConditionAlways:
+		default:
+			return nil, fmt.Errorf("step %q: unsupported condition %q", step.Key, step.Condition)
+		}
+		if err := ValidateTarget(step.Type, step.TargetID, string(step.Payload)); err != nil {
+			return nil, fmt.Errorf("step %q: %v", step.Key, err)
+		}
+	}
+
+	for _, step := range steps {
+		for _, dep := range step.DependsOn {
+			if dep == step.Key {
+				return nil, fmt.Errorf("step %q depends on itself", step.Key)
+			}
+			if !keys[dep] {
+				return nil, fmt.Errorf("step %q depends on unknown step %q", step.Key, dep)
+			}
+		}
+	}
+
+	if err := checkWorkflowCycles(steps); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// checkWorkflowCycles 按依赖关系逐层展开步骤，存在循环依赖时返回错误
+func checkWorkflowCycles(steps []models.WorkflowStep) error {
+	done := make(map[string]bool)
+	remaining := steps
+	for len(remaining) > 0 {
+		var next []models.WorkflowStep
+		var ready []models.WorkflowStep
+		for _, step := range remaining {
+			if dependenciesDone(step, done) {
+				ready = append(ready, step)
+			} else {
+				next = append(next, step)
+			}
+		}
+		if len(ready) == 0 {
+			var cyclic []string
+			for _, step := range remaining {
+				cyclic = append(cyclic, step.Key)
+			}
+			return fmt.Errorf("circular dependency between steps: %s", strings.Join(cyclic, ", "))
+		}
+		for _, step := range ready {
+			done[step.Key] = true
+		}
+		remaining = next
+	}
+	return nil
+}
+
+// dependenciesDone 判断步骤的所有依赖是否都已结束
+func dependenciesDone(step models.WorkflowStep, done map[string]bool) bool {
+	for _, dep := range step.DependsOn {
+		if !done[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadWorkflow 根据ID获取工作流
+func LoadWorkflow(id int) (*models.Workflow, error) {
+	var wf models.Workflow
+	var steps string
+	err := database.DB.QueryRow(`
+		SELECT id, name, description, steps, created_at, updated_at FROM workflows WHERE id = ?
+	`, id).Scan(&wf.ID, &wf.Name, &wf.Description, &steps, &wf.CreatedAt, &wf.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	wf.Steps, err = ParseWorkflowSteps(steps)
+	if err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+// StartWorkflowRun 创建运行记录并在后台执行工作流
+func StartWorkflowRun(wf models.Workflow, triggeredBy string) (int64, error) {
+	runID, err := createWorkflowRun(wf, triggeredBy)
+	if err != nil {
+		return 0, err
+	}
+
+	go executeWorkflowRun(runID, wf)
+	return runID, nil
+}
+
+// createWorkflowRun 创建运行记录，所有步骤初始为pending
+func createWorkflowRun(wf models.Workflow, triggeredBy string) (int64, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO workflow_runs (workflow_id, status, triggered_by, started_at) VALUES (?, 'running', ?, ?)",
+		wf.ID, triggeredBy, time.Now(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create workflow run: %v", err)
+	}
+	runID, _ := result.LastInsertId()
+
+	for _, step := range wf.Steps {
+		_, err := tx.Exec(`
+			INSERT INTO workflow_step_runs (run_id, step_key, step_name, step_type, target_id, status)
+			VALUES (?, ?, ?, ?, ?, 'pending')
+		`, runID, step.Key, step.Name, step.Type, step.TargetID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create workflow step run: %v", err)
+		}
+	}
+
+	return runID, tx.Commit()
+}
+
+// executeWorkflowRun 按依赖关系执行所有步骤，步骤的依赖全部结束后立即开始，互不依赖的步骤并行执行
+func executeWorkflowRun(runID int64, wf models.Workflow) {
+	type stepResult struct {
+		key    string
+		status string
+	}
+	states := make(map[string]string)
+	finished := make(map[string]bool)
+	results := make(chan stepResult)
+	pending := wf.Steps
+	running := 0
+
+	for {
+		// 启动依赖均已结束的步骤；条件不满足的步骤直接跳过，可能使其他步骤就绪，因此重复检查直到没有变化
+		for progressed := true; progressed; {
+			progressed = false
+			var waiting []models.WorkflowStep
+			for _, step := range pending {
+				if !dependenciesDone(step, finished) {
+					waiting = append(waiting, step)
+					continue
+				}
+				if !stepConditionMet(step, states) {
+					updateWorkflowStepRun(runID, step.Key, "skipped",
+						fmt.Sprintf("Condition %s not met", step.Condition), nil)
+					states[step.Key] = "skipped"
+					finished[step.Key] = true
+					progressed = true
+					continue
+				}
+				running++
+				go func(step models.WorkflowStep) {
+					results <- stepResult{key: step.Key, status: runWorkflowStep(runID, step)}
+				}(step)
+			}
+			pending = waiting
+		}
+		if running == 0 {
+			break
+		}
+
+		// 任一步骤结束后重新检查等待中的步骤
+		result := <-results
+		running--
+		states[result.key] = result.status
+		finished[result.key] = true
+	}
+
+	// 保存时已校验，正常不会出现
+	for _, step := range pending {
+		updateWorkflowStepRun(runID, step.Key, "skipped", "Unresolvable dependencies", nil)
+	}
+
+	status := "success"
+	for _, state := range states {
+		if state == "failed" {
+			status = "failed"
+			break
+		}
+	}
+
+	_, err := database.DB.Exec("UPDATE workflow_runs SET status = ?, finished_at = ? WHERE id = ?",
+		status, time.Now(), runID)
+	if err != nil {
+		log.Printf("Failed to update workflow run %d: %v", runID, err)
+	}
+	log.Printf("Workflow %s run %d finished with status %s", wf.Name, runID, status)
+}
+
+// runWorkflowStep 执行单个步骤并记录结果
+func runWorkflowStep(runID int64, step models.WorkflowStep) string {
+	startedAt := time.Now()
+	_, err := database.DB.Exec(
+		"UPDATE workflow_step_runs SET status = 'running', started_at = ? WHERE run_id = ? AND step_key = ?",
+		startedAt, runID, step.Key,
+	)
+	if err != nil {
+		log.Printf("Failed to update workflow step %s: %v", step.Key, err)
+	}
+
+	status, message := RunTarget(step.Type, step.TargetID, string(step.Payload))
+	if status != "success" && status != "warning" && status != "skipped" {
+		status = "failed"
+	}
+	updateWorkflowStepRun(runID, step.Key, status, message, &startedAt)
+	return status
+}
+
+// stepConditionMet 根据依赖步骤的状态判断步骤是否需要执行
+func stepConditionMet(step models.WorkflowStep, states map[string]string) bool {
+	switch step.Condition {
+	case StepConditionAlways:
+		return true
+	case StepConditionOnFailure:
+		for _, dep := range step.DependsOn {
+			if states[dep] == "failed" {
+				return true
+			}
+		}
+		return false
+	default:
+		// 以警告退出码结束的步骤视为成功；跳过的依赖（如未触发的on_failure分支）不阻止执行，
+		// 但至少需要一个依赖成功，避免失败导致的连锁跳过继续向下游执行
+		succeeded := len(step.DependsOn) == 0
+		for _, dep := range step.DependsOn {
+			switch states[dep] {
+			case "success", "warning":
+				succeeded = true
+			case "skipped":
+			default:
+				return false
+			}
+		}
+		return succeeded
+	}
+}
+
+// updateWorkflowStepRun 更新步骤运行状态
+func updateWorkflowStepRun(runID int64, key, status, message string, startedAt *time.Time) {
+	now := time.Now()
+	if startedAt == nil {
+		startedAt = &now
+	}
+	_, err := database.DB.Exec(`
+		UPDATE workflow_step_runs SET status = ?, message = ?, started_at = ?, finished_at = ?
+		WHERE run_id = ? AND step_key = ?
+	`, status, message, *startedAt, now, runID, key)
+	if err != nil {
+		log.Printf("Failed to update workflow step %s: %v", key, err)
+	}
+}
+
+// ListWorkflowRuns 获取工作流的运行记录
+func ListWorkflowRuns(workflowID int) ([]models.WorkflowRun, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, workflow_id, status, triggered_by, started_at, finished_at
+		FROM workflow_runs WHERE workflow_id = ? ORDER BY id DESC
+	`, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []models.WorkflowRun
+	for rows.Next() {
+		var run models.WorkflowRun
+		if err := rows.Scan(&run.ID, &run.WorkflowID, &run.Status, &run.TriggeredBy, &run.StartedAt, &run.FinishedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// GetWorkflowRun 获取单次运行记录及各步骤状态
+func GetWorkflowRun(workflowID, runID int) (*models.WorkflowRun, error) {
+	var run models.WorkflowRun
+	err := database.DB.QueryRow(`
+		SELECT id, workflow_id, status, triggered_by, started_at, finished_at
+		FROM workflow_runs WHERE id = ? AND workflow_id = ?
+	`, runID, workflowID).Scan(&run.ID, &run.WorkflowID, &run.Status, &run.TriggeredBy, &run.StartedAt, &run.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, run_id, step_key, step_name, step_type, target_id, status, message, started_at, finished_at
+		FROM workflow_step_runs WHERE run_id = ? ORDER BY id
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var step models.WorkflowStepRun
+		err := rows.Scan(&step.ID, &step.RunID, &step.StepKey, &step.StepName, &step.StepType, &step.TargetID,
+			&step.Status, &step.Message, &step.StartedAt, &step.FinishedAt)
+		if err != nil {
+			return nil, err
+		}
+		run.Steps = append(run.Steps, step)
+	}
+	return &run, nil
+}
+
+// DeleteWorkflowRuns 删除工作流的所有运行记录
+func DeleteWorkflowRuns(workflowID int) error {
+	_, err := database.DB.Exec(
+		"DELETE FROM workflow_step_runs WHERE run_id IN (SELECT id FROM workflow_runs WHERE workflow_id = ?)",
+		workflowID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = database.DB.Exec("DELETE FROM workflow_runs WHERE workflow_id = ?", workflowID)
+	return err
+}